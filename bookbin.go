@@ -0,0 +1,784 @@
+//////////////////////////////////////////////////////
+// bookbin.go
+// implements the binary book format, an alternative to the JSON book for large books
+// the file holds fixed size records sorted by Zobrist key and move, that are looked up
+// by binary search on demand, and an unsorted tail of records appended since the last
+// compaction, which is kept in memory
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// BINARY_BOOK_MAGIC identifies binary book files
+const BINARY_BOOK_MAGIC = "VBK1"
+
+// extension of binary book files, book files with other extensions are JSON
+const BINARY_BOOK_EXTENSION = ".vbk"
+
+// size of the file header : magic, number of sorted records
+const BINARY_BOOK_HEADER_SIZE = 16
+
+// size of a record : key, move code, payload
+// the record of move code 0 holds the packed position, the others a move entry
+const BINARY_BOOK_RECORD_SIZE = 48
+
+// largest game count of a move record, counts are stored in 3 bytes
+const BINARY_BOOK_MAX_COUNT = 1<<24 - 1
+
+// BinaryBook : an open binary book file
+type BinaryBook struct {
+	Path    string                       // file path
+	file    *os.File                     // file
+	sorted  int64                        // number of records in the sorted part
+	records int64                        // number of records in the file
+	tail    map[uint64]BookPositionEntry // entries of the appended records
+	mutex   sync.Mutex                   // protects the file
+}
+
+// BookStore : binary book backing Book, nil if the book is JSON
+var BookStore *BinaryBook
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// encodeBookMove : encodes a move in algebraic notation as from | to << 6 | promotion << 12
+// the code of a move is never 0
+// -> algeb string : move
+// <- uint16 : code
+// <- error : error
+
+func encodeBookMove(algeb string) (uint16, error) {
+	if ( len(algeb) != 4 ) && ( len(algeb) != 5 ) {
+		return 0, fmt.Errorf("invalid book move %s", algeb)
+	}
+	from, err := SquareFromString(algeb[0:2])
+	if err != nil {
+		return 0, err
+	}
+	to, err := SquareFromString(algeb[2:4])
+	if err != nil {
+		return 0, err
+	}
+	code := uint16(from) | uint16(to)<<6
+	if len(algeb) == 5 {
+		fig := symbolToFigure[rune(algeb[4])]
+		if fig == NoFigure {
+			return 0, fmt.Errorf("invalid book move %s", algeb)
+		}
+		code |= uint16(fig) << 12
+	}
+	return code, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// decodeBookMove : decodes a move code
+// -> code uint16 : code
+// <- string : move in algebraic notation
+
+func decodeBookMove(code uint16) string {
+	return Square(code&63).String() + Square(code>>6&63).String() + figureToSymbol[Figure(code>>12)]
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// packBookPosition : packs a position into a record payload
+// a piece per nibble, side to move, castling ability, en passant square, clocks
+// -> fen string : position
+// -> b []byte : payload
+// <- error : error
+
+func packBookPosition(fen string, b []byte) error {
+	pos, err := PositionFromFEN(fen)
+	if err != nil {
+		return err
+	}
+	for sq := SquareMinValue; sq <= SquareMaxValue; sq++ {
+		b[sq/2] |= byte(pos.Get(sq)) << ( 4 * ( sq % 2 ) )
+	}
+	b[32] = byte(pos.SideToMove)
+	b[33] = byte(pos.CastlingAbility())
+	b[34] = byte(pos.EnpassantSquare())
+	b[35] = byte(min(int32(pos.HalfmoveClock()), 255))
+	binary.BigEndian.PutUint16(b[36:], uint16(pos.FullmoveCounter()))
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// unpackBookPosition : unpacks a position from a record payload
+// -> b []byte : payload
+// <- string : fen
+
+func unpackBookPosition(b []byte) string {
+	pos := NewPosition()
+	for sq := SquareMinValue; sq <= SquareMaxValue; sq++ {
+		pos.Put(sq, Piece(b[sq/2]>>( 4 * ( sq % 2 ) )&15))
+	}
+	pos.SetSideToMove(Color(b[32]))
+	pos.SetCastlingAbility(Castle(b[33]))
+	pos.SetEnpassantSquare(Square(b[34]))
+	pos.SetHalfmoveClock(int(b[35]))
+	pos.SetFullmoveCounter(int(binary.BigEndian.Uint16(b[36:])))
+	return pos.String()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// encodeBookRecords : encodes a position entry as records, position record first, moves in code order
+// -> key uint64 : Zobrist key
+// -> posentry BookPositionEntry : position entry
+// <- []byte : records
+// <- error : error
+
+func encodeBookRecords(key uint64, posentry BookPositionEntry) ([]byte, error) {
+	codes := []int{}
+	algebs := map[uint16]string{}
+	for algeb := range posentry.MoveEntries {
+		code, err := encodeBookMove(algeb)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, int(code))
+		algebs[code] = algeb
+	}
+	sort.Ints(codes)
+	data := []byte{}
+	if posentry.Fen != "" {
+		record := make([]byte, BINARY_BOOK_RECORD_SIZE)
+		binary.BigEndian.PutUint64(record[0:], key)
+		if err := packBookPosition(posentry.Fen, record[10:]); err != nil {
+			return nil, err
+		}
+		data = append(data, record...)
+	}
+	for _, code := range codes {
+		mentry := posentry.MoveEntries[algebs[uint16(code)]]
+		record := make([]byte, BINARY_BOOK_RECORD_SIZE)
+		binary.BigEndian.PutUint64(record[0:], key)
+		binary.BigEndian.PutUint16(record[8:], uint16(code))
+		if mentry.HasEval {
+			record[10] = 1
+		}
+		record[11] = byte(mentry.BookVersion)
+		binary.BigEndian.PutUint32(record[12:], uint32(int32(mentry.Score)))
+		binary.BigEndian.PutUint16(record[16:], uint16(int16(mentry.Depth)))
+		binary.BigEndian.PutUint32(record[18:], uint32(int32(mentry.Eval)))
+		binary.BigEndian.PutUint32(record[22:], uint32(int32(mentry.Nodes)))
+		binary.BigEndian.PutUint32(record[26:], uint32(int32(mentry.Int1)))
+		binary.BigEndian.PutUint32(record[30:], uint32(int32(mentry.Int2)))
+		binary.BigEndian.PutUint32(record[34:], uint32(int32(mentry.Int3)))
+		putBookCount(record[38:], mentry.Wins)
+		putBookCount(record[41:], mentry.Draws)
+		putBookCount(record[44:], mentry.Losses)
+		data = append(data, record...)
+	}
+	return data, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// putBookCount : stores a game count in 3 bytes, counts above the maximum are saturated
+// -> b []byte : destination
+// -> count int : count
+
+func putBookCount(b []byte, count int) {
+	if count > BINARY_BOOK_MAX_COUNT {
+		count = BINARY_BOOK_MAX_COUNT
+	} else if count < 0 {
+		count = 0
+	}
+	b[0], b[1], b[2] = byte(count>>16), byte(count>>8), byte(count)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// bookCount : reads a game count stored in 3 bytes
+// -> b []byte : source
+// <- int : count
+
+func bookCount(b []byte) int {
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// decodeBookRecord : applies a record to a position entry
+// -> record []byte : record
+// -> posentry *BookPositionEntry : position entry
+// <- uint64 : Zobrist key
+
+func decodeBookRecord(record []byte, posentry *BookPositionEntry) uint64 {
+	if posentry.MoveEntries == nil {
+		posentry.MoveEntries = make(BookMoveEntries)
+	}
+	code := binary.BigEndian.Uint16(record[8:])
+	if code == 0 {
+		posentry.Fen = unpackBookPosition(record[10:])
+	} else {
+		algeb := decodeBookMove(code)
+		posentry.MoveEntries[algeb] = BookMoveEntry{
+			Algeb       : algeb,
+			HasEval     : record[10] == 1,
+			BookVersion : int(record[11]),
+			Score       : int(int32(binary.BigEndian.Uint32(record[12:]))),
+			Depth       : int(int16(binary.BigEndian.Uint16(record[16:]))),
+			Eval        : int(int32(binary.BigEndian.Uint32(record[18:]))),
+			Nodes       : int(int32(binary.BigEndian.Uint32(record[22:]))),
+			Int1        : int(int32(binary.BigEndian.Uint32(record[26:]))),
+			Int2        : int(int32(binary.BigEndian.Uint32(record[30:]))),
+			Int3        : int(int32(binary.BigEndian.Uint32(record[34:]))),
+			Wins        : bookCount(record[38:]),
+			Draws       : bookCount(record[41:]),
+			Losses      : bookCount(record[44:]),
+		}
+	}
+	return binary.BigEndian.Uint64(record[0:])
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// mergeBookEntry : merges the records of a position entry into another
+// -> posentry *BookPositionEntry : position entry to update
+// -> update BookPositionEntry : newer records
+
+func mergeBookEntry(posentry *BookPositionEntry, update BookPositionEntry) {
+	if posentry.MoveEntries == nil {
+		posentry.MoveEntries = make(BookMoveEntries)
+	}
+	if update.Fen != "" {
+		posentry.Fen = update.Fen
+	}
+	for algeb, mentry := range update.MoveEntries {
+		posentry.MoveEntries[algeb] = mentry
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// OpenBinaryBook : opens a binary book, the file is created if it does not exist
+// -> path string : file path
+// <- *BinaryBook : book
+// <- error : error
+
+func OpenBinaryBook(path string) (*BinaryBook, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	bb := &BinaryBook{Path: path, file: f, tail: map[uint64]BookPositionEntry{}}
+	if err := bb.init(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return bb, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// init : checks the header and reads the appended records
+// -> bb *BinaryBook : book
+// <- error : error
+
+func (bb *BinaryBook) init() error {
+	info, err := bb.file.Stat()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, BINARY_BOOK_HEADER_SIZE)
+	if info.Size() == 0 {
+		copy(header, BINARY_BOOK_MAGIC)
+		_, err := bb.file.WriteAt(header, 0)
+		return err
+	}
+	if _, err := bb.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if string(header[:4]) != BINARY_BOOK_MAGIC {
+		return fmt.Errorf("not a binary book")
+	}
+	size := info.Size() - BINARY_BOOK_HEADER_SIZE
+	if size%BINARY_BOOK_RECORD_SIZE != 0 {
+		// an interrupted append leaves a partial record, which is overwritten by the next append
+		size -= size % BINARY_BOOK_RECORD_SIZE
+	}
+	bb.records = size / BINARY_BOOK_RECORD_SIZE
+	bb.sorted = int64(binary.BigEndian.Uint64(header[8:]))
+	if bb.sorted > bb.records {
+		return fmt.Errorf("truncated binary book")
+	}
+	record := make([]byte, BINARY_BOOK_RECORD_SIZE)
+	for i := bb.sorted; i < bb.records; i++ {
+		if err := bb.readRecord(i, record); err != nil {
+			return err
+		}
+		posentry := BookPositionEntry{}
+		key := decodeBookRecord(record, &posentry)
+		tailentry := bb.tail[key]
+		mergeBookEntry(&tailentry, posentry)
+		bb.tail[key] = tailentry
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// readRecord : reads a record
+// -> bb *BinaryBook : book
+// -> i int64 : record index
+// -> record []byte : record read
+// <- error : error
+
+func (bb *BinaryBook) readRecord(i int64, record []byte) error {
+	_, err := bb.file.ReadAt(record, BINARY_BOOK_HEADER_SIZE+i*BINARY_BOOK_RECORD_SIZE)
+	return err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Close : closes the book
+// -> bb *BinaryBook : book
+// <- error : error
+
+func (bb *BinaryBook) Close() error {
+	bb.mutex.Lock()
+	defer bb.mutex.Unlock()
+	return bb.file.Close()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Lookup : looks up a position, the sorted part is binary searched
+// -> bb *BinaryBook : book
+// -> key uint64 : Zobrist key
+// <- BookPositionEntry : position entry
+// <- bool : true if the position is in the book
+
+func (bb *BinaryBook) Lookup(key uint64) (BookPositionEntry, bool) {
+	bb.mutex.Lock()
+	defer bb.mutex.Unlock()
+	record := make([]byte, BINARY_BOOK_RECORD_SIZE)
+	var err error
+	first := sort.Search(int(bb.sorted), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		err = bb.readRecord(int64(i), record)
+		return binary.BigEndian.Uint64(record) >= key
+	})
+	posentry := BookPositionEntry{MoveEntries: make(BookMoveEntries)}
+	found := false
+	for i := int64(first); ( err == nil ) && ( i < bb.sorted ); i++ {
+		if err = bb.readRecord(i, record); ( err != nil ) || ( binary.BigEndian.Uint64(record) != key ) {
+			break
+		}
+		decodeBookRecord(record, &posentry)
+		found = true
+	}
+	if tailentry, ok := bb.tail[key]; ok {
+		mergeBookEntry(&posentry, tailentry)
+		found = true
+	}
+	return posentry, found
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Append : appends records of a position entry to the end of the file
+// -> bb *BinaryBook : book
+// -> key uint64 : Zobrist key
+// -> posentry BookPositionEntry : records to append, Fen may be empty
+// <- error : error
+
+func (bb *BinaryBook) Append(key uint64, posentry BookPositionEntry) error {
+	data, err := encodeBookRecords(key, posentry)
+	if err != nil {
+		return err
+	}
+	bb.mutex.Lock()
+	defer bb.mutex.Unlock()
+	if _, err := bb.file.WriteAt(data, BINARY_BOOK_HEADER_SIZE+bb.records*BINARY_BOOK_RECORD_SIZE); err != nil {
+		return err
+	}
+	bb.records += int64(len(data) / BINARY_BOOK_RECORD_SIZE)
+	tailentry := bb.tail[key]
+	mergeBookEntry(&tailentry, posentry)
+	bb.tail[key] = tailentry
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Range : calls fn for all positions in key order
+// the sorted part is streamed, appended records are merged into it
+// -> bb *BinaryBook : book
+// -> replace map[uint64]BookPositionEntry : entries replacing those of the book, may be nil
+// -> fn func(key uint64, posentry BookPositionEntry) error : called for each position
+// <- error : error
+
+func (bb *BinaryBook) Range(replace map[uint64]BookPositionEntry, fn func(key uint64, posentry BookPositionEntry) error) error {
+	bb.mutex.Lock()
+	defer bb.mutex.Unlock()
+	return bb.rangeRecords(replace, fn)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// rangeRecords : Range without locking
+// -> bb *BinaryBook : book
+// -> replace map[uint64]BookPositionEntry : entries replacing those of the book, may be nil
+// -> fn func(key uint64, posentry BookPositionEntry) error : called for each position
+// <- error : error
+
+func (bb *BinaryBook) rangeRecords(replace map[uint64]BookPositionEntry, fn func(key uint64, posentry BookPositionEntry) error) error {
+	// keys of appended and replacing entries are emitted in order between the streamed keys
+	pending := []uint64{}
+	for key := range bb.tail {
+		pending = append(pending, key)
+	}
+	for key := range replace {
+		if _, ok := bb.tail[key]; !ok {
+			pending = append(pending, key)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i] < pending[j] })
+	emit := func(key uint64, posentry BookPositionEntry) error {
+		if replaced, ok := replace[key]; ok {
+			return fn(key, replaced)
+		}
+		if tailentry, ok := bb.tail[key]; ok {
+			mergeBookEntry(&posentry, tailentry)
+		}
+		return fn(key, posentry)
+	}
+	flush := func(limit uint64) error {
+		for ( len(pending) > 0 ) && ( pending[0] < limit ) {
+			if err := emit(pending[0], BookPositionEntry{MoveEntries: make(BookMoveEntries)}); err != nil {
+				return err
+			}
+			pending = pending[1:]
+		}
+		if ( len(pending) > 0 ) && ( pending[0] == limit ) {
+			// merged into the streamed position
+			pending = pending[1:]
+		}
+		return nil
+	}
+	reader := bufio.NewReader(io.NewSectionReader(bb.file, BINARY_BOOK_HEADER_SIZE, bb.sorted*BINARY_BOOK_RECORD_SIZE))
+	record := make([]byte, BINARY_BOOK_RECORD_SIZE)
+	current, posentry, started := uint64(0), BookPositionEntry{}, false
+	for i := int64(0); i < bb.sorted; i++ {
+		if _, err := io.ReadFull(reader, record); err != nil {
+			return err
+		}
+		key := binary.BigEndian.Uint64(record)
+		if !started || ( key != current ) {
+			if started {
+				if err := emit(current, posentry); err != nil {
+					return err
+				}
+			}
+			if err := flush(key); err != nil {
+				return err
+			}
+			current, posentry, started = key, BookPositionEntry{MoveEntries: make(BookMoveEntries)}, true
+		}
+		decodeBookRecord(record, &posentry)
+	}
+	if started {
+		if err := emit(current, posentry); err != nil {
+			return err
+		}
+	}
+	for _, key := range pending {
+		if err := emit(key, BookPositionEntry{MoveEntries: make(BookMoveEntries)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// writeBinaryBook : writes a sorted binary book through a temporary file
+// -> path string : file path
+// -> each func(emit func(key uint64, posentry BookPositionEntry) error) error : emits the positions in key order
+// <- error : error
+
+func writeBinaryBook(path string, each func(emit func(key uint64, posentry BookPositionEntry) error) error) error {
+	tmppath := path + ".tmp"
+	f, err := os.Create(tmppath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	header := make([]byte, BINARY_BOOK_HEADER_SIZE)
+	copy(header, BINARY_BOOK_MAGIC)
+	w.Write(header)
+	records := uint64(0)
+	err = each(func(key uint64, posentry BookPositionEntry) error {
+		data, err := encodeBookRecords(key, posentry)
+		if err != nil {
+			return fmt.Errorf("position %d: %v", key, err)
+		}
+		records += uint64(len(data) / BINARY_BOOK_RECORD_SIZE)
+		_, err = w.Write(data)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		binary.BigEndian.PutUint64(header[8:], records)
+		_, err = f.WriteAt(header, 0)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmppath)
+		return err
+	}
+	return os.Rename(tmppath, path)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// bookEntriesByKey : converts JSON book position entries to entries by Zobrist key
+// -> entries map[string]BookPositionEntry : entries by Zobrist key as string
+// <- map[uint64]BookPositionEntry : entries by Zobrist key
+// <- error : error
+
+func bookEntriesByKey(entries map[string]BookPositionEntry) (map[uint64]BookPositionEntry, error) {
+	bykey := make(map[uint64]BookPositionEntry, len(entries))
+	for zobriststr, posentry := range entries {
+		key, err := strconv.ParseUint(zobriststr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid book key %s", zobriststr)
+		}
+		bykey[key] = posentry
+	}
+	return bykey, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Compact : rewrites the book sorted, merging the appended records
+// -> bb *BinaryBook : book
+// -> path string : file path, the book's own file is replaced and reopened
+// -> replace map[uint64]BookPositionEntry : entries replacing those of the book, may be nil
+// <- error : error
+
+func (bb *BinaryBook) Compact(path string, replace map[uint64]BookPositionEntry) error {
+	bb.mutex.Lock()
+	defer bb.mutex.Unlock()
+	err := writeBinaryBook(path, func(emit func(key uint64, posentry BookPositionEntry) error) error {
+		return bb.rangeRecords(replace, emit)
+	})
+	if ( err != nil ) || ( path != bb.Path ) {
+		return err
+	}
+	bb.file.Close()
+	reopened, err := OpenBinaryBook(path)
+	if err != nil {
+		return err
+	}
+	bb.file, bb.sorted, bb.records, bb.tail = reopened.file, reopened.sorted, reopened.records, reopened.tail
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// WriteBinaryBook : writes a JSON book as binary book
+// -> path string : file path
+// -> book BookMainEntry : book
+// <- error : error
+
+func WriteBinaryBook(path string, book BookMainEntry) error {
+	bykey, err := bookEntriesByKey(book.PositionEntries)
+	if err != nil {
+		return err
+	}
+	keys := make([]uint64, 0, len(bykey))
+	for key := range bykey {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return writeBinaryBook(path, func(emit func(key uint64, posentry BookPositionEntry) error) error {
+		for _, key := range keys {
+			if err := emit(key, bykey[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadBinaryBook : reads a whole binary book as JSON book
+// -> path string : file path
+// <- BookMainEntry : book
+// <- error : error
+
+func ReadBinaryBook(path string) (BookMainEntry, error) {
+	book := BookMainEntry{PositionEntries: make(map[string]BookPositionEntry)}
+	bb, err := OpenBinaryBook(path)
+	if err != nil {
+		return book, err
+	}
+	defer bb.Close()
+	err = bb.Range(nil, func(key uint64, posentry BookPositionEntry) error {
+		book.PositionEntries[fmt.Sprintf("%d", key)] = posentry
+		return nil
+	})
+	return book, err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ConvertBookToBinary : converts a JSON book file to a binary book file
+// -> jsonpath string : JSON book file
+// -> binpath string : binary book file
+// <- int : number of positions
+// <- error : error
+
+func ConvertBookToBinary(jsonpath, binpath string) (int, error) {
+	jsonBlob, err := ioutil.ReadFile(jsonpath)
+	if err != nil {
+		return 0, err
+	}
+	book := BookMainEntry{}
+	if err := json.Unmarshal(jsonBlob, &book); err != nil {
+		return 0, fmt.Errorf("%s: %v", jsonpath, err)
+	}
+	return len(book.PositionEntries), WriteBinaryBook(binpath, book)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ConvertBookToJSON : converts a binary book file to a JSON book file
+// -> binpath string : binary book file
+// -> jsonpath string : JSON book file
+// <- int : number of positions
+// <- error : error
+
+func ConvertBookToJSON(binpath, jsonpath string) (int, error) {
+	book, err := ReadBinaryBook(binpath)
+	if err != nil {
+		return 0, err
+	}
+	b, err := json.Marshal(book)
+	if err != nil {
+		return 0, err
+	}
+	return len(book.PositionEntries), ioutil.WriteFile(jsonpath, b, 0644)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// RangeBook : calls fn for all positions of Book, including those only in BookStore
+// -> fn func(zobriststr string, posentry BookPositionEntry) : called for each position
+// <- error : error
+
+func RangeBook(fn func(zobriststr string, posentry BookPositionEntry)) error {
+	bookLock.RLock()
+	if BookStore == nil {
+		defer bookLock.RUnlock()
+		for zobriststr, posentry := range Book.PositionEntries {
+			fn(zobriststr, posentry)
+		}
+		return nil
+	}
+	replace, err := bookEntriesByKey(Book.PositionEntries)
+	bookLock.RUnlock()
+	if err != nil {
+		return err
+	}
+	return BookStore.Range(replace, func(key uint64, posentry BookPositionEntry) error {
+		fn(fmt.Sprintf("%d", key), posentry)
+		return nil
+	})
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// SaveBinaryBook : saves Book as binary book, including the positions only in BookStore
+// -> path string : file path
+// <- error : error
+
+func SaveBinaryBook(path string) error {
+	bookLock.Lock()
+	defer bookLock.Unlock()
+	if BookStore == nil {
+		return WriteBinaryBook(path, Book)
+	}
+	replace, err := bookEntriesByKey(Book.PositionEntries)
+	if err != nil {
+		return err
+	}
+	if err := BookStore.Compact(path, replace); err != nil {
+		return err
+	}
+	if path == BookStore.Path {
+		// the positions in memory are in the file now
+		Book.PositionEntries = make(map[string]BookPositionEntry)
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// CloseBookStore : closes the binary book backing Book, if any
+
+func CloseBookStore() {
+	if BookStore != nil {
+		BookStore.Close()
+		BookStore = nil
+	}
+}
+
+///////////////////////////////////////////////
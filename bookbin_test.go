@@ -0,0 +1,104 @@
+//////////////////////////////////////////////////////
+// bookbin_test.go
+// tests writing and reading binary books and storing minimaxed evals in them
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// binaryBookTestEntry : returns the start position entry of a test book
+// -> t *testing.T : test
+// <- *Position : start position
+// <- BookPositionEntry : position entry
+
+func binaryBookTestEntry(t *testing.T) (*Position, BookPositionEntry) {
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	pos, err := PositionFromFEN(START_FENS[VARIANT_Standard])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pos, BookPositionEntry{
+		Fen : pos.String(),
+		MoveEntries : BookMoveEntries{
+			"e2e4" : {Algeb: "e2e4", Score: 35, Depth: 20, BookVersion: 1, HasEval: true, Eval: 28, Nodes: 120, Int1: 1, Int2: 87, Wins: 1500, Draws: 2400, Losses: 1100},
+			"d2d4" : {Algeb: "d2d4", Score: -12, Depth: 18, Wins: 900, Draws: 1800, Losses: 700},
+			"b1c3" : {Algeb: "b1c3", Score: 5, Depth: 0, Losses: BINARY_BOOK_MAX_COUNT},
+		},
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestBinaryBookReadWrite : writes a book and reads it back
+
+func TestBinaryBookReadWrite(t *testing.T) {
+	pos, posentry := binaryBookTestEntry(t)
+	book := BookMainEntry{PositionEntries: map[string]BookPositionEntry{pos.ZobristStr(): posentry}}
+	path := filepath.Join(t.TempDir(), "book"+BINARY_BOOK_EXTENSION)
+	if err := WriteBinaryBook(path, book); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadBinaryBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, book) {
+		t.Errorf("read %+v, expected %+v", read, book)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestBinaryBookMinimaxEvals : minimaxed evals are appended to the binary book, not stored in memory
+
+func TestBinaryBookMinimaxEvals(t *testing.T) {
+	pos, posentry := binaryBookTestEntry(t)
+	path := filepath.Join(t.TempDir(), "book"+BINARY_BOOK_EXTENSION)
+	if err := WriteBinaryBook(path, BookMainEntry{PositionEntries: map[string]BookPositionEntry{pos.ZobristStr(): posentry}}); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenBinaryBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ClearBook()
+	BookStore = store
+	defer CloseBookStore()
+	minimaxed := BookPositionEntry{MoveEntries: posentry.MoveEntries.Copy()}
+	mentry := minimaxed.MoveEntries["d2d4"]
+	mentry.HasEval, mentry.Eval, mentry.Nodes = true, -20, 40
+	minimaxed.MoveEntries["d2d4"] = mentry
+	pos.StoreMinimaxEvals(minimaxed)
+	if len(Book.PositionEntries) != 0 {
+		t.Errorf("%d positions in memory, expected none", len(Book.PositionEntries))
+	}
+	// position and three moves sorted, the changed move appended
+	if store.records != 5 {
+		t.Errorf("%d records, expected 5", store.records)
+	}
+	// storing the same evals again appends nothing
+	pos.StoreMinimaxEvals(minimaxed)
+	if store.records != 5 {
+		t.Errorf("%d records after storing unchanged evals, expected 5", store.records)
+	}
+	read, found := pos.GetBookEntry()
+	if !found {
+		t.Fatal("position not found")
+	}
+	if !reflect.DeepEqual(read, BookPositionEntry{Fen: posentry.Fen, MoveEntries: minimaxed.MoveEntries}) {
+		t.Errorf("read %+v, expected %+v", read, minimaxed)
+	}
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,392 @@
+//////////////////////////////////////////////////////
+// bookbuild.go
+// implements building the book with a pool of private engines
+// workers walk down the book at random and add the best move not yet in the book at the end of the walk,
+// the book is minimaxed and checkpointed to disk periodically, an interrupted build resumes from the checkpoint
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// number of engines building the book in parallel
+var BookBuildThreads = 1
+
+// book moves added between two minimaxings of the book
+var BookBuildMinimaxEvery = 10
+
+// bookBuildProgress : progress of a build, saved next to the book as checkpoint
+type bookBuildProgress struct {
+	Root     string  `json:"root"`     // position the book is built from
+	Added    int     `json:"added"`    // book moves added
+	Tries    int     `json:"tries"`    // walks down the book
+	Nodes    uint64  `json:"nodes"`    // nodes searched
+	Seconds  float64 `json:"seconds"`  // building time of the finished runs
+	Frontier int     `json:"frontier"` // book moves leading to positions not in the book, at the last minimaxing
+}
+
+// bookBuildResult : result of a walk down the book
+type bookBuildResult struct {
+	added   bool   // a book move was added
+	skipped bool   // the walk ended in a position searched by another worker
+	nodes   uint64 // nodes searched
+}
+
+// pause of a worker whose walk ended in a position searched by another worker
+const BOOK_BUILD_SKIP_PAUSE = 10 * time.Millisecond
+
+// bookBuilder : state shared by the workers of a build
+type bookBuilder struct {
+	progress bookBuildProgress
+	start    time.Time             // start of the current run
+	pending  map[uint64]bool       // positions searched by a worker
+	searches map[*TimeControl]bool // searches in progress, stopped when the build stops
+	results  chan bookBuildResult  // results of the workers
+	stop     chan struct{}         // closed when the build stops
+	stopOnce sync.Once
+	finished chan struct{}         // closed when the workers are done and the book is saved
+	workers  sync.WaitGroup
+	lock     sync.Mutex
+}
+
+// running build, nil if none
+var bookBuild *bookBuilder
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// BookCheckpointFile : get the file of the book building checkpoint
+// <- string : file path
+
+func BookCheckpointFile() string {
+	return GetBookFile() + ".build"
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// IsBookBuilding : tells whether a book build has been started and not stopped
+// <- bool : true if building
+
+func IsBookBuilding() bool {
+	return bookBuild != nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// StartBuildBook : starts building the book from the current position with BookBuildThreads engines
+// -> resume bool : resume from the checkpoint of Book, including its root position
+// <- error : error
+
+func StartBuildBook(resume bool) error {
+	if bookBuild != nil {
+		return fmt.Errorf("book building is under way")
+	}
+	bb := &bookBuilder{
+		start    : time.Now(),
+		pending  : map[uint64]bool{},
+		searches : map[*TimeControl]bool{},
+		results  : make(chan bookBuildResult),
+		stop     : make(chan struct{}),
+		finished : make(chan struct{}),
+	}
+	bb.progress.Root = uci.Engine.Position.String()
+	if resume {
+		data, err := os.ReadFile(BookCheckpointFile())
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &bb.progress); err != nil {
+			return fmt.Errorf("%s: %v", BookCheckpointFile(), err)
+		}
+		if _, err := PositionFromFEN(bb.progress.Root); err != nil {
+			return err
+		}
+	}
+
+	threads := BookBuildThreads
+	if threads < 1 {
+		threads = 1
+	}
+	for i := 0; i < threads; i++ {
+		bb.workers.Add(1)
+		go bb.work(Rand.Int63())
+	}
+	go bb.run()
+	bookBuild = bb
+	fmt.Printf("book building started with %d threads\n", threads)
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// StopBuildBook : stops building the book, waits for the running searches and saves the book
+
+func StopBuildBook() {
+	if bookBuild == nil {
+		return
+	}
+	bookBuild.halt()
+	<-bookBuild.finished
+	bookBuild = nil
+	fmt.Printf("book building stopped\n")
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// halt : signals the workers to stop and stops the running searches
+// -> bb *bookBuilder : builder
+
+func (bb *bookBuilder) halt() {
+	bb.stopOnce.Do(func() {
+		close(bb.stop)
+	})
+	bb.lock.Lock()
+	defer bb.lock.Unlock()
+	for tc := range bb.searches {
+		tc.Stop()
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// stopped : tells whether the build is stopping
+// -> bb *bookBuilder : builder
+// <- bool : true if stopping
+
+func (bb *bookBuilder) stopped() bool {
+	select {
+	case <-bb.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// run : collects the results of the workers, minimaxes, reports and checkpoints the book
+// -> bb *bookBuilder : builder
+
+func (bb *bookBuilder) run() {
+	go func() {
+		bb.workers.Wait()
+		close(bb.results)
+	}()
+	tries, added := 0, 0
+	for res := range bb.results {
+		if res.skipped {
+			continue
+		}
+		tries++
+		bb.progress.Tries++
+		bb.progress.Nodes += res.nodes
+		if res.added {
+			added++
+			bb.progress.Added++
+			if ( added % BookBuildMinimaxEvery ) == 0 {
+				bb.minimax()
+				if ( MinimaxCnt % SaveBookAfterMinimaxCnt ) == 0 {
+					bb.checkpoint()
+				}
+			}
+		}
+		// if at least one node cannot be added per 1000 tries something is wrong, better to finish
+		if ( tries >= 1000 ) && ( ( added*1000 ) < tries ) && !bb.stopped() {
+			fmt.Printf("\n\nwarning: add move fails too much, book building auto stopped\n\n")
+			bb.halt()
+		}
+	}
+	bb.minimax()
+	bb.checkpoint()
+	close(bb.finished)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// minimax : minimaxes the book from the root and reports the progress
+// a copy of the root is minimaxed, the position of the engine may change meanwhile
+// -> bb *bookBuilder : builder
+
+func (bb *bookBuilder) minimax() {
+	pos, err := PositionFromFEN(bb.progress.Root)
+	if err != nil {
+		return
+	}
+	MinimaxCnt++
+	fmt.Println()
+	MinimaxOutVerbose(pos)
+	bb.progress.Frontier = MinimaxLeaves
+	fmt.Print(pos.BookMovesToPrintable())
+	seconds := bb.progress.Seconds + time.Since(bb.start).Seconds()
+	rate := 0.0
+	if seconds > 0 {
+		rate = float64(bb.progress.Added) * 3600 / seconds
+	}
+	fmt.Printf("book building : added %d tries %d nodes/hour %.0f frontier %d searched %d\n",
+		bb.progress.Added, bb.progress.Tries, rate, bb.progress.Frontier, bb.progress.Nodes)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// checkpoint : saves the book and the progress, the progress is only saved together with the book
+// nothing is saved if the book was not loaded from disk
+// -> bb *bookBuilder : builder
+
+func (bb *bookBuilder) checkpoint() {
+	if !BookLoaded || ( SaveBookAutoVerbose() != nil ) {
+		return
+	}
+	progress := bb.progress
+	progress.Seconds += time.Since(bb.start).Seconds()
+	data, _ := json.Marshal(progress)
+	if err := os.WriteFile(BookCheckpointFile(), data, 0644); err != nil {
+		fmt.Printf("saving checkpoint failed: %v\n", err)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// work : walks down the book and adds moves until the build stops
+// -> bb *bookBuilder : builder
+// -> seed int64 : seed of the walks
+
+func (bb *bookBuilder) work(seed int64) {
+	defer bb.workers.Done()
+	eng := NewEngine(nil, nil, Options{Private: true})
+	rnd := rand.New(rand.NewSource(seed))
+	for !bb.stopped() {
+		pos, err := PositionFromFEN(bb.progress.Root)
+		if err != nil {
+			return
+		}
+		res := bb.walk(eng, pos, rnd)
+		if res.skipped {
+			time.Sleep(BOOK_BUILD_SKIP_PAUSE)
+		}
+		bb.results <- res
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// walk : walks down the book at random and adds a move at the end of the walk
+// -> bb *bookBuilder : builder
+// -> eng *Engine : private engine
+// -> pos *Position : root position, changed by the walk
+// -> rnd *rand.Rand : random source
+// <- bookBuildResult : result
+
+func (bb *bookBuilder) walk(eng *Engine, pos *Position, rnd *rand.Rand) bookBuildResult {
+	for depth := 0; depth < MAX_BOOK_DEPTH; depth++ {
+		move, ok := WalkBookNode(pos, depth, rnd)
+		if !ok {
+			return bookBuildResult{}
+		}
+		if move == NullMove {
+			return bb.expand(eng, pos)
+		}
+		pos.DoMove(move)
+	}
+	return bookBuildResult{}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// expand : searches the best move not yet in the book and stores it
+// a position searched by another worker is skipped
+// -> bb *bookBuilder : builder
+// -> eng *Engine : private engine
+// -> pos *Position : position
+// <- bookBuildResult : result
+
+func (bb *bookBuilder) expand(eng *Engine, pos *Position) bookBuildResult {
+	if _, over := GameResult(pos); over {
+		return bookBuildResult{}
+	}
+	ignore := []Move{}
+	for _, mentry := range pos.GetSortedMoveEntryList() {
+		if ( mentry.Depth == 0 ) && ( mentry.Games() > 0 ) {
+			// imported from games and never searched, searched like a new move
+			continue
+		}
+		if m, err := pos.UCIToMove(mentry.Algeb); err == nil {
+			ignore = append(ignore, m)
+		}
+	}
+	if len(ignore) >= len(pos.GetLegalMoves(GET_ALL)) {
+		// if all moves were already searched, nothing to do
+		return bookBuildResult{}
+	}
+
+	key := pos.Zobrist()
+	tc := NewFixedDepthTimeControl(pos, int32(StoreMinDepth))
+	// started before it is registered, halt may stop it any time after
+	tc.Start(false)
+	bb.lock.Lock()
+	if bb.pending[key] || bb.stopped() {
+		bb.lock.Unlock()
+		return bookBuildResult{skipped: true}
+	}
+	bb.pending[key] = true
+	bb.searches[tc] = true
+	bb.lock.Unlock()
+	defer func() {
+		bb.lock.Lock()
+		delete(bb.pending, key)
+		delete(bb.searches, tc)
+		bb.lock.Unlock()
+	}()
+
+	eng.SetPosition(pos)
+	eng.history = newHistoryTable()
+	eng.hash().Clear()
+	pv := eng.Play(tc, ignore)
+	nodes := eng.Stats.Nodes
+	depth := int(eng.Stats.Depth)
+	if ( len(pv) == 0 ) || ( depth < StoreMinDepth ) {
+		// stopped before reaching the depth
+		return bookBuildResult{nodes: nodes}
+	}
+
+	algeb := pv[0].UCI()
+	mentry, found := pos.GetMoveEntry(algeb)
+	umentry := BookMoveEntry{
+		Algeb       : algeb,
+		Score       : int(eng.LastScore),
+		Depth       : depth,
+		BookVersion : BookVersion,
+		Wins        : mentry.Wins,
+		Draws       : mentry.Draws,
+		Losses      : mentry.Losses,
+	}
+	if found && !umentry.Supersedes(mentry) {
+		return bookBuildResult{nodes: nodes}
+	}
+	pos.StoreMoveEntry(algeb, umentry)
+	return bookBuildResult{added: true, nodes: nodes}
+}
+
+///////////////////////////////////////////////
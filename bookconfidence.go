@@ -0,0 +1,286 @@
+//////////////////////////////////////////////////////
+// bookconfidence.go
+// implements minimaxing the book with confidence
+// besides the eval every book move gets a confidence in percent, the leaves are trusted in proportion to
+// the depth of their search and to the games they were played in, inner moves gain confidence with the
+// size of the book below them, moves are chosen by eval less a penalty for the missing confidence
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"fmt"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// minimax the book with confidence, draw value and game statistics
+var BookConfidence = false
+
+// value of a draw in centipawns for the side to move at the root of the minimaxing
+var BookDrawValue = 0
+
+// weight in percent of the game statistics blended into the search score of a leaf, 0 uses the search score only
+var BookGameWeight = 0
+
+// search depth at which the score of a leaf is fully trusted
+var BookConfidenceDepth = 20
+
+// book positions below a move at which the missing confidence of its eval is halved
+var BookConfidenceNodes = 50
+
+// centipawns a move without confidence is worse than its eval when choosing book moves
+var BookUncertaintyPenalty = 50
+
+// moves of lower confidence in percent are under-explored
+var BookLowConfidence = 50
+
+// games of a move at which its statistics get half of BookGameWeight
+const BOOK_GAME_HALF_WEIGHT = 10
+
+// value of a won game in centipawns
+const BOOK_GAME_WIN_VALUE = 300
+
+// moves worse than the best move by more centipawns are not followed when looking for under-explored lines
+const BOOK_UNDER_EXPLORED_MARGIN = 50
+
+// bookMinimaxValue : minimaxed eval and confidence
+type bookMinimaxValue struct {
+	eval       int
+	confidence int
+}
+
+// side to move at the root of the minimaxing, the draw value is relative to it
+var bookMinimaxRoot = White
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Confidence : confidence of the eval of the move set by minimaxing with BookConfidence
+// -> mentry *BookMoveEntry : move entry
+// <- int : confidence in percent
+
+func (mentry *BookMoveEntry) Confidence() int {
+	if mentry.Int2 < 0 {
+		return 0
+	} else if mentry.Int2 > 100 {
+		return 100
+	}
+	return mentry.Int2
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ChoiceEval : eval the book moves are chosen by, with BookConfidence the eval less the uncertainty penalty
+// -> mentry *BookMoveEntry : move entry
+// <- int : eval
+
+func (mentry *BookMoveEntry) ChoiceEval() int {
+	if !BookConfidence {
+		return mentry.GetEval()
+	}
+	return mentry.GetEval() - BookUncertaintyPenalty*( 100 - mentry.Confidence() )/100
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// bookDrawValue : value of a draw for the side to move
+// -> color Color : side to move
+// <- int : centipawns
+
+func bookDrawValue(color Color) int {
+	if color == bookMinimaxRoot {
+		return BookDrawValue
+	}
+	return -BookDrawValue
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// leafValue : eval and confidence of a move leading out of the book
+// the search score is blended with the results of the games the move was played in
+// -> mentry *BookMoveEntry : move entry
+// -> color Color : side making the move
+// <- bookMinimaxValue : value
+
+func (mentry *BookMoveEntry) leafValue(color Color) bookMinimaxValue {
+	value := bookMinimaxValue{eval: mentry.Score}
+	if BookConfidenceDepth > 0 {
+		value.confidence = mentry.Depth * 100 / BookConfidenceDepth
+	}
+	if value.confidence > 100 {
+		value.confidence = 100
+	}
+	games := mentry.Games()
+	if ( BookGameWeight <= 0 ) || ( games == 0 ) {
+		return value
+	}
+	stats := ( ( mentry.Wins - mentry.Losses )*BOOK_GAME_WIN_VALUE + mentry.Draws*bookDrawValue(color) ) / games
+	gameconfidence := 100 * games / ( games + BOOK_GAME_HALF_WEIGHT )
+	weight := BookGameWeight * gameconfidence / 100
+	if mentry.Depth == 0 {
+		// imported from games and never searched
+		weight = 100
+	}
+	value.eval = ( value.eval*( 100 - weight ) + stats*weight ) / 100
+	if gameconfidence > value.confidence {
+		value.confidence = gameconfidence
+	}
+	return value
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// minimaxConfidenceRecursive : minimax out book with confidence wrt position recursively
+// the move with the best choice eval is backed up
+// -> pos *Position : position, restored on return
+// -> depth int : depth
+// -> line []uint64 : line in Zobrist keys
+// <- bookMinimaxValue : value of the best move
+// <- bool : false if the position has no book moves
+
+func minimaxConfidenceRecursive(pos *Position, depth int, line []uint64) (bookMinimaxValue, bool) {
+	MinimaxNodes++
+	if depth > MinimaxMaxDepth {
+		MinimaxMaxDepth = depth
+	}
+	if depth >= MAX_BOOK_DEPTH {
+		return bookMinimaxValue{}, false
+	}
+	zobrist := pos.Zobrist()
+	for _, z := range line {
+		if zobrist == z {
+			// a repetition is a sure draw
+			return bookMinimaxValue{eval: bookDrawValue(pos.SideToMove), confidence: 100}, true
+		}
+	}
+	pentry , found := pos.GetBookEntry()
+	if !found {
+		MinimaxLeaves++
+		return bookMinimaxValue{}, false
+	}
+	best, bestfound := bookMinimaxValue{}, false
+	bestchoice := 0
+	color := pos.SideToMove
+	// the move entries are copied, readers may hold the old ones
+	pentry.MoveEntries = pentry.MoveEntries.Copy()
+	for algeb , mentry := range pentry.MoveEntries {
+		move , err := pos.UCIToMove(algeb)
+		if err != nil {
+			continue
+		}
+		startnodes := MinimaxNodes
+		pos.DoMove(move)
+		child, childfound := minimaxConfidenceRecursive(pos, depth+1, append(line, zobrist))
+		pos.UndoMove()
+		nodes := MinimaxNodes - startnodes
+		if !childfound && mentry.Unscored() && ( BookGameWeight <= 0 ) {
+			// a leaf imported from games has no score and its games are not used
+			continue
+		}
+		value := mentry.leafValue(color)
+		if childfound {
+			value.eval = -child.eval
+			value.confidence = child.confidence + ( 100 - child.confidence )*nodes/( nodes + BookConfidenceNodes )
+		}
+		mentry.Eval = value.eval
+		mentry.HasEval = true
+		mentry.Nodes = nodes
+		mentry.Int2 = value.confidence
+		pentry.MoveEntries[algeb] = mentry
+		if choice := mentry.ChoiceEval(); !bestfound || ( choice > bestchoice ) {
+			best, bestfound, bestchoice = value, true, choice
+		}
+	}
+	pos.StoreMinimaxEvals(pentry)
+	return best, bestfound
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// MinimaxConfidence : minimax out book with confidence wrt position
+// -> pos *Position : position, restored on return
+// <- int : eval
+// <- int : confidence in percent
+
+func MinimaxConfidence(pos *Position) (int, int) {
+	MinimaxNodes = 0
+	MinimaxLeaves = 0
+	MinimaxMaxDepth = 0
+	bookMinimaxRoot = pos.SideToMove
+	value, _ := minimaxConfidenceRecursive(pos, 0, []uint64{})
+	return value.eval, value.confidence
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// UnderExploredLines : lists the book moves of low confidence on the lines the book may play from the position
+// the moves within BOOK_UNDER_EXPLORED_MARGIN of the best move are followed
+// -> pos *Position : position, restored on return
+// -> limit int : maximum number of lines
+// <- []string : lines in printable form, ending with the under-explored move
+
+func (pos *Position) UnderExploredLines(limit int) []string {
+	lines := []string{}
+	pos.underExploredLines([]Move{}, []uint64{}, limit, &lines)
+	return lines
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// underExploredLines : collects the under-explored lines below a position
+// -> pos *Position : position, restored on return
+// -> moves []Move : line leading to the position
+// -> keys []uint64 : Zobrist keys of the line
+// -> limit int : maximum number of lines
+// -> lines *[]string : collected lines
+
+func (pos *Position) underExploredLines(moves []Move, keys []uint64, limit int, lines *[]string) {
+	zobrist := pos.Zobrist()
+	for _, z := range keys {
+		if zobrist == z {
+			return
+		}
+	}
+	if len(moves) >= MAX_BOOK_DEPTH {
+		return
+	}
+	mentrylist := pos.GetSortedMoveEntryList()
+	if len(mentrylist) == 0 {
+		return
+	}
+	best := mentrylist[0].ChoiceEval()
+	for _, mentry := range mentrylist {
+		if ( len(*lines) >= limit ) || ( ( best - mentry.ChoiceEval() ) > BOOK_UNDER_EXPLORED_MARGIN ) {
+			return
+		}
+		move, err := pos.UCIToMove(mentry.Algeb)
+		if err != nil {
+			continue
+		}
+		if mentry.Confidence() < BookLowConfidence {
+			line := ""
+			for _, m := range moves {
+				line += m.UCI() + " "
+			}
+			*lines = append(*lines, fmt.Sprintf("%s%s eval %s confidence %d%%",
+				line, move.UCI(), SignedScore(mentry.GetEval()), mentry.Confidence()))
+			continue
+		}
+		pos.DoMove(move)
+		pos.underExploredLines(append(moves, move), append(keys, zobrist), limit, lines)
+		pos.UndoMove()
+	}
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,238 @@
+//////////////////////////////////////////////////////
+// bookconfidence_test.go
+// tests minimaxing the book with confidence: the confidence of shallow and deep leaves and its
+// propagation, the draw value of repetitions, blending game statistics and the under-explored lines
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"strings"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// bookConfidenceTestMove : a book move stored after a line from the start position
+type bookConfidenceTestMove struct {
+	line  string // moves leading to the position in UCI notation
+	algeb string // book move
+	score int    // search score from the point of view of the side making the move
+	depth int    // search depth, 0 if only imported from games
+	wins  int    // games won by the side making the move
+	draws int    // games drawn
+}
+
+// a shallow and a deep 0.00 at the root, the deep one has a shallow alternative, a move
+// below the margin and a line of moderate confidence
+var bookConfidenceTestMoves = []bookConfidenceTestMove{
+	{"", "e2e4", 0, 2, 0, 0},
+	{"", "d2d4", 0, 0, 0, 0},
+	{"", "a2a3", -100, 2, 0, 0},
+	{"", "c2c4", 0, 0, 0, 0},
+	{"d2d4", "d7d5", 0, 20, 0, 0},
+	{"d2d4", "g8f6", 0, 4, 0, 0},
+	{"c2c4", "e7e5", 0, 10, 0, 0},
+}
+
+// the knights return to the start position, with White to move, or to the position after
+// g1f3, with Black to move, the last move of each line repeats it
+var bookConfidenceTestRepetition = []bookConfidenceTestMove{
+	{"", "g1f3", 0, 20, 0, 0},
+	{"g1f3", "g8f6", 0, 20, 0, 0},
+	{"g1f3 g8f6", "f3g1", 0, 20, 0, 0},
+	{"g1f3 g8f6 f3g1", "f6g8", 0, 20, 0, 0},
+	{"g1f3 g8f6", "b1c3", 0, 20, 0, 0},
+	{"g1f3 g8f6 b1c3", "f6g8", 0, 20, 0, 0},
+	{"g1f3 g8f6 b1c3 f6g8", "c3b1", 0, 20, 0, 0},
+}
+
+// bookGameTestCase : a leaf with game statistics and its blended eval and confidence
+type bookGameTestCase struct {
+	name       string
+	weight     int           // BookGameWeight
+	mentry     BookMoveEntry // leaf, made by White at a root with White to move
+	eval       int           // blended eval
+	confidence int           // confidence in percent
+}
+
+var bookGameTestCases = []bookGameTestCase{
+	{"no weight", 0, BookMoveEntry{Score: 20, Depth: 20, Wins: 10}, 20, 100},
+	{"half confidence in 10 won games", 100, BookMoveEntry{Score: 0, Depth: 10, Wins: 10}, 150, 50},
+	{"quarter weight", 50, BookMoveEntry{Score: 0, Depth: 10, Wins: 10}, 75, 50},
+	{"draws at the draw value", 100, BookMoveEntry{Score: 0, Depth: 0, Draws: 30}, 30, 75},
+	{"unscored leaf from games only", 20, BookMoveEntry{Score: 0, Depth: 0, Wins: 6, Losses: 4}, 60, 50},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// bookConfidenceTestSetup : stores a book with confidence minimaxing turned on
+// the settings and the book are restored when the test finishes
+// -> t *testing.T : test
+// -> moves []bookConfidenceTestMove : moves of the book
+// <- *Position : start position
+
+func bookConfidenceTestSetup(t *testing.T, moves []bookConfidenceTestMove) *Position {
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	confidence, draw, weight, low := BookConfidence, BookDrawValue, BookGameWeight, BookLowConfidence
+	t.Cleanup(func() {
+		BookConfidence, BookDrawValue, BookGameWeight, BookLowConfidence = confidence, draw, weight, low
+		ClearBook()
+	})
+	BookConfidence, BookDrawValue, BookGameWeight, BookLowConfidence = true, 0, 0, 50
+	ClearBook()
+	for _, tm := range moves {
+		linepos := polyglotTestPosition(t, VARIANT_Standard, tm.line)
+		linepos.StoreMoveEntry(tm.algeb, BookMoveEntry{Algeb: tm.algeb, Score: tm.score, Depth: tm.depth,
+			BookVersion: BookVersion, Wins: tm.wins, Draws: tm.draws})
+	}
+	return pos
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// bookConfidenceTestEntry : returns a move entry of the book
+// -> t *testing.T : test
+// -> line string : moves leading to the position in UCI notation
+// -> algeb string : move
+// <- BookMoveEntry : move entry
+
+func bookConfidenceTestEntry(t *testing.T, line, algeb string) BookMoveEntry {
+	t.Helper()
+	mentry, found := polyglotTestPosition(t, VARIANT_Standard, line).GetMoveEntry(algeb)
+	if !found {
+		t.Fatalf("%s %s: no move entry", line, algeb)
+	}
+	return mentry
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestMinimaxConfidence : a shallow and a deep 0.00 differ in confidence and choice eval,
+// the confidence of an inner move grows with the book below it
+
+func TestMinimaxConfidence(t *testing.T) {
+	pos := bookConfidenceTestSetup(t, bookConfidenceTestMoves)
+	eval, confidence := MinimaxConfidence(pos)
+	if ( eval != 0 ) || ( confidence != 100 ) {
+		t.Errorf("root eval %d confidence %d, expected 0 and 100", eval, confidence)
+	}
+
+	shallow := bookConfidenceTestEntry(t, "", "e2e4")
+	deep := bookConfidenceTestEntry(t, "", "d2d4")
+	if ( shallow.GetEval() != 0 ) || ( deep.GetEval() != 0 ) {
+		t.Errorf("evals %d and %d, expected 0", shallow.GetEval(), deep.GetEval())
+	}
+	if ( shallow.Confidence() != 10 ) || ( deep.Confidence() != 100 ) {
+		t.Errorf("shallow confidence %d, deep %d, expected 10 and 100", shallow.Confidence(), deep.Confidence())
+	}
+	if shallow.ChoiceEval() != -BookUncertaintyPenalty*90/100 || deep.ChoiceEval() != 0 {
+		t.Errorf("shallow choice eval %d, deep %d", shallow.ChoiceEval(), deep.ChoiceEval())
+	}
+	if best := pos.GetSortedMoveEntryList()[0].Algeb; best != "d2d4" {
+		t.Errorf("best move %s, expected the deep d2d4", best)
+	}
+
+	// c2c4 backs up the 50% of e7e5 and gains with the positions below it
+	inner := bookConfidenceTestEntry(t, "", "c2c4")
+	expected := 50 + 50*inner.Nodes/( inner.Nodes + BookConfidenceNodes )
+	if ( inner.Nodes == 0 ) || ( inner.Confidence() != expected ) || ( inner.Confidence() <= 50 ) {
+		t.Errorf("inner confidence %d with %d nodes, expected %d", inner.Confidence(), inner.Nodes, expected)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestMinimaxConfidenceDrawValue : a repetition backs up the draw value of the root side with full confidence
+
+func TestMinimaxConfidenceDrawValue(t *testing.T) {
+	pos := bookConfidenceTestSetup(t, bookConfidenceTestRepetition)
+	BookDrawValue = 30
+	eval, confidence := MinimaxConfidence(pos)
+	if ( eval != 30 ) || ( confidence != 100 ) {
+		t.Errorf("root eval %d confidence %d, expected the draw value 30 and 100", eval, confidence)
+	}
+	// Black repeats with White to move, the draw is worth -30 to Black
+	repeat := bookConfidenceTestEntry(t, "g1f3 g8f6 f3g1", "f6g8")
+	if ( repeat.GetEval() != -30 ) || ( repeat.Confidence() != 100 ) {
+		t.Errorf("Black repeating: eval %d confidence %d, expected -30 and 100", repeat.GetEval(), repeat.Confidence())
+	}
+	// White repeats with Black to move, the draw is still worth 30 to White
+	repeat = bookConfidenceTestEntry(t, "g1f3 g8f6 b1c3 f6g8", "c3b1")
+	if ( repeat.GetEval() != 30 ) || ( repeat.Confidence() != 100 ) {
+		t.Errorf("White repeating: eval %d confidence %d, expected 30 and 100", repeat.GetEval(), repeat.Confidence())
+	}
+
+	BookDrawValue = -20
+	if eval, _ := MinimaxConfidence(pos); eval != -20 {
+		t.Errorf("root eval %d, expected the draw value -20", eval)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestBookGameBlending : the game statistics of a leaf are blended in with their own confidence
+
+func TestBookGameBlending(t *testing.T) {
+	bookConfidenceTestSetup(t, nil)
+	BookDrawValue = 30
+	bookMinimaxRoot = White
+	for _, tc := range bookGameTestCases {
+		BookGameWeight = tc.weight
+		value := tc.mentry.leafValue(White)
+		if ( value.eval != tc.eval ) || ( value.confidence != tc.confidence ) {
+			t.Errorf("%s: eval %d confidence %d, expected %d and %d", tc.name, value.eval, value.confidence, tc.eval, tc.confidence)
+		}
+	}
+
+	// without game weight a leaf known from games only is left out of the minimaxing
+	pos := bookConfidenceTestSetup(t, []bookConfidenceTestMove{
+		{"", "e2e4", 10, 20, 0, 0},
+		{"", "d2d4", 0, 0, 10, 0},
+	})
+	MinimaxConfidence(pos)
+	if mentry := bookConfidenceTestEntry(t, "", "d2d4"); mentry.HasEval {
+		t.Errorf("unscored leaf got eval %d", mentry.GetEval())
+	}
+	BookGameWeight = 100
+	if eval, _ := MinimaxConfidence(pos); eval != BOOK_GAME_WIN_VALUE {
+		t.Errorf("root eval %d, expected the won games of d2d4 %d", eval, BOOK_GAME_WIN_VALUE)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestUnderExploredLines : the low confidence moves within the margin of the best move are listed,
+// along the lines the book plays
+
+func TestUnderExploredLines(t *testing.T) {
+	pos := bookConfidenceTestSetup(t, bookConfidenceTestMoves)
+	MinimaxConfidence(pos)
+	lines := pos.UnderExploredLines(10)
+	expected := []string{"d2d4 g8f6 ", "e2e4 "}
+	if len(lines) != len(expected) {
+		t.Fatalf("lines %q, expected lines starting with %q", lines, expected)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, expected[i]) || !strings.Contains(line, "confidence") {
+			t.Errorf("line %q, expected it to start with %q", line, expected[i])
+		}
+	}
+	if lines := pos.UnderExploredLines(1); len(lines) != 1 {
+		t.Errorf("%d lines with a limit of 1", len(lines))
+	}
+	if fen := pos.String(); fen != START_FENS[VARIANT_Standard] {
+		t.Errorf("listing changed the position to %s", fen)
+	}
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,392 @@
+//////////////////////////////////////////////////////
+// bookpgn.go
+// implements importing PGN game collections into the book and exporting the book as PGN
+// the games of the current variant are replayed up to MAX_BOOK_DEPTH plies, the results are counted
+// for each move from the point of view of the side making it and added to the move entries
+// the export writes the book tree as one game, the best move is the main line and the others are variations
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// default rating filter of an import, 0 imports the moves of unrated players too
+const PGN_BOOK_MIN_ELO = 0
+
+// default occurrence filter of an import
+const PGN_BOOK_MIN_COUNT = 1
+
+// PGNBookImport : summary of an import
+type PGNBookImport struct {
+	Games     int // games read
+	Imported  int // games replayed into the book
+	Skipped   int // games of other variants or without result
+	Invalid   int // games with an illegal move, replayed up to the move
+	Moves     int // move entries stored
+	Positions int // positions with stored move entries
+}
+
+// pgnBookCounts : results of a move in the collection
+type pgnBookCounts struct {
+	wins, draws, losses int
+}
+
+// maximum length of an exported movetext line
+const PGN_LINE_LENGTH = 79
+
+// bookPGNWriter : state of a book export
+type bookPGNWriter struct {
+	out     *bufio.Writer   // output, the movetext is written line by line
+	text    string          // movetext line being filled
+	visited map[uint64]bool // positions whose moves are written
+	moves   int             // moves written
+}
+
+// pgnBookPosition : moves played in a position of the collection
+type pgnBookPosition struct {
+	fen   string
+	moves map[string]*pgnBookCounts
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Games : number of games the move was played in
+// -> mentry *BookMoveEntry : move entry
+// <- int : games
+
+func (mentry *BookMoveEntry) Games() int {
+	return mentry.Wins + mentry.Draws + mentry.Losses
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Unscored : tells whether the move was only imported from games, so that it has neither a search score nor an eval
+// -> mentry *BookMoveEntry : move entry
+// <- bool : true if unscored
+
+func (mentry *BookMoveEntry) Unscored() bool {
+	return ( mentry.Depth == 0 ) && ( mentry.Games() > 0 ) && !mentry.HasEval
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GameScore : score of the move in the games it was played in, from the point of view of the side making it
+// -> mentry *BookMoveEntry : move entry
+// <- float64 : score between 0 and 1, 0.5 if the move was never played
+// <- bool : true if the move was played in at least one game
+
+func (mentry *BookMoveEntry) GameScore() (float64, bool) {
+	games := mentry.Games()
+	if games == 0 {
+		return 0.5, false
+	}
+	return ( float64(mentry.Wins) + float64(mentry.Draws)/2 ) / float64(games), true
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// pgnPlayerElo : rating of a player of the game
+// -> g *PGNGame : game
+// -> color Color : color of the player
+// <- int : rating, 0 if unrated
+
+func pgnPlayerElo(g *PGNGame, color Color) int {
+	tag := "WhiteElo"
+	if color == Black {
+		tag = "BlackElo"
+	}
+	elo, err := strconv.Atoi(g.Tags[tag])
+	if err != nil {
+		return 0
+	}
+	return elo
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ImportPGNBook : imports the games of the current variant into the book
+// the moves of players rated below minelo are skipped, the moves played in fewer than
+// mincount games are not stored, the counts are added to those already in the book
+// the games are streamed, only the counts of the positions are kept in memory
+// -> r io.Reader : PGN
+// -> minelo int : smallest rating of the player making a move, 0 imports unrated players too
+// -> mincount int : smallest number of games a move is played in
+// <- PGNBookImport : summary
+// <- error : error
+
+func ImportPGNBook(r io.Reader, minelo, mincount int) (PGNBookImport, error) {
+	summary := PGNBookImport{}
+	positions := map[uint64]*pgnBookPosition{}
+	err := ScanPGN(r, func(game *PGNGame) error {
+		summary.Games++
+		variant, ok := game.Variant()
+		if !ok || ( variant != Variant ) {
+			summary.Skipped++
+			return nil
+		}
+		result, ok := PGNResultScore(game.Result)
+		if !ok {
+			summary.Skipped++
+			return nil
+		}
+		summary.Imported++
+		ply := 0
+		err := game.Replay(func(pos *Position, move Move) bool {
+			if ply >= MAX_BOOK_DEPTH {
+				return false
+			}
+			ply++
+			if pgnPlayerElo(game, pos.SideToMove) < minelo {
+				return true
+			}
+			key := pos.Zobrist()
+			bpos, found := positions[key]
+			if !found {
+				bpos = &pgnBookPosition{fen: pos.String(), moves: map[string]*pgnBookCounts{}}
+				positions[key] = bpos
+			}
+			algeb := move.UCI()
+			counts, found := bpos.moves[algeb]
+			if !found {
+				counts = &pgnBookCounts{}
+				bpos.moves[algeb] = counts
+			}
+			score := result
+			if pos.SideToMove == Black {
+				score = 1 - result
+			}
+			switch score {
+			case 1:
+				counts.wins++
+			case 0:
+				counts.losses++
+			default:
+				counts.draws++
+			}
+			return true
+		})
+		if err != nil {
+			summary.Invalid++
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	for _, bpos := range positions {
+		pos := (*Position)(nil)
+		for algeb, counts := range bpos.moves {
+			if ( counts.wins + counts.draws + counts.losses ) < mincount {
+				continue
+			}
+			if pos == nil {
+				pos, err = PositionFromFEN(bpos.fen)
+				if err != nil {
+					return summary, err
+				}
+				summary.Positions++
+			}
+			mentry, found := pos.GetMoveEntry(algeb)
+			if !found {
+				// not searched yet, the book builder searches it like a new move
+				mentry = BookMoveEntry{
+					Algeb       : algeb,
+					BookVersion : BookVersion,
+				}
+			}
+			mentry.Wins += counts.wins
+			mentry.Draws += counts.draws
+			mentry.Losses += counts.losses
+			pos.StoreMoveEntry(algeb, mentry)
+			summary.Moves++
+		}
+	}
+	return summary, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// String : summary in printable form
+// -> summary PGNBookImport : summary
+// <- string : summary printable
+
+func (summary PGNBookImport) String() string {
+	return fmt.Sprintf("games %d imported %d skipped %d invalid %d positions %d moves %d",
+		summary.Games, summary.Imported, summary.Skipped, summary.Invalid, summary.Positions, summary.Moves)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// PGNAnnotation : converts a move annotation to a NAG
+// -> annot int : annotation, positive for good moves and negative for bad ones
+// <- string : NAG, empty if the move is not annotated
+
+func PGNAnnotation(annot int) string {
+	switch {
+	case annot >= 2:
+		return "$3" // !!
+	case annot == 1:
+		return "$1" // !
+	case annot == -1:
+		return "$2" // ?
+	case annot <= -2:
+		return "$4" // ??
+	}
+	return ""
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// PGNComment : comment of an exported book move
+// -> mentry *BookMoveEntry : move entry
+// <- string : comment
+
+func (mentry *BookMoveEntry) PGNComment() string {
+	comment := fmt.Sprintf("score %s depth %d", SignedScore(mentry.Score), mentry.Depth)
+	if mentry.HasEval {
+		comment += fmt.Sprintf(" eval %s nodes %d", SignedScore(mentry.Eval), mentry.Nodes)
+	}
+	if mentry.Games() > 0 {
+		comment += fmt.Sprintf(" games +%d =%d -%d", mentry.Wins, mentry.Draws, mentry.Losses)
+	}
+	return "{" + comment + "}"
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ExportBookPGN : writes the book tree below the position as a PGN game with nested variations
+// the moves of a position reached again by transposition are written only once
+// -> w io.Writer : writer
+// -> pos *Position : root position, restored on return
+// <- int : number of moves written
+// <- error : error
+
+func ExportBookPGN(w io.Writer, pos *Position) (int, error) {
+	out := bufio.NewWriter(w)
+	tags := [][2]string{
+		{"Event", "Book"},
+		{"Site", "?"},
+		{"Date", "????.??.??"},
+		{"Round", "-"},
+		{"White", GetEngineName()},
+		{"Black", GetEngineName()},
+		{"Result", "*"},
+	}
+	if Variant != VARIANT_Standard {
+		tags = append(tags, [2]string{"Variant", VARIANT_TO_NAME[Variant]})
+	}
+	if fen := pos.String(); fen != START_FENS[Variant] {
+		tags = append(tags, [2]string{"SetUp", "1"}, [2]string{"FEN", fen})
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(out, "[%s \"%s\"]\n", tag[0], strings.Replace(tag[1], "\"", "\\\"", -1))
+	}
+	out.WriteString("\n")
+
+	bw := &bookPGNWriter{out: out, visited: map[uint64]bool{}}
+	bw.line(pos, 0)
+	bw.token("*")
+	out.WriteString(bw.text + "\n\n")
+	return bw.moves, out.Flush()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// token : appends a movetext token to the current line
+// the line is written out when the token does not fit on it anymore
+// -> bw *bookPGNWriter : writer
+// -> token string : token
+
+func (bw *bookPGNWriter) token(token string) {
+	if ( bw.text != "" ) && ( len(bw.text)+1+len(token) > PGN_LINE_LENGTH ) {
+		bw.out.WriteString(bw.text + "\n")
+		bw.text = ""
+	}
+	if ( bw.text != "" ) && !strings.HasSuffix(bw.text, "(") && ( token != ")" ) {
+		bw.text += " "
+	}
+	bw.text += token
+}
+
+///////////////////////////////////////////////
+// line : writes the book moves of a position, the best move is continued after the variations of the others
+// -> bw *bookPGNWriter : writer
+// -> pos *Position : position, restored on return
+// -> depth int : depth of the position
+
+func (bw *bookPGNWriter) line(pos *Position, depth int) {
+	key := pos.Zobrist()
+	if ( depth >= MAX_BOOK_DEPTH ) || bw.visited[key] {
+		return
+	}
+	bw.visited[key] = true
+	moves, mentries := []Move{}, []BookMoveEntry{}
+	for _, mentry := range pos.GetSortedMoveEntryList() {
+		if m, err := pos.UCIToMove(mentry.Algeb); err == nil {
+			moves = append(moves, m)
+			mentries = append(mentries, mentry)
+		}
+	}
+	if len(moves) == 0 {
+		return
+	}
+	bw.move(pos, moves[0], mentries[0])
+	for i := 1; i < len(moves); i++ {
+		bw.token("(")
+		bw.move(pos, moves[i], mentries[i])
+		pos.DoMove(moves[i])
+		bw.line(pos, depth+1)
+		pos.UndoMove()
+		bw.token(")")
+	}
+	pos.DoMove(moves[0])
+	bw.line(pos, depth+1)
+	pos.UndoMove()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// move : writes a book move with its number, annotation and comment
+// black moves are always numbered since every move is followed by a comment
+// -> bw *bookPGNWriter : writer
+// -> pos *Position : position
+// -> m Move : move
+// -> mentry BookMoveEntry : move entry
+
+func (bw *bookPGNWriter) move(pos *Position, m Move, mentry BookMoveEntry) {
+	number := fmt.Sprintf("%d.", pos.FullmoveCounter())
+	if pos.SideToMove == Black {
+		number += ".."
+	}
+	bw.token(number + pos.MoveToSAN(m))
+	if nag := PGNAnnotation(mentry.Int1); nag != "" {
+		bw.token(nag)
+	}
+	bw.token(mentry.PGNComment())
+	bw.moves++
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,247 @@
+//////////////////////////////////////////////////////
+// bookpgn_test.go
+// tests importing PGN game collections into the book and exporting a book tree as PGN movetext
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bytes"
+	"strings"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// bookPGNTestMove : a book move stored after a line from the start position
+type bookPGNTestMove struct {
+	line  string // moves leading to the position in UCI notation
+	algeb string // book move
+	score int    // score
+	annot int    // annotation
+}
+
+// a main line with a variation at each ply, the variation of White's first move has one of its own
+var bookPGNTestMoves = []bookPGNTestMove{
+	{"", "e2e4", 30, 1},
+	{"", "d2d4", 20, 0},
+	{"e2e4", "e7e5", -30, 0},
+	{"e2e4", "c7c5", -35, -2},
+	{"e2e4 e7e5", "g1f3", 30, 0},
+	{"d2d4", "d7d5", -20, 0},
+	{"d2d4", "g8f6", -25, 0},
+}
+
+// expected movetext, a comment follows every move so that Black's moves are always numbered
+const bookPGNTestMovetext = `1.e4 $1 {score +30 depth 20} (1.d4 {score +20 depth 20} 1...d5
+{score -20 depth 20} (1...Nf6 {score -25 depth 20})) 1...e5
+{score -30 depth 20} (1...c5 $4 {score -35 depth 20}) 2.Nf3
+{score +30 depth 20} *`
+
+// games of a collection to import, a rated game, a game of a rated player against an unrated one,
+// an unrated game, a game of another variant, an unfinished game, a game with an illegal
+// move and a rated game of 52 plies shuffling the knights, 4 plies longer than MAX_BOOK_DEPTH
+var bookPGNTestGames = `[Event "rated"]
+[WhiteElo "2400"]
+[BlackElo "2300"]
+
+1. e4 e5 2. Nf3 Nc6 1-0
+
+[Event "half rated"]
+[WhiteElo "2400"]
+
+1. e4 c5 0-1
+
+[Event "unrated"]
+
+1. d4 d5 1/2-1/2
+
+[Event "atomic"]
+[Variant "Atomic"]
+
+1. e4 e5 1-0
+
+[Event "unfinished"]
+[WhiteElo "2400"]
+[BlackElo "2400"]
+
+1. c4 e5 *
+
+[Event "illegal"]
+[WhiteElo "2500"]
+[BlackElo "2500"]
+
+1. e4 e5 2. Qh8 Nc6 1-0
+
+[Event "long"]
+[WhiteElo "2400"]
+[BlackElo "2400"]
+
+` + strings.Repeat("Nf3 Nf6 Ng1 Ng8 ", 13) + `1/2-1/2
+`
+
+// bookPGNImportTestCount : counts of a move expected after an import
+type bookPGNImportTestCount struct {
+	line   string // moves leading to the position in UCI notation
+	algeb  string // move
+	stored bool   // true if the move has an entry
+	wins   int    // games won by the side making the move
+	draws  int    // games drawn
+	losses int    // games lost by the side making the move
+}
+
+// bookPGNImportTestCase : filters of an import and the counts they give
+type bookPGNImportTestCase struct {
+	name     string                   // description
+	minelo   int                      // rating filter
+	mincount int                      // occurrence filter
+	counts   []bookPGNImportTestCount // expected counts
+}
+
+var bookPGNImportTestCases = []bookPGNImportTestCase{
+	{"all games", 0, 1, []bookPGNImportTestCount{
+		// White won twice and lost once with e4, the illegal game counts up to its illegal move
+		{"", "e2e4", true, 2, 0, 1},
+		{"e2e4", "e7e5", true, 0, 0, 2},
+		{"e2e4", "c7c5", true, 1, 0, 0},
+		{"e2e4 e7e5", "g1f3", true, 1, 0, 0},
+		{"", "d2d4", true, 0, 1, 0},
+		// the 13th return to the start position is past MAX_BOOK_DEPTH
+		{"", "g1f3", true, 0, 12, 0},
+		{"", "c2c4", false, 0, 0, 0},
+	}},
+	{"rated players only", 2350, 1, []bookPGNImportTestCount{
+		{"", "e2e4", true, 2, 0, 1},
+		// the 2300 player of the first game is filtered out, the 2500 player is not
+		{"e2e4", "e7e5", true, 0, 0, 1},
+		// the unrated player is filtered out
+		{"e2e4", "c7c5", false, 0, 0, 0},
+		{"e2e4 e7e5", "g1f3", true, 1, 0, 0},
+		{"", "d2d4", false, 0, 0, 0},
+		{"", "g1f3", true, 0, 12, 0},
+	}},
+	{"frequent moves only", 0, 2, []bookPGNImportTestCount{
+		{"", "e2e4", true, 2, 0, 1},
+		{"e2e4", "e7e5", true, 0, 0, 2},
+		{"e2e4", "c7c5", false, 0, 0, 0},
+		{"e2e4 e7e5", "g1f3", false, 0, 0, 0},
+		{"", "d2d4", false, 0, 0, 0},
+		{"", "g1f3", true, 0, 12, 0},
+	}},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPGNAnnotation : converts annotations to NAGs
+
+func TestPGNAnnotation(t *testing.T) {
+	for annot, nag := range map[int]string{3: "$3", 2: "$3", 1: "$1", 0: "", -1: "$2", -2: "$4", -3: "$4"} {
+		if got := PGNAnnotation(annot); got != nag {
+			t.Errorf("annotation %d: %q, expected %q", annot, got, nag)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestExportBookPGN : exports a small book and checks the nesting, numbering and NAGs of the movetext
+
+func TestExportBookPGN(t *testing.T) {
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	ClearBook()
+	defer ClearBook()
+	for _, tm := range bookPGNTestMoves {
+		pos := polyglotTestPosition(t, VARIANT_Standard, tm.line)
+		pos.StoreMoveEntry(tm.algeb, BookMoveEntry{Algeb: tm.algeb, Score: tm.score, Depth: 20, BookVersion: BookVersion, Int1: tm.annot})
+	}
+
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	var buf bytes.Buffer
+	moves, err := ExportBookPGN(&buf, pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moves != len(bookPGNTestMoves) {
+		t.Errorf("%d moves written, expected %d", moves, len(bookPGNTestMoves))
+	}
+	pgn := buf.String()
+	if !strings.HasPrefix(pgn, "[Event \"Book\"]\n") || strings.Contains(pgn, "[FEN ") {
+		t.Errorf("unexpected tags in\n%s", pgn)
+	}
+	parts := strings.SplitN(pgn, "\n\n", 2)
+	if ( len(parts) != 2 ) || ( strings.TrimSpace(parts[1]) != bookPGNTestMovetext ) {
+		t.Errorf("movetext\n%s\nexpected\n%s", strings.TrimSpace(parts[len(parts)-1]), bookPGNTestMovetext)
+	}
+	for _, line := range strings.Split(pgn, "\n") {
+		if len(line) > PGN_LINE_LENGTH {
+			t.Errorf("line longer than %d characters: %s", PGN_LINE_LENGTH, line)
+		}
+	}
+	if fen := pos.String(); fen != START_FENS[VARIANT_Standard] {
+		t.Errorf("export changed the position to %s", fen)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestImportPGNBook : imports a small collection with each filter and checks the counts of the moves
+// from the point of view of the side making them
+
+func TestImportPGNBook(t *testing.T) {
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	defer ClearBook()
+	for _, tc := range bookPGNImportTestCases {
+		ClearBook()
+		summary, err := ImportPGNBook(strings.NewReader(bookPGNTestGames), tc.minelo, tc.mincount)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if ( summary.Games != 7 ) || ( summary.Imported != 5 ) || ( summary.Skipped != 2 ) || ( summary.Invalid != 1 ) {
+			t.Errorf("%s: %s, expected games 7 imported 5 skipped 2 invalid 1", tc.name, summary)
+		}
+		for _, count := range tc.counts {
+			pos := polyglotTestPosition(t, VARIANT_Standard, count.line)
+			mentry, found := pos.GetMoveEntry(count.algeb)
+			name := tc.name + " " + count.line + " " + count.algeb
+			switch {
+				case found != count.stored:
+					t.Errorf("%s: stored %v, expected %v", name, found, count.stored)
+				case ( mentry.Wins != count.wins ) || ( mentry.Draws != count.draws ) || ( mentry.Losses != count.losses ):
+					t.Errorf("%s: +%d =%d -%d, expected +%d =%d -%d", name, mentry.Wins, mentry.Draws, mentry.Losses,
+						count.wins, count.draws, count.losses)
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestImportPGNBookAddsCounts : the counts are added to a searched move entry, which keeps its score
+
+func TestImportPGNBookAddsCounts(t *testing.T) {
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	ClearBook()
+	defer ClearBook()
+	pos.StoreMoveEntry("e2e4", BookMoveEntry{Algeb: "e2e4", Score: 30, Depth: 20, BookVersion: BookVersion, Wins: 1})
+	if _, err := ImportPGNBook(strings.NewReader(bookPGNTestGames), 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	mentry, found := pos.GetMoveEntry("e2e4")
+	if !found || ( mentry.Wins != 3 ) || ( mentry.Losses != 1 ) {
+		t.Errorf("got +%d =%d -%d, expected +3 =0 -1", mentry.Wins, mentry.Draws, mentry.Losses)
+	}
+	if ( mentry.Score != 30 ) || ( mentry.Depth != 20 ) {
+		t.Errorf("search result changed to score %d depth %d", mentry.Score, mentry.Depth)
+	}
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,329 @@
+//////////////////////////////////////////////////////
+// booktools.go
+// implements merging, comparing and pruning books
+// the books are handled as BookMainEntry values, the inputs are never modified, book files are
+// JSON or binary by extension
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// BookMergeStats : summary of a merge
+type BookMergeStats struct {
+	Positions int // positions added
+	Added     int // moves added
+	Replaced  int // moves replaced by a deeper or newer entry
+	Kept      int // moves kept against a shallower or older entry
+}
+
+// kinds of book differences
+const (
+	BOOK_DIFF_BEST_MOVE = iota // the best move changed
+	BOOK_DIFF_EVAL             // the eval of a move in both books changed
+)
+
+// BookDiffChange : a difference of a position in both books
+type BookDiffChange struct {
+	Kind   int    // BOOK_DIFF_BEST_MOVE or BOOK_DIFF_EVAL
+	Fen    string // position
+	AlgebA string // best move, or the move of the changed eval, in the first book
+	AlgebB string // best move, or the move of the changed eval, in the second book
+	EvalA  int    // eval in the first book
+	EvalB  int    // eval in the second book
+}
+
+// BookDiff : differences of two books
+type BookDiff struct {
+	OnlyA   int              // positions only in the first book
+	OnlyB   int              // positions only in the second book
+	Common  int              // positions in both books
+	Changes []BookDiffChange // changes of the common positions
+}
+
+// BookPruneStats : summary of a pruning
+type BookPruneStats struct {
+	Moves       int // moves removed
+	Positions   int // positions removed as left without moves
+	Unreachable int // positions removed as no longer reachable from the root
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Supersedes : tells whether a move entry replaces a stored one
+// a shallower entry only replaces the stored one if its book version is higher
+// -> mentry *BookMoveEntry : new move entry
+// -> stored BookMoveEntry : stored move entry
+// <- bool : true if mentry replaces stored
+
+func (mentry *BookMoveEntry) Supersedes(stored BookMoveEntry) bool {
+	return ( mentry.Depth >= stored.Depth ) || ( mentry.BookVersion > stored.BookVersion )
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadBookFile : reads a whole book file, binary if it has BINARY_BOOK_EXTENSION, JSON otherwise
+// -> path string : file path
+// <- BookMainEntry : book
+// <- error : error
+
+func ReadBookFile(path string) (BookMainEntry, error) {
+	if strings.HasSuffix(path, BINARY_BOOK_EXTENSION) {
+		return ReadBinaryBook(path)
+	}
+	book := BookMainEntry{}
+	jsonBlob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return book, err
+	}
+	if err := json.Unmarshal(jsonBlob, &book); err != nil {
+		return book, fmt.Errorf("%s: %v", path, err)
+	}
+	if book.PositionEntries == nil {
+		book.PositionEntries = make(map[string]BookPositionEntry)
+	}
+	return book, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// WriteBookFile : writes a whole book file, binary if it has BINARY_BOOK_EXTENSION, JSON otherwise
+// -> path string : file path
+// -> book BookMainEntry : book
+// <- error : error
+
+func WriteBookFile(path string, book BookMainEntry) error {
+	if strings.HasSuffix(path, BINARY_BOOK_EXTENSION) {
+		return WriteBinaryBook(path, book)
+	}
+	b, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// MergeBooks : merges two books, a move in both books is taken from the entry that supersedes the other
+// the game counts of the entry with more games are taken, an annotation is kept if the taken entry has none,
+// the evals are those of the books and become exact after minimaxing the merged book
+// -> a BookMainEntry : first book, wins ties
+// -> b BookMainEntry : second book
+// <- BookMainEntry : merged book
+// <- BookMergeStats : summary of merging b into a
+
+func MergeBooks(a, b BookMainEntry) (BookMainEntry, BookMergeStats) {
+	stats := BookMergeStats{}
+	merged := BookMainEntry{PositionEntries: make(map[string]BookPositionEntry, len(a.PositionEntries))}
+	for zobriststr, posentry := range a.PositionEntries {
+		posentry.MoveEntries = posentry.MoveEntries.Copy()
+		merged.PositionEntries[zobriststr] = posentry
+	}
+	for zobriststr, posentry := range b.PositionEntries {
+		current, found := merged.PositionEntries[zobriststr]
+		if !found {
+			posentry.MoveEntries = posentry.MoveEntries.Copy()
+			merged.PositionEntries[zobriststr] = posentry
+			stats.Positions++
+			stats.Added += len(posentry.MoveEntries)
+			continue
+		}
+		if current.Fen == "" {
+			current.Fen = posentry.Fen
+		}
+		for algeb, mentry := range posentry.MoveEntries {
+			stored, found := current.MoveEntries[algeb]
+			if !found {
+				current.MoveEntries[algeb] = mentry
+				stats.Added++
+				continue
+			}
+			taken, other := stored, mentry
+			replaced := ( ( mentry.Depth != stored.Depth ) || ( mentry.BookVersion != stored.BookVersion ) ) && mentry.Supersedes(stored)
+			if replaced {
+				taken, other = mentry, stored
+				stats.Replaced++
+			} else {
+				stats.Kept++
+			}
+			if other.Games() > taken.Games() {
+				taken.Wins, taken.Draws, taken.Losses = other.Wins, other.Draws, other.Losses
+			}
+			if taken.Int1 == 0 {
+				taken.Int1 = other.Int1
+			}
+			current.MoveEntries[algeb] = taken
+		}
+		merged.PositionEntries[zobriststr] = current
+	}
+	return merged, stats
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// DiffBooks : compares two books
+// for the positions in both books, the changes of the best move and the eval changes of the moves in both
+// books by at least threshold centipawns are listed, sorted by position, unchanged evals are never listed
+// -> a BookMainEntry : first book
+// -> b BookMainEntry : second book
+// -> threshold int : smallest eval change listed
+// <- BookDiff : differences
+
+func DiffBooks(a, b BookMainEntry, threshold int) BookDiff {
+	diff := BookDiff{Changes: []BookDiffChange{}}
+	for zobriststr := range b.PositionEntries {
+		if _, found := a.PositionEntries[zobriststr]; !found {
+			diff.OnlyB++
+		}
+	}
+	for zobriststr, posentrya := range a.PositionEntries {
+		posentryb, found := b.PositionEntries[zobriststr]
+		if !found {
+			diff.OnlyA++
+			continue
+		}
+		diff.Common++
+		fen := posentrya.Fen
+		if fen == "" {
+			fen = posentryb.Fen
+		}
+		lista := posentrya.GetSortedMoveEntryList()
+		listb := posentryb.GetSortedMoveEntryList()
+		if ( len(lista) > 0 ) && ( len(listb) > 0 ) && ( lista[0].Algeb != listb[0].Algeb ) {
+			diff.Changes = append(diff.Changes, BookDiffChange{
+				Kind   : BOOK_DIFF_BEST_MOVE,
+				Fen    : fen,
+				AlgebA : lista[0].Algeb,
+				AlgebB : listb[0].Algeb,
+				EvalA  : lista[0].GetEval(),
+				EvalB  : listb[0].GetEval(),
+			})
+		}
+		for _, mentrya := range lista {
+			mentryb, found := posentryb.MoveEntries[mentrya.Algeb]
+			if !found {
+				continue
+			}
+			delta := mentryb.GetEval() - mentrya.GetEval()
+			if ( delta != 0 ) && ( ( delta >= threshold ) || ( -delta >= threshold ) ) {
+				diff.Changes = append(diff.Changes, BookDiffChange{
+					Kind   : BOOK_DIFF_EVAL,
+					Fen    : fen,
+					AlgebA : mentrya.Algeb,
+					AlgebB : mentrya.Algeb,
+					EvalA  : mentrya.GetEval(),
+					EvalB  : mentryb.GetEval(),
+				})
+			}
+		}
+	}
+	sort.SliceStable(diff.Changes, func(i, j int) bool { return diff.Changes[i].Fen < diff.Changes[j].Fen })
+	return diff
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ToPrintable : change in printable form
+// -> change *BookDiffChange : change
+// <- string : change printable
+
+func (change *BookDiffChange) ToPrintable() string {
+	if change.Kind == BOOK_DIFF_BEST_MOVE {
+		return fmt.Sprintf("%s best %s %s -> %s %s", change.Fen,
+			change.AlgebA, SignedScore(change.EvalA), change.AlgebB, SignedScore(change.EvalB))
+	}
+	return fmt.Sprintf("%s eval %s %s -> %s ( %s )", change.Fen,
+		change.AlgebA, SignedScore(change.EvalA), SignedScore(change.EvalB), SignedScore(change.EvalB-change.EvalA))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// PruneBook : removes the moves searched below a depth, minimaxed with fewer nodes or evaluated
+// worse than the best eval of their position by more than a window, the positions left without moves
+// and the positions no longer reachable from the root by the moves kept
+// the first move of a position and the positively annotated moves are only removed by depth and nodes
+// -> book BookMainEntry : book
+// -> root *Position : root position of the book, restored on return
+// -> mindepth int : smallest search depth kept
+// -> minnodes int : smallest number of nodes kept
+// -> window int : largest distance in centipawns from the best move kept, negative keeps all
+// <- BookMainEntry : pruned book
+// <- BookPruneStats : summary
+
+func PruneBook(book BookMainEntry, root *Position, mindepth, minnodes, window int) (BookMainEntry, BookPruneStats) {
+	stats := BookPruneStats{}
+	kept := make(map[string]BookPositionEntry)
+	for zobriststr, posentry := range book.PositionEntries {
+		mentrylist := posentry.GetSortedMoveEntryList()
+		moveentries := make(BookMoveEntries)
+		// an annotation can sort a worse move first, the window is measured from the best eval
+		besteval := 0
+		for i, mentry := range mentrylist {
+			if ( i == 0 ) || ( mentry.ChoiceEval() > besteval ) {
+				besteval = mentry.ChoiceEval()
+			}
+		}
+		for i, mentry := range mentrylist {
+			keep := ( mentry.Depth >= mindepth ) && ( mentry.Nodes >= minnodes )
+			if keep && ( i > 0 ) && ( window >= 0 ) && ( mentry.Int1 <= 0 ) {
+				keep = ( besteval - mentry.ChoiceEval() ) <= window
+			}
+			if keep {
+				moveentries[mentry.Algeb] = mentry
+			} else {
+				stats.Moves++
+			}
+		}
+		if len(moveentries) == 0 {
+			stats.Positions++
+			continue
+		}
+		posentry.MoveEntries = moveentries
+		kept[zobriststr] = posentry
+	}
+
+	pruned := BookMainEntry{PositionEntries: make(map[string]BookPositionEntry)}
+	var walk func(pos *Position)
+	walk = func(pos *Position) {
+		zobriststr := pos.ZobristStr()
+		posentry, found := kept[zobriststr]
+		if !found {
+			return
+		}
+		if _, visited := pruned.PositionEntries[zobriststr]; visited {
+			return
+		}
+		pruned.PositionEntries[zobriststr] = posentry
+		for algeb := range posentry.MoveEntries {
+			if m, err := pos.UCIToMove(algeb); err == nil {
+				pos.DoMove(m)
+				walk(pos)
+				pos.UndoMove()
+			}
+		}
+	}
+	walk(root)
+	stats.Unreachable = len(kept) - len(pruned.PositionEntries)
+	return pruned, stats
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,230 @@
+//////////////////////////////////////////////////////
+// booktools_test.go
+// tests the supersede rule of merging, comparing books and the limits of pruning
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// bookSupersedeTestCase : a new move entry against a stored one
+type bookSupersedeTestCase struct {
+	name          string
+	depth         int  // depth of the new entry
+	version       int  // book version of the new entry
+	storedDepth   int  // depth of the stored entry
+	storedVersion int  // book version of the stored entry
+	supersedes    bool // the new entry supersedes the stored one
+	replaced      bool // merging replaces the stored entry
+}
+
+var bookSupersedeTestCases = []bookSupersedeTestCase{
+	{"equal depth and version", 20, 2, 20, 2, true, false},
+	{"deeper", 22, 2, 20, 2, true, true},
+	{"shallower", 18, 2, 20, 2, false, false},
+	{"shallower but newer version", 18, 3, 20, 2, true, true},
+	{"equal depth newer version", 20, 3, 20, 2, true, true},
+	{"deeper older version", 22, 1, 20, 2, true, true},
+	{"shallower older version", 18, 1, 20, 2, false, false},
+}
+
+// bookPruneTestCase : pruning a position with moves evaluated +50, +30, +10 and an annotated -40
+type bookPruneTestCase struct {
+	name     string
+	mindepth int
+	minnodes int
+	window   int
+	kept     string // moves kept, in the order of bookPruneTestMoves
+}
+
+// moves of the pruned position, the depth grows with the eval
+var bookPruneTestMoves = []BookMoveEntry{
+	{Algeb: "e2e4", Score: 50, Depth: 20, Nodes: 10},
+	{Algeb: "d2d4", Score: 30, Depth: 18, Nodes: 10},
+	{Algeb: "c2c4", Score: 10, Depth: 16, Nodes: 5},
+	{Algeb: "g1f3", Score: -40, Depth: 16, Nodes: 5, Int1: 1},
+}
+
+var bookPruneTestCases = []bookPruneTestCase{
+	{"nothing removed", 0, 0, -1, "e2e4 d2d4 c2c4 g1f3"},
+	{"window reaching the worst unannotated move", 0, 0, 40, "e2e4 d2d4 c2c4 g1f3"},
+	{"window one short of it", 0, 0, 39, "e2e4 d2d4 g1f3"},
+	{"window zero keeps the best and annotated moves", 0, 0, 0, "e2e4 g1f3"},
+	{"depth equal to the shallowest", 16, 0, -1, "e2e4 d2d4 c2c4 g1f3"},
+	{"depth one above the shallowest", 17, 0, -1, "e2e4 d2d4"},
+	{"depth removes the best move too", 21, 0, -1, ""},
+	{"nodes", 0, 6, -1, "e2e4 d2d4"},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// bookToolsTestBook : returns a book of one position
+// -> pos *Position : position
+// -> mentries ...BookMoveEntry : moves
+// <- BookMainEntry : book
+
+func bookToolsTestBook(pos *Position, mentries ...BookMoveEntry) BookMainEntry {
+	posentry := BookPositionEntry{Fen: pos.String(), MoveEntries: BookMoveEntries{}}
+	for _, mentry := range mentries {
+		posentry.MoveEntries[mentry.Algeb] = mentry
+	}
+	return BookMainEntry{PositionEntries: map[string]BookPositionEntry{pos.ZobristStr(): posentry}}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestBookSupersedes : checks the supersede rule and its use by merging, the first book wins ties
+
+func TestBookSupersedes(t *testing.T) {
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	for _, tc := range bookSupersedeTestCases {
+		mentry := BookMoveEntry{Algeb: "e2e4", Score: 40, Depth: tc.depth, BookVersion: tc.version}
+		stored := BookMoveEntry{Algeb: "e2e4", Score: 20, Depth: tc.storedDepth, BookVersion: tc.storedVersion}
+		if supersedes := mentry.Supersedes(stored); supersedes != tc.supersedes {
+			t.Errorf("%s: supersedes %v, expected %v", tc.name, supersedes, tc.supersedes)
+		}
+		merged, stats := MergeBooks(bookToolsTestBook(pos, stored), bookToolsTestBook(pos, mentry))
+		score := merged.PositionEntries[pos.ZobristStr()].MoveEntries["e2e4"].Score
+		if replaced := score == mentry.Score; ( replaced != tc.replaced ) || ( stats.Replaced+stats.Kept != 1 ) {
+			t.Errorf("%s: replaced %v, expected %v, stats %+v", tc.name, replaced, tc.replaced, stats)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestMergeBooks : merges the game counts, annotations and positions of two books
+
+func TestMergeBooks(t *testing.T) {
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	after := polyglotTestPosition(t, VARIANT_Standard, "e2e4")
+	a := bookToolsTestBook(pos,
+		BookMoveEntry{Algeb: "e2e4", Score: 30, Depth: 20, BookVersion: 2, Wins: 1},
+		BookMoveEntry{Algeb: "d2d4", Score: 20, Depth: 20, BookVersion: 2})
+	b := bookToolsTestBook(pos,
+		BookMoveEntry{Algeb: "e2e4", Score: 35, Depth: 22, BookVersion: 2, Int1: 1},
+		BookMoveEntry{Algeb: "c2c4", Score: 10, Depth: 20, BookVersion: 2, Wins: 3, Draws: 2})
+	b.PositionEntries[after.ZobristStr()] = bookToolsTestBook(after, BookMoveEntry{Algeb: "e7e5", Depth: 20}).PositionEntries[after.ZobristStr()]
+	a0 := len(a.PositionEntries[pos.ZobristStr()].MoveEntries)
+
+	merged, stats := MergeBooks(a, b)
+	expected := BookMergeStats{Positions: 1, Added: 2, Replaced: 1}
+	if stats != expected {
+		t.Errorf("stats %+v, expected %+v", stats, expected)
+	}
+	e4 := merged.PositionEntries[pos.ZobristStr()].MoveEntries["e2e4"]
+	if ( e4.Score != 35 ) || ( e4.Wins != 1 ) || ( e4.Int1 != 1 ) {
+		t.Errorf("e2e4 %+v, expected the deeper score with the game count of the first book", e4)
+	}
+	if n := len(merged.PositionEntries[pos.ZobristStr()].MoveEntries); n != 3 {
+		t.Errorf("%d moves, expected 3", n)
+	}
+	if n := len(a.PositionEntries[pos.ZobristStr()].MoveEntries); n != a0 {
+		t.Errorf("merging modified the first book")
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestDiffBooks : lists best move changes and eval changes from the threshold on
+
+func TestDiffBooks(t *testing.T) {
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	after := polyglotTestPosition(t, VARIANT_Standard, "e2e4")
+	a := bookToolsTestBook(pos,
+		BookMoveEntry{Algeb: "e2e4", Score: 30},
+		BookMoveEntry{Algeb: "d2d4", Score: 20},
+		BookMoveEntry{Algeb: "c2c4", Score: 10})
+	b := bookToolsTestBook(pos,
+		BookMoveEntry{Algeb: "e2e4", Score: 10},
+		BookMoveEntry{Algeb: "d2d4", Score: 21},
+		BookMoveEntry{Algeb: "c2c4", Score: 10})
+	a.PositionEntries[after.ZobristStr()] = bookToolsTestBook(after, BookMoveEntry{Algeb: "e7e5"}).PositionEntries[after.ZobristStr()]
+
+	for threshold, changes := range map[int]int{20: 2, 21: 1, 1: 3, 0: 3} {
+		diff := DiffBooks(a, b, threshold)
+		if ( diff.OnlyA != 1 ) || ( diff.OnlyB != 0 ) || ( diff.Common != 1 ) {
+			t.Errorf("threshold %d: only a %d only b %d common %d, expected 1 0 1", threshold, diff.OnlyA, diff.OnlyB, diff.Common)
+		}
+		if len(diff.Changes) != changes {
+			t.Errorf("threshold %d: %d changes, expected %d", threshold, len(diff.Changes), changes)
+			continue
+		}
+		best := diff.Changes[0]
+		if ( best.Kind != BOOK_DIFF_BEST_MOVE ) || ( best.AlgebA != "e2e4" ) || ( best.AlgebB != "d2d4" ) {
+			t.Errorf("threshold %d: first change %+v, expected the best move e2e4 -> d2d4", threshold, best)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPruneBook : prunes by depth, nodes and window at the edges of the limits
+
+func TestPruneBook(t *testing.T) {
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	book := bookToolsTestBook(pos, bookPruneTestMoves...)
+	for _, tc := range bookPruneTestCases {
+		pruned, stats := PruneBook(book, pos, tc.mindepth, tc.minnodes, tc.window)
+		kept := ""
+		posentry := pruned.PositionEntries[pos.ZobristStr()]
+		for _, mentry := range bookPruneTestMoves {
+			if _, found := posentry.MoveEntries[mentry.Algeb]; found {
+				if kept != "" {
+					kept += " "
+				}
+				kept += mentry.Algeb
+			}
+		}
+		if kept != tc.kept {
+			t.Errorf("%s: kept %q, expected %q", tc.name, kept, tc.kept)
+		}
+		if removed := len(bookPruneTestMoves) - len(posentry.MoveEntries); stats.Moves != removed {
+			t.Errorf("%s: %d moves counted, %d removed", tc.name, stats.Moves, removed)
+		}
+		if ( tc.kept == "" ) != ( stats.Positions == 1 ) {
+			t.Errorf("%s: %d positions removed", tc.name, stats.Positions)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPruneBookUnreachable : positions only reached by pruned moves are removed
+
+func TestPruneBookUnreachable(t *testing.T) {
+	pos := polyglotTestPosition(t, VARIANT_Standard, "")
+	book := bookToolsTestBook(pos,
+		BookMoveEntry{Algeb: "e2e4", Score: 30, Depth: 20},
+		BookMoveEntry{Algeb: "d2d4", Score: 20, Depth: 10})
+	for line, algeb := range map[string]string{"e2e4": "e7e5", "d2d4": "d7d5", "d2d4 d7d5": "g1f3"} {
+		next := polyglotTestPosition(t, VARIANT_Standard, line)
+		book.PositionEntries[next.ZobristStr()] = bookToolsTestBook(next, BookMoveEntry{Algeb: algeb, Depth: 20}).PositionEntries[next.ZobristStr()]
+	}
+	// d2d4 is pruned, the two positions after it are unreachable
+	pruned, stats := PruneBook(book, pos, 15, 0, -1)
+	if ( len(pruned.PositionEntries) != 2 ) || ( stats.Unreachable != 2 ) {
+		t.Errorf("%d positions left, %d unreachable, expected 2 and 2", len(pruned.PositionEntries), stats.Unreachable)
+	}
+	if stats.Positions != 0 {
+		t.Errorf("%d positions without moves, expected none", stats.Positions)
+	}
+	if fen := pos.String(); fen != START_FENS[VARIANT_Standard] {
+		t.Errorf("pruning changed the root to %s", fen)
+	}
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,513 @@
+//////////////////////////////////////////////////////
+// datagen.go
+// implements the generation of labelled positions from self-play
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// DatagenOptions : options of the self-play data generator
+type DatagenOptions struct {
+	Games       int    // total number of games, including those of interrupted runs
+	Depth       int32  // fixed search depth, 0 for no limit
+	Nodes       uint64 // fixed number of nodes, 0 for no limit
+	RandomPlies int    // number of random plies played at the start of each game
+	MaxPlies    int    // a game reaching this many plies is a draw
+	Threads     int    // number of parallel engines
+	Binary      bool   // write binary records instead of EPD
+	Seed        int64  // seed of the random openings
+}
+
+// default options of the self-play data generator
+var DATAGEN_DEFAULTS = DatagenOptions{
+	Games:       1000,
+	Depth:       6,
+	RandomPlies: 8,
+	MaxPlies:    400,
+	Threads:     1,
+	Seed:        1,
+}
+
+// DataRecord : a position labelled by self-play
+type DataRecord struct {
+	Position *Position // position
+	Score    int32     // search score in centipawns from White's POV
+	PVLength int       // length of the principal variation
+	Result   string    // game result, 1-0, 0-1 or 1/2-1/2
+}
+
+// datagenProgress : progress of an interrupted generation, saved next to the output
+// games finish out of order so the finished games above the first unfinished one are listed
+type datagenProgress struct {
+	Done     int   `json:"done"`     // games with a lower index are finished
+	Finished []int `json:"finished"` // finished games with an index above done
+	Bytes    int64 `json:"bytes"`    // size of the output after the last written game
+}
+
+// datagen : state shared by the workers of a generation
+type datagen struct {
+	opts     DatagenOptions
+	out      *os.File
+	path     string
+	progress datagenProgress
+	cursor   int // index of the next game to consider
+	lock     sync.Mutex
+	report   func(games int)
+	err      error
+}
+
+// results of binary records
+var dataResults = []string{"0-1", "1/2-1/2", "1-0"}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// RunDatagen : plays games between private engines and writes the labelled positions
+// positions are written after their game ends, so the output only contains whole games
+// the progress is saved next to the output in path.progress and an interrupted run resumes from there
+// files ending with .bin get binary records, anything else EPD
+// -> path string : output file
+// -> opts DatagenOptions : options
+// -> report func(games int) : called after each game with the number of games written, can be nil
+// <- error : error
+
+func RunDatagen(path string, opts DatagenOptions, report func(games int)) error {
+	dg := &datagen{opts: opts, path: path, report: report}
+	if err := dg.resume(); err != nil {
+		return err
+	}
+	defer dg.out.Close()
+	dg.cursor = dg.progress.Done
+
+	threads := opts.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dg.work()
+		}()
+	}
+	wg.Wait()
+	return dg.err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// resume : opens the output and restores the progress of an interrupted run
+// a partially written game is cut off
+// -> dg *datagen : generator
+// <- error : error
+
+func (dg *datagen) resume() error {
+	if data, err := os.ReadFile(dg.progressPath()); err == nil {
+		if err := json.Unmarshal(data, &dg.progress); err != nil {
+			return fmt.Errorf("%s: %v", dg.progressPath(), err)
+		}
+	}
+	out, err := os.OpenFile(dg.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := out.Truncate(dg.progress.Bytes); err == nil {
+		_, err = out.Seek(dg.progress.Bytes, io.SeekStart)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	dg.out = out
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// progressPath : path of the progress file
+// -> dg *datagen : generator
+// <- string : path
+
+func (dg *datagen) progressPath() string {
+	return dg.path + ".progress"
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// next : returns the index of the next game to play
+// -> dg *datagen : generator
+// <- int : index of the game
+// <- bool : false if all games are started or an error occurred
+
+func (dg *datagen) next() (int, bool) {
+	dg.lock.Lock()
+	defer dg.lock.Unlock()
+	for ( dg.err == nil ) && ( dg.cursor < dg.opts.Games ) {
+		index := dg.cursor
+		dg.cursor++
+		if !dg.finished(index) {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// finished : tells whether a game is finished
+// -> dg *datagen : generator
+// -> index int : index of the game
+// <- bool : true if finished
+
+func (dg *datagen) finished(index int) bool {
+	if index < dg.progress.Done {
+		return true
+	}
+	for _, i := range dg.progress.Finished {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// work : plays games until all are started
+// -> dg *datagen : generator
+
+func (dg *datagen) work() {
+	eng := NewEngine(nil, nil, Options{Private: true})
+	for {
+		index, ok := dg.next()
+		if !ok {
+			return
+		}
+		dg.write(index, dg.play(eng, index))
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// write : appends the records of a game to the output and saves the progress
+// -> dg *datagen : generator
+// -> index int : index of the game
+// -> records []DataRecord : records of the game
+
+func (dg *datagen) write(index int, records []DataRecord) {
+	buf := []byte{}
+	for _, record := range records {
+		if dg.opts.Binary {
+			buf = record.AppendBinary(buf)
+		} else {
+			buf = append(buf, record.EPD()...)
+			buf = append(buf, '\n')
+		}
+	}
+
+	dg.lock.Lock()
+	defer dg.lock.Unlock()
+	if dg.err != nil {
+		return
+	}
+	if _, err := dg.out.Write(buf); err != nil {
+		dg.err = err
+		return
+	}
+	dg.progress.Bytes += int64(len(buf))
+	dg.progress.Finished = append(dg.progress.Finished, index)
+	for dg.finished(dg.progress.Done) {
+		dg.progress.Done++
+	}
+	finished := dg.progress.Finished[:0]
+	for _, i := range dg.progress.Finished {
+		if i >= dg.progress.Done {
+			finished = append(finished, i)
+		}
+	}
+	dg.progress.Finished = finished
+	data, _ := json.Marshal(dg.progress)
+	if err := os.WriteFile(dg.progressPath(), data, 0644); err != nil {
+		dg.err = err
+		return
+	}
+	if dg.report != nil {
+		dg.report(dg.progress.Done + len(dg.progress.Finished))
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// play : plays one game of self-play
+// -> dg *datagen : generator
+// -> eng *Engine : private engine
+// -> index int : index of the game, determines the random opening
+// <- []DataRecord : labelled positions, none if the opening ended the game
+
+func (dg *datagen) play(eng *Engine, index int) []DataRecord {
+	rnd := rand.New(rand.NewSource(dg.opts.Seed + int64(index)))
+	pos, _ := PositionFromFEN(START_FENS[Variant])
+
+	for ply := 0; ply < dg.opts.RandomPlies; ply++ {
+		moves := pos.GetLegalMoves(GET_ALL)
+		if len(moves) == 0 {
+			return nil
+		}
+		pos.DoMove(moves[rnd.Intn(len(moves))])
+		if _, over := GameResult(pos); over {
+			return nil
+		}
+	}
+
+	records := []DataRecord{}
+	eng.SetPosition(pos)
+	eng.history = newHistoryTable()
+	eng.hash().Clear()
+	result := "1/2-1/2"
+	for ply := 0; ; ply++ {
+		if r, over := GameResult(eng.Position); over {
+			result = r
+			break
+		}
+		if ply >= dg.opts.MaxPlies {
+			break
+		}
+
+		var tc *TimeControl
+		if dg.opts.Depth > 0 {
+			tc = NewFixedDepthTimeControl(eng.Position, dg.opts.Depth)
+		} else {
+			tc = NewTimeControl(eng.Position, false)
+		}
+		tc.Nodes = dg.opts.Nodes
+		tc.Start(false)
+		pv := eng.Play(tc, nil)
+		if len(pv) == 0 {
+			break
+		}
+
+		us := eng.Position.SideToMove
+		score := eng.LastScore
+		copied, _ := PositionFromFEN(eng.Position.String())
+		records = append(records, DataRecord{
+			Position: copied,
+			Score:    scoreMultiplier[us] * score,
+			PVLength: len(pv),
+		})
+
+		// adjudicate found mates
+		if score >= KnownWinScore {
+			result = map[Color]string{White: "1-0", Black: "0-1"}[us]
+			break
+		}
+		if score <= KnownLossScore {
+			result = map[Color]string{White: "0-1", Black: "1-0"}[us]
+			break
+		}
+		eng.DoMove(pv[0])
+	}
+
+	for i := range records {
+		records[i].Result = result
+	}
+	return records
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GameResult : returns the result if the game is over
+// covers mate, stalemate, the variant specific wins, insufficient material and the draw rules
+// -> pos *Position : position
+// <- string : result, 1-0, 0-1 or 1/2-1/2
+// <- bool : true if the game is over
+
+func GameResult(pos *Position) (string, bool) {
+	us := pos.SideToMove
+	lost := map[Color]string{White: "0-1", Black: "1-0"}[us]
+	// a lost king or lost pawns have to be detected before generating moves
+	if IS_Atomic && pos.IsExploded(us) {
+		return lost, true
+	}
+	if IS_Horde && ( us == HORDE_Pawns_Side ) && pos.AllPawnsCaptured() {
+		return lost, true
+	}
+	if len(pos.GetLegalMoves(GET_ALL)) == 0 {
+		if pos.IsChecked(us) {
+			return lost, true
+		}
+		return "1/2-1/2", true
+	}
+	if pos.InsufficientMaterial() || pos.FiftyMoveRule() || ( pos.ThreeFoldRepetition() >= 3 ) {
+		return "1/2-1/2", true
+	}
+	return "", false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// EPD : formats the record as an EPD line
+// ce is the score from the side to move's POV, c0 the length of the pv and c9 the result
+// -> r DataRecord : record
+// <- string : EPD line
+
+func (r DataRecord) EPD() string {
+	fields := strings.Fields(r.Position.String())
+	return fmt.Sprintf("%s ce %d; c0 \"%d\"; c9 \"%s\";", strings.Join(fields[:4], " "),
+		scoreMultiplier[r.Position.SideToMove]*r.Score, r.PVLength, r.Result)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// AppendBinary : appends the record in binary format
+// occupancy as uint64, pieces in square order as 4 bit codes, side to move and castling in one byte,
+// en passant square, score as int16, pv length and result as bytes, all little endian
+// -> r DataRecord : record
+// -> buf []byte : buffer
+// <- []byte : buffer with the record appended
+
+func (r DataRecord) AppendBinary(buf []byte) []byte {
+	pos := r.Position
+	occupancy := pos.ByColor[White] | pos.ByColor[Black]
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(occupancy))
+	packed, half := byte(0), false
+	for bb := occupancy; bb != 0; {
+		code := byte(pos.Get(bb.Pop()))
+		if half {
+			buf = append(buf, packed|code<<4)
+		} else {
+			packed = code
+		}
+		half = !half
+	}
+	if half {
+		buf = append(buf, packed)
+	}
+
+	flags := byte(pos.CastlingAbility()) << 1
+	if pos.SideToMove == Black {
+		flags |= 1
+	}
+	buf = append(buf, flags, byte(pos.EnpassantSquare()))
+
+	score := r.Score
+	if score > 32767 {
+		score = 32767
+	} else if score < -32767 {
+		score = -32767
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(int16(score)))
+	pvlength := r.PVLength
+	if pvlength > 255 {
+		pvlength = 255
+	}
+	result := byte(1)
+	for i, name := range dataResults {
+		if name == r.Result {
+			result = byte(i)
+		}
+	}
+	return append(buf, byte(pvlength), result)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadDataRecord : reads a record in binary format
+// -> br *bufio.Reader : reader
+// <- DataRecord : record
+// <- error : error, io.EOF at the end of the data
+
+func ReadDataRecord(br *bufio.Reader) (DataRecord, error) {
+	var occupancy uint64
+	if err := binary.Read(br, binary.LittleEndian, &occupancy); err != nil {
+		return DataRecord{}, err
+	}
+	pieces := make([]byte, ( Bitboard(occupancy).Count() + 1 ) / 2)
+	tail := make([]byte, 6)
+	for _, data := range [][]byte{pieces, tail} {
+		if _, err := io.ReadFull(br, data); err != nil {
+			return DataRecord{}, fmt.Errorf("truncated record")
+		}
+	}
+
+	pos := NewPosition()
+	i := 0
+	for bb := Bitboard(occupancy); bb != 0; i++ {
+		code := pieces[i/2] >> uint(4*(i%2)) & 15
+		pi := Piece(code)
+		if ( pi.Figure() < FigureMinValue ) || ( pi.Figure() > FigureMaxValue ) {
+			return DataRecord{}, fmt.Errorf("invalid piece code %d", code)
+		}
+		pos.Put(bb.Pop(), pi)
+	}
+	if tail[0]&1 != 0 {
+		pos.SetSideToMove(Black)
+	} else {
+		pos.SetSideToMove(White)
+	}
+	pos.SetCastlingAbility(Castle(tail[0] >> 1) & AnyCastle)
+	pos.SetEnpassantSquare(Square(tail[1] & 63))
+
+	if int(tail[5]) >= len(dataResults) {
+		return DataRecord{}, fmt.Errorf("invalid result %d", tail[5])
+	}
+	return DataRecord{
+		Position: pos,
+		Score:    int32(int16(binary.LittleEndian.Uint16(tail[2:4]))),
+		PVLength: int(tail[4]),
+		Result:   dataResults[tail[5]],
+	}, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadTuneBinary : reads labelled positions from binary records
+// -> r io.Reader : reader
+// <- []TuneEntry : labelled positions
+// <- error : error
+
+func LoadTuneBinary(r io.Reader) ([]TuneEntry, error) {
+	entries := []TuneEntry{}
+	br := bufio.NewReader(r)
+	for num := 1; ; num++ {
+		record, err := ReadDataRecord(br)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", num, err)
+		}
+		result, _ := PGNResultScore(record.Result)
+		entries = append(entries, TuneEntry{Position: record.Position, Result: result})
+	}
+}
+
+///////////////////////////////////////////////
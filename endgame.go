@@ -0,0 +1,355 @@
+//////////////////////////////////////////////////////
+// endgame.go
+// implements the registry of endgame knowledge modules dispatched by material signature
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"fmt"
+	"strings"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// EndgameModule : specialised knowledge of a material configuration
+type EndgameModule struct {
+	Name     string                                  // material configuration, strong side first, e.g. KBNK
+	Evaluate func(pos *Position, strong Color) int32 // exact evaluation from the strong side's POV in Evaluate units, nil if none
+	Scale    func(pos *Position, strong Color) int32 // scaling factor of the strong side, MATERIAL_SCALE_FULL is none, nil if none
+}
+
+// endgameEntry : a module registered for a material signature
+type endgameEntry struct {
+	module *EndgameModule // module
+	strong Color          // side having the first part of the configuration
+}
+
+// modules by variant and material signature
+var endgameRegistry = map[int]map[uint64]endgameEntry{}
+
+// value of a won endgame in centipawns, on top of the material
+const ENDGAME_KNOWN_WIN int32 = 10000
+
+// bonus in centipawns by step of the weak king towards the edge
+var ENDGAME_PUSH_TO_EDGE_VALUE int32 = 15
+
+// bonus in centipawns by step of the kings towards each other
+var ENDGAME_PUSH_CLOSE_VALUE int32 = 10
+
+// bonus in centipawns by step of the weak king towards the corner the bishop controls
+var ENDGAME_PUSH_TO_CORNER_VALUE int32 = 30
+
+// scaling factor of a pawn ending the defending king blocks
+var ENDGAME_KPK_BLOCKED_SCALE int32 = 16
+
+// bonus in centipawns by rank of a horde pawn against a lone king
+var ENDGAME_HORDE_ADVANCE_VALUE int32 = 10
+
+// pawns of the horde at the start
+const ENDGAME_HORDE_MAX_PAWNS = 36
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// init : registers the endgame modules of all variants
+
+func init() {
+	// the strong side mates a bare king
+	for _, code := range []string{"KQK", "KRK", "KQQK", "KQRK", "KRRK", "KQBK", "KQNK", "KRBK", "KRNK", "KBBK"} {
+		RegisterEndgame(VARIANT_Standard, &EndgameModule{Name: code, Evaluate: evaluateKXK})
+	}
+	RegisterEndgame(VARIANT_Standard, &EndgameModule{Name: "KBNK", Evaluate: evaluateKBNK})
+	RegisterEndgame(VARIANT_Standard, &EndgameModule{Name: "KPK", Scale: scaleKPK})
+
+	// kings cannot capture in atomic, without a second piece nothing can explode
+	for _, code := range []string{"KK", "KNK", "KBK"} {
+		RegisterEndgame(VARIANT_Atomic, &EndgameModule{Name: code, Evaluate: evaluateDraw})
+	}
+
+	// the horde wins once a pawn outruns the lone king, the king has to capture every pawn
+	for pawns := 1; pawns <= ENDGAME_HORDE_MAX_PAWNS; pawns++ {
+		RegisterEndgame(VARIANT_Horde, &EndgameModule{Name: strings.Repeat("P", pawns) + "K", Evaluate: evaluateHordePK})
+	}
+
+	// with bare kings nothing but the race counts
+	RegisterEndgame(VARIANT_Racing_Kings, &EndgameModule{Name: "KK", Evaluate: evaluateRaceKK})
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// RegisterEndgame : registers a module for a variant
+// the module is registered for both sides as the strong side
+// -> variant int : variant
+// -> module *EndgameModule : module
+// <- error : error if the name is not a material configuration
+
+func RegisterEndgame(variant int, module *EndgameModule) error {
+	if endgameRegistry[variant] == nil {
+		endgameRegistry[variant] = map[uint64]endgameEntry{}
+	}
+	for _, strong := range []Color{White, Black} {
+		sig, err := MaterialSignatureFromCode(module.Name, strong)
+		if err != nil {
+			return err
+		}
+		if _, found := endgameRegistry[variant][sig]; !found {
+			// a symmetric configuration is registered with White as the strong side
+			endgameRegistry[variant][sig] = endgameEntry{module: module, strong: strong}
+		}
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LookupEndgame : returns the module of a material configuration in the current variant
+// -> sig uint64 : material signature
+// <- endgameEntry : module and strong side
+// <- bool : true if a module is registered
+
+func LookupEndgame(sig uint64) (endgameEntry, bool) {
+	entry, found := endgameRegistry[Variant][sig]
+	return entry, found
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// MaterialSignatureFromCode : returns the material signature of a configuration like KBNK
+// the pieces of the strong side come first, the second K starts the pieces of the weak side
+// a strong side without king, the horde, has no K, like PPK
+// -> code string : configuration
+// -> strong Color : side having the first part of the configuration
+// <- uint64 : signature
+// <- error : error
+
+func MaterialSignatureFromCode(code string, strong Color) (uint64, error) {
+	if len(code) < 2 {
+		return 0, fmt.Errorf("invalid material configuration %s", code)
+	}
+	split := strings.Index(code, "K")
+	if split == 0 {
+		split = strings.Index(code[1:], "K") + 1
+	}
+	if split <= 0 {
+		return 0, fmt.Errorf("invalid material configuration %s", code)
+	}
+	sig := uint64(0)
+	for i, part := range []string{code[:split], code[split:]} {
+		col := strong
+		if i == 1 {
+			col = strong.Opposite()
+		}
+		counts := [FigureArraySize]uint64{}
+		for _, symbol := range part {
+			fig := symbolToFigure[symbol]
+			if ( fig == NoFigure ) || ( ( fig == King ) && ( counts[King] > 0 ) ) {
+				return 0, fmt.Errorf("invalid material configuration %s", code)
+			}
+			counts[fig]++
+		}
+		for fig := FigureMinValue; fig <= FigureMaxValue; fig++ {
+			sig |= counts[fig] << materialSignatureShift(col, fig)
+		}
+	}
+	return sig, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// squareDistance : returns the number of king moves between two squares
+// -> a Square : square
+// -> b Square : square
+// <- int32 : distance
+
+func squareDistance(a, b Square) int32 {
+	df, dr := int32(a.File()-b.File()), int32(a.Rank()-b.Rank())
+	if df < 0 {
+		df = -df
+	}
+	if dr < 0 {
+		dr = -dr
+	}
+	return max(df, dr)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// centerDistance : returns how far a square is from the center, 0 to 6
+// -> sq Square : square
+// <- int32 : distance
+
+func centerDistance(sq Square) int32 {
+	return 3 - min(int32(sq.File()), int32(7-sq.File())) + 3 - min(int32(sq.Rank()), int32(7-sq.Rank()))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluateDraw : evaluates a drawn configuration
+// -> pos *Position : position
+// -> strong Color : strong side
+// <- int32 : 0
+
+func evaluateDraw(pos *Position, strong Color) int32 {
+	return 0
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluateKXK : evaluates mating material against a bare king
+// drives the weak king to the edge and brings the strong king closer
+// -> pos *Position : position
+// -> strong Color : strong side
+// <- int32 : score from the strong side's POV
+
+func evaluateKXK(pos *Position, strong Color) int32 {
+	if pos.InsufficientMaterial() {
+		// bishops on the same color
+		return 0
+	}
+	sk := pos.ByPiece(strong, King).AsSquare()
+	wk := pos.ByPiece(strong.Opposite(), King).AsSquare()
+	score := ENDGAME_KNOWN_WIN + 100*materialPoints(pos.MaterialSignature(), strong)
+	score += ENDGAME_PUSH_TO_EDGE_VALUE * centerDistance(wk)
+	score += ENDGAME_PUSH_CLOSE_VALUE * ( 7 - squareDistance(sk, wk) )
+	return score * 128
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluateKBNK : evaluates bishop and knight against a bare king
+// only the corners of the bishop's color can be mated
+// -> pos *Position : position
+// -> strong Color : strong side
+// <- int32 : score from the strong side's POV
+
+func evaluateKBNK(pos *Position, strong Color) int32 {
+	sk := pos.ByPiece(strong, King).AsSquare()
+	wk := pos.ByPiece(strong.Opposite(), King).AsSquare()
+	corners := []Square{SquareA1, SquareH8}
+	if pos.ByPiece(strong, Bishop)&BbWhiteSquares != 0 {
+		corners = []Square{SquareH1, SquareA8}
+	}
+	corner := min(squareDistance(wk, corners[0]), squareDistance(wk, corners[1]))
+	score := ENDGAME_KNOWN_WIN + 100*materialPoints(pos.MaterialSignature(), strong)
+	score += ENDGAME_PUSH_TO_CORNER_VALUE * ( 7 - corner )
+	score += ENDGAME_PUSH_CLOSE_VALUE * ( 7 - squareDistance(sk, wk) )
+	return score * 128
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// scaleKPK : scales king and pawn against king
+// a defending king in front of a rook pawn draws,
+// in front of another pawn it draws unless the strong king is ahead of the pawn
+// -> pos *Position : position
+// -> strong Color : strong side
+// <- int32 : scaling factor
+
+func scaleKPK(pos *Position, strong Color) int32 {
+	pawn := pos.ByPiece(strong, Pawn)
+	weak := pos.ByPiece(strong.Opposite(), King)
+	front := ForwardSpan(strong, pawn)
+	if file := pawn.AsSquare().File(); ( file == 0 ) || ( file == 7 ) {
+		promotion := ( front & ( BbRank1 | BbRank8 ) ).AsSquare()
+		if ( weak&front != 0 ) || ( squareDistance(weak.AsSquare(), promotion) <= 1 ) {
+			return 0
+		}
+		return MATERIAL_SCALE_FULL
+	}
+	sk := pos.ByPiece(strong, King).AsSquare().POV(strong)
+	if ( weak&front != 0 ) && ( sk.Rank() <= pawn.AsSquare().POV(strong).Rank() ) {
+		return ENDGAME_KPK_BLOCKED_SCALE
+	}
+	return MATERIAL_SCALE_FULL
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluateHordePK : evaluates horde pawns against a lone king
+// a pawn with a free path out of the square of the king promotes, otherwise the pawns count by their advance
+// -> pos *Position : position
+// -> strong Color : horde side
+// <- int32 : score from the strong side's POV
+
+func evaluateHordePK(pos *Position, strong Color) int32 {
+	pawns := pos.ByPiece(strong, Pawn)
+	wk := pos.ByPiece(strong.Opposite(), King).AsSquare()
+	tempo := int32(0)
+	if pos.SideToMove != strong {
+		tempo = 1
+	}
+	score := 100 * pawns.Count()
+	unstoppable := false
+	for bb := pawns; bb > 0; {
+		sq := bb.Pop()
+		rank := int32(sq.POV(strong).Rank())
+		score += ENDGAME_HORDE_ADVANCE_VALUE * rank
+		// pawns on the first two ranks can step twice
+		dist := 7 - rank
+		if rank <= 1 {
+			dist--
+		}
+		front := ForwardSpan(strong, sq.Bitboard())
+		promotion := ( front & ( BbRank1 | BbRank8 ) ).AsSquare()
+		if ( front&( pos.ByColor[White]|pos.ByColor[Black] ) == 0 ) && ( squareDistance(wk, promotion)-tempo > dist ) {
+			unstoppable = true
+		}
+	}
+	if unstoppable {
+		score += ENDGAME_KNOWN_WIN
+	}
+	return score * 128
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluateRaceKK : evaluates the race of bare kings in Racing Kings
+// a king arriving first wins, White only if Black cannot arrive on the next ply
+// -> pos *Position : position
+// -> strong Color : White, the configuration is symmetric
+// <- int32 : score from the strong side's POV
+
+func evaluateRaceKK(pos *Position, strong Color) int32 {
+	var dist, arrival [ColorArraySize]int32
+	for _, side := range []Color{White, Black} {
+		them := pos.ByPiece(side.Opposite(), King).AsSquare()
+		dist[side] = RaceDistanceRk(pos, side, KingMobility(them))
+		arrival[side] = 2*dist[side]
+		if side == pos.SideToMove {
+			arrival[side]--
+		}
+	}
+	winner := NoColor
+	if ( dist[White] < RK_RACE_UNREACHABLE ) || ( dist[Black] < RK_RACE_UNREACHABLE ) {
+		if arrival[Black] < arrival[White] {
+			winner = Black
+		} else if arrival[White]+1 < arrival[Black] {
+			winner = White
+		}
+	}
+	if winner == NoColor {
+		return 0
+	}
+	score := ENDGAME_KNOWN_WIN + ENDGAME_PUSH_CLOSE_VALUE * ( 7 - dist[winner] )
+	if winner != strong {
+		score = -score
+	}
+	return score * 128
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,133 @@
+//////////////////////////////////////////////////////
+// endgame_test.go
+// tests that the endgame modules are dispatched for either side as the strong side
+// and that their evaluations have the right sign
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// endgameTestCase : a position, the module it is dispatched to and the sign of its evaluation
+type endgameTestCase struct {
+	variant int    // variant
+	fen     string // position
+	module  string // name of the module the position is dispatched to
+	sign    int32  // sign of the evaluation from White's POV, 0 for a draw
+}
+
+var endgameTestCases = []endgameTestCase{
+	{VARIANT_Standard, "k7/8/8/8/8/2K5/8/4NB2 w - - 0 1", "KBNK", 1},
+	{VARIANT_Standard, "4nb2/8/2k5/8/8/8/8/K7 w - - 0 1", "KBNK", -1},
+	{VARIANT_Standard, "k7/8/8/8/P7/8/8/2K5 w - - 0 1", "KPK", 0},
+	{VARIANT_Standard, "8/8/8/8/7p/8/1k6/7K b - - 0 1", "KPK", 0},
+	{VARIANT_Standard, "7k/8/8/1K6/P7/8/8/8 w - - 0 1", "KPK", 1},
+	{VARIANT_Standard, "8/8/8/7p/6k1/8/8/K7 b - - 0 1", "KPK", -1},
+	{VARIANT_Atomic, "8/8/8/3k4/8/8/8/1N2K3 w - - 0 1", "KNK", 0},
+	{VARIANT_Atomic, "1n2k3/8/8/8/3K4/8/8/8 b - - 0 1", "KNK", 0},
+	{VARIANT_Horde, "8/8/P7/8/8/8/8/7k w - - 0 1", "PK", 1},
+	{VARIANT_Horde, "8/1k6/8/8/8/8/P7/8 b - - 0 1", "PK", 1},
+	{VARIANT_Racing_Kings, "8/6K1/8/8/8/8/8/k7 w - - 0 1", "KK", 1},
+	{VARIANT_Racing_Kings, "8/1k6/8/8/8/8/8/K7 b - - 0 1", "KK", -1},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// endgameTestEvaluate : evaluates a position of a variant
+// -> t *testing.T : test
+// -> variant int : variant
+// -> fen string : position
+// <- int32 : eval from White's POV
+// <- EvalBreakdown : breakdown of the eval
+
+func endgameTestEvaluate(t *testing.T, variant int, fen string) (int32, EvalBreakdown) {
+	t.Helper()
+	uci = NewUCI()
+	uci.SetVariant(variant)
+	pos, err := PositionFromFEN(fen)
+	if err != nil {
+		t.Fatalf("%s: %v", fen, err)
+	}
+	return Evaluate(pos), EvaluateBreakdown(pos)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestEndgameDispatch : each position is evaluated by its module with the expected sign
+
+func TestEndgameDispatch(t *testing.T) {
+	for _, tc := range endgameTestCases {
+		score, breakdown := endgameTestEvaluate(t, tc.variant, tc.fen)
+		if breakdown.Endgame != tc.module {
+			t.Errorf("%s: dispatched to %q, want %q", tc.fen, breakdown.Endgame, tc.module)
+		}
+		sign := int32(0)
+		if score > 0 {
+			sign = 1
+		} else if score < 0 {
+			sign = -1
+		}
+		if sign != tc.sign {
+			t.Errorf("%s: got eval %d, want sign %d", tc.fen, score, tc.sign)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestEndgameKBNKCorner : the weak king is driven to a corner of the bishop's color
+
+func TestEndgameKBNKCorner(t *testing.T) {
+	// the light squared bishop mates on a8 and h1, not on a1 and h8
+	right, _ := endgameTestEvaluate(t, VARIANT_Standard, "k7/8/8/8/8/2K5/8/4NB2 w - - 0 1")
+	wrong, _ := endgameTestEvaluate(t, VARIANT_Standard, "7k/8/8/8/8/2K5/8/4NB2 w - - 0 1")
+	if right <= wrong {
+		t.Errorf("king on the bishop's corner %d, on the other corner %d", right, wrong)
+	}
+	if wrong < ENDGAME_KNOWN_WIN*128 {
+		t.Errorf("king on the other corner %d, want a known win", wrong)
+	}
+
+	// the same seen from Black as the strong side
+	right, _ = endgameTestEvaluate(t, VARIANT_Standard, "K7/8/8/8/8/2k5/8/4nb2 b - - 0 1")
+	wrong, _ = endgameTestEvaluate(t, VARIANT_Standard, "7K/8/8/8/8/2k5/8/4nb2 b - - 0 1")
+	if -right <= -wrong {
+		t.Errorf("black: king on the bishop's corner %d, on the other corner %d", right, wrong)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestEndgameHordeLoneKing : a pawn out of the square of the king is a known win,
+// a pawn the king catches is not
+
+func TestEndgameHordeLoneKing(t *testing.T) {
+	runaway, _ := endgameTestEvaluate(t, VARIANT_Horde, "8/8/P7/8/8/8/8/7k w - - 0 1")
+	caught, _ := endgameTestEvaluate(t, VARIANT_Horde, "8/1k6/8/8/8/8/P7/8 b - - 0 1")
+	if runaway < ENDGAME_KNOWN_WIN*128 {
+		t.Errorf("pawn outrunning the king %d, want a known win", runaway)
+	}
+	if caught >= ENDGAME_KNOWN_WIN*128 {
+		t.Errorf("pawn the king catches %d, want less than a known win", caught)
+	}
+
+	// a king just outside the square of the pawn only catches it with the move
+	withmove, _ := endgameTestEvaluate(t, VARIANT_Horde, "8/8/8/5k2/P7/8/8/8 b - - 0 1")
+	without, _ := endgameTestEvaluate(t, VARIANT_Horde, "8/8/8/5k2/P7/8/8/8 w - - 0 1")
+	if ( withmove >= ENDGAME_KNOWN_WIN*128 ) || ( without < ENDGAME_KNOWN_WIN*128 ) {
+		t.Errorf("king to move %d, pawn to move %d", withmove, without)
+	}
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,212 @@
+//////////////////////////////////////////////////////
+// eval.go
+// implements the per-term breakdown of the evaluation
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"fmt"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// enumeration of evaluation terms
+const(
+	TermMaterial = iota
+	TermPawnSquare
+	TermPassedPawn
+	TermConnectedPawn
+	TermDoublePawn
+	TermIsolatedPawn
+	TermPawnThreat
+	TermKingPosition
+	TermKingShelter
+	TermMobility
+	TermBishopPair
+	TermRookFile
+	TermAtomicPawn
+	TermAtomicQueen
+	TermAtomicKingAttack
+	TermAtomicConnection
+	TermAtomicThreat
+	TermAtomicKingSafety
+	TermHordePawn
+	TermHordeCenter
+	TermHordeBalance
+	TermHordeChain
+	TermHordePassedPawn
+	TermHordeHole
+	TermHordeKingDanger
+	TermRkMaterial
+	TermRkKingAdvance
+	TermRkKnightAdvance
+	TermRkRace
+	TermRkTempo
+	TermRkControl
+	TermCount
+)
+
+// names of evaluation terms
+var EVAL_TERM_NAMES = [TermCount]string{
+	"Material",
+	"Pawn square",
+	"Passed pawn",
+	"Connected pawn",
+	"Double pawn",
+	"Isolated pawn",
+	"Pawn threat",
+	"King position",
+	"King shelter",
+	"Mobility",
+	"Bishop pair",
+	"Rook file",
+	"Atomic pawn",
+	"Atomic queen",
+	"Atomic king attack",
+	"Atomic connection",
+	"Atomic threat",
+	"Atomic king safety",
+	"Horde pawn",
+	"Horde center",
+	"Horde balance",
+	"Horde chain",
+	"Horde passed pawn",
+	"Horde hole",
+	"Horde king danger",
+	"RK material",
+	"RK king advance",
+	"RK knight advance",
+	"RK race distance",
+	"RK race tempo",
+	"RK forward control",
+}
+
+// EvalBreakdown : evaluation split into terms
+// term values are in Evaluate units ( 128 = one centipawn ) from the side's own POV
+type EvalBreakdown struct {
+	Terms [TermCount][ColorArraySize]Eval // terms by side
+	Used  [TermCount]bool                 // true if the term was evaluated
+	Phase int32                           // game phase, 0 is opening, 256 is late end game
+	Score int32                           // blended score in centipawns from White's POV
+	Scale int32                           // material scaling factor of the leading side, MATERIAL_SCALE_FULL is none
+	Endgame string                        // name of the endgame module used, empty if none
+	NNUE  bool                            // true if the network evaluation is used
+	NNUEScore int32                       // network score in centipawns from White's POV
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// add : adds a value to a term
+// -> b *EvalBreakdown : breakdown
+// -> term int : term
+// -> us Color : side
+// -> m int32 : middle game value
+// -> e int32 : end game value
+
+func (b *EvalBreakdown) add(term int, us Color, m, e int32) {
+	b.Terms[term][us].M += m
+	b.Terms[term][us].E += e
+	b.Used[term] = true
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// rk : records a Racing Kings term, which has no phases, a nil breakdown records nothing
+// -> b *EvalBreakdown : breakdown, nil if not tracing
+// -> term int : term
+// -> us Color : side
+// -> val int32 : value in centipawns
+// <- int32 : val unchanged
+
+func (b *EvalBreakdown) rk(term int, us Color, val int32) int32 {
+	if b != nil {
+		b.add(term, us, val*128, val*128)
+	}
+	return val
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// EvaluateBreakdown : evaluates position and splits the evaluation into terms
+// the evaluation caches are bypassed so that every term is computed
+// -> pos *Position : position
+// <- EvalBreakdown : breakdown
+
+func EvaluateBreakdown(pos *Position) EvalBreakdown {
+	var b EvalBreakdown
+	if NNUEActive() {
+		b.NNUE = true
+		b.NNUEScore = ScaleToCentiPawn(EvaluateNNUE(pos))
+	}
+
+	b.Scale = MATERIAL_SCALE_FULL
+	b.Score = ScaleToCentiPawn(globalEvalCaches.evaluateClassical(pos, &b))
+	b.Phase = Phase(pos)
+	return b
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Total : returns the sum of all terms of a side
+// -> b *EvalBreakdown : breakdown
+// -> us Color : side
+// <- Eval : total
+
+func (b *EvalBreakdown) Total(us Color) Eval {
+	var total Eval
+	for term := range b.Terms {
+		total.Merge(b.Terms[term][us])
+	}
+	return total
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// String : formats the breakdown as a table in pawns
+// -> b *EvalBreakdown : breakdown
+// <- string : table
+
+func (b *EvalBreakdown) String() string {
+	cp := func(v int32) string {
+		return fmt.Sprintf("%7.2f", float64(v)/128/100)
+	}
+	row := func(name string, white, black Eval) string {
+		return fmt.Sprintf("%-20s | %s %s | %s %s | %s %s\n", name,
+			cp(white.M), cp(white.E), cp(black.M), cp(black.E), cp(white.M-black.M), cp(white.E-black.E))
+	}
+
+	s := fmt.Sprintf("%-20s | %-15s | %-15s | %-15s\n", "Term", "     White", "     Black", "     Total")
+	s += fmt.Sprintf("%-20s | %7s %7s | %7s %7s | %7s %7s\n", "", "MG", "EG", "MG", "EG", "MG", "EG")
+	for term, name := range EVAL_TERM_NAMES {
+		if b.Used[term] {
+			s += row(name, b.Terms[term][White], b.Terms[term][Black])
+		}
+	}
+	s += row("Total", b.Total(White), b.Total(Black))
+	s += fmt.Sprintf("\nPhase %d\n", b.Phase)
+	if b.Scale != MATERIAL_SCALE_FULL {
+		s += fmt.Sprintf("Material scale %d/%d\n", b.Scale, MATERIAL_SCALE_FULL)
+	}
+	if b.Endgame != "" {
+		s += fmt.Sprintf("Endgame module %s\n", b.Endgame)
+	}
+	s += fmt.Sprintf("Classical evaluation %.2f (White side)\n", float64(b.Score)/100)
+	if b.NNUE {
+		s += fmt.Sprintf("NNUE evaluation %.2f (White side)\n", float64(b.NNUEScore)/100)
+		s += fmt.Sprintf("Final evaluation %.2f (White side)\n", float64(b.NNUEScore)/100)
+	} else {
+		s += fmt.Sprintf("Final evaluation %.2f (White side)\n", float64(b.Score)/100)
+	}
+	return s
+}
+
+///////////////////////////////////////////////
@@ -29,6 +29,7 @@ import(
 	"io/ioutil"
 	"math/rand"
 	"sort"
+	"sync"
 )
 
 //////////////////////////////////////////////////////
@@ -52,7 +53,7 @@ func Run(variant int, protocol int, bookblob *[]byte) {
 
 	ClearBook()
 
-	LoadSimpleBook(bookblob)
+	bookerr := LoadSimpleBook(bookblob)
 
 	/*if protocol == PROTOCOL_XBOARD {
 		UseBook = true
@@ -74,6 +75,10 @@ func Run(variant int, protocol int, bookblob *[]byte) {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.Lshortfile)
 
+	if bookerr != nil {
+		log.Println(bookerr)
+	}
+
 	// command interpreter main loop
 	scan := bufio.NewScanner(os.Stdin)
 
@@ -290,6 +295,8 @@ type UCI struct {
 	ponder chan struct{}
 	// predicted position hash after 2 moves
 	predicted uint64
+	// mate length requested by go mate, 0 for a normal search
+	mate int
 }
 
 var MakeAnalyzedMove bool = false
@@ -325,11 +332,15 @@ type BookMoveEntry struct {
 	Nodes int
 	// reserved for future use
 	Int1 int // annotation
-	Int2 int
+	Int2 int // confidence of Eval in percent, set by minimaxing with BookConfidence
 	Int3 int
 	Str1 string
 	Str2 string
 	Str3 string
+	// results of the games the move was played in, from the point of view of the side making it
+	Wins int `json:",omitempty"`
+	Draws int `json:",omitempty"`
+	Losses int `json:",omitempty"`
 }
 
 // moventries type
@@ -354,6 +365,9 @@ type BookMainEntry struct {
 // book
 var Book BookMainEntry
 
+// protects Book, move entries are copied on write so that readers can keep them
+var bookLock sync.RWMutex
+
 // simple book
 var SimpleBook map[string]string
 
@@ -375,15 +389,37 @@ var UseBook = false
 // flag indicating that a book has been loaded
 var BookLoaded = false
 
+// default book file by variant
+var BOOK_DEFAULT_FILES = [...]string{
+	"book.txt",
+	"book_racingkings.txt",
+	"book_atomic.txt",
+	"book_horde.txt",
+}
+
+// default simple book file by variant
+var SIMPLE_BOOK_DEFAULT_FILES = [...]string{
+	"simplebook.txt",
+	"simplebook_racingkings.txt",
+	"simplebook_atomic.txt",
+	"simplebook_horde.txt",
+}
+
+// BookFile : book file, empty for the default of the book's variant
+var BookFile = ""
+
+// SimpleBookFile : simple book file, empty for the default of the book's variant
+var SimpleBookFile = ""
+
+// variant of the positions in Book
+var BookVariant = VARIANT_Standard
+
 // count miminaxing
 var MinimaxCnt = 0
 
 // save book after certain number of minimaxes
 var SaveBookAfterMinimaxCnt = 5
 
-// book building under way
-var BookBuildingUnderWay = false
-
 // multipv mode
 var MultiPV = 1
 
@@ -481,6 +517,53 @@ var MinSimpleBookNodes = 3
 // max number of legal moves per position
 var MAX_LEGAL_MOVES = 500
 
+// maximum skill level, at this level the engine plays at full strength
+const MAX_SKILL_LEVEL = 20
+
+// number of candidate lines searched when playing with reduced strength
+const SKILL_MULTIPV = 4
+
+// skill level set by the Skill Level option
+var SkillLevel = MAX_SKILL_LEVEL
+
+// limit strength to UCI_Elo
+var UCI_LimitStrength = false
+
+// elo used when UCI_LimitStrength is set, zero means the variant's minimum elo
+var UCI_Elo = 0
+
+// skill calibration holds the parameters of weaker play for a variant
+type SkillCalibration struct {
+	// elo corresponding to skill level 0
+	MinElo int
+	// elo corresponding to MAX_SKILL_LEVEL
+	MaxElo int
+	// score margin in centipawns allowed below the best move per missing skill level
+	Margin int32
+	// probability of a deliberate blunder in percent at skill level 0
+	BlunderPercent int
+	// maximum search depth at skill level 0
+	MinDepth int32
+	// skill levels needed for one more ply of depth
+	LevelsPerDepth int
+	// maximum number of nodes at skill level 0, doubled every two skill levels
+	MinNodes uint64
+}
+
+// skill calibrations by variant
+// blunders decide games much faster in Atomic and Racing Kings
+// so these variants get smaller margins and blunder probabilities
+var SKILL_CALIBRATIONS = [...]SkillCalibration{
+	// Standard
+	SkillCalibration{ MinElo: 1350, MaxElo: 2850, Margin: 15, BlunderPercent: 12, MinDepth: 1, LevelsPerDepth: 2, MinNodes: 2000 },
+	// Racing Kings
+	SkillCalibration{ MinElo: 1000, MaxElo: 2400, Margin: 12, BlunderPercent: 8, MinDepth: 2, LevelsPerDepth: 2, MinNodes: 4000 },
+	// Atomic
+	SkillCalibration{ MinElo: 1200, MaxElo: 2600, Margin: 8, BlunderPercent: 5, MinDepth: 2, LevelsPerDepth: 3, MinNodes: 3000 },
+	// Horde
+	SkillCalibration{ MinElo: 1100, MaxElo: 2500, Margin: 15, BlunderPercent: 10, MinDepth: 1, LevelsPerDepth: 2, MinNodes: 2000 },
+}
+
 // end definitions
 ///////////////////////////////////////////////
 
@@ -558,6 +641,141 @@ func (ul *uciLogger) CreateMultiPVItem(stats Stats, score int32, line []Move) Mu
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// GetSkillLevel : get the effective skill level
+// if UCI_LimitStrength is set the level is calculated from UCI_Elo
+// using the calibration of the current variant
+// <- int : skill level between 0 and MAX_SKILL_LEVEL
+
+func GetSkillLevel() int {
+	level := SkillLevel
+	if UCI_LimitStrength {
+		cal := SKILL_CALIBRATIONS[Variant]
+		elo := UCI_Elo
+		if elo < cal.MinElo {
+			elo = cal.MinElo
+		}
+		if elo > cal.MaxElo {
+			elo = cal.MaxElo
+		}
+		level = ( elo - cal.MinElo ) * MAX_SKILL_LEVEL / ( cal.MaxElo - cal.MinElo )
+	}
+	if level < 0 {
+		return 0
+	}
+	if level > MAX_SKILL_LEVEL {
+		return MAX_SKILL_LEVEL
+	}
+	return level
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// IsSkillLimited : check if the engine should play with reduced strength
+// <- bool : true if strength is limited
+
+func IsSkillLimited() bool {
+	return GetSkillLevel() < MAX_SKILL_LEVEL
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// SearchMultiPV : number of pv lines to be searched
+// with limited strength at least SKILL_MULTIPV candidates are searched
+// <- int : number of pv lines
+
+func SearchMultiPV() int {
+	if IsSkillLimited() && ( MultiPV < SKILL_MULTIPV ) {
+		return SKILL_MULTIPV
+	}
+	return MultiPV
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ApplySkillLimits : cap depth and nodes of time control according to skill level
+// -> tc *TimeControl : time control
+
+func ApplySkillLimits(tc *TimeControl) {
+	if !IsSkillLimited() {
+		return
+	}
+	level := GetSkillLevel()
+	cal := SKILL_CALIBRATIONS[Variant]
+	depth := cal.MinDepth + int32(level / cal.LevelsPerDepth)
+	if tc.Depth > depth {
+		tc.Depth = depth
+	}
+	nodes := cal.MinNodes << uint(level / 2)
+	if ( tc.Nodes == 0 ) || ( tc.Nodes > nodes ) {
+		tc.Nodes = nodes
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// PickSkillMove : pick a line among the multipv candidates according to skill level
+// candidates within a skill dependent margin of the best score are selected randomly,
+// with a skill dependent probability a deliberate blunder is made
+// -> mpvl *MultiPVItemList : multipv item list
+// <- []Move : selected line
+
+func (mpvl *MultiPVItemList) PickSkillMove() []Move {
+	if !mpvl.HasScore() {
+		return []Move{}
+	}
+	mpvl.Sort()
+	cal := SKILL_CALIBRATIONS[Variant]
+	missing := MAX_SKILL_LEVEL - GetSkillLevel()
+	best := (*mpvl)[0]
+
+	candidates := MultiPVItemList{}
+	for _ , item := range *mpvl {
+		if len(item.Line) > 0 {
+			candidates = append(candidates, item)
+		}
+	}
+	if len(candidates) <= 1 {
+		return best.Line
+	}
+
+	// deliberate blunder, any of the candidates can be played
+	if Rand.Intn(100 * MAX_SKILL_LEVEL) < cal.BlunderPercent * missing {
+		return candidates[Rand.Intn(len(candidates))].Line
+	}
+
+	// weighted selection among the candidates within margin
+	// the closer to the best score, the higher the weight
+	margin := cal.Margin * int32(missing)
+	weights := []int32{}
+	total := int32(0)
+	for _ , item := range candidates {
+		weight := int32(0)
+		if diff := best.Score - item.Score; diff <= margin {
+			weight = margin - diff + 1
+		}
+		weights = append(weights, weight)
+		total += weight
+	}
+	if total <= 0 {
+		return candidates[0].Line
+	}
+	r := Rand.Int31n(total)
+	for i , weight := range weights {
+		if r < weight {
+			return candidates[i].Line
+		}
+		r -= weight
+	}
+	return best.Line
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // ZobristStr : get the Zobrist key of the position as string
 // -> pos *Position : position
@@ -569,14 +787,35 @@ func (pos *Position) ZobristStr() string {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// Copy : copies the move entries, so that the copy can be changed while the original is read
+// -> mentries BookMoveEntries : move entries
+// <- BookMoveEntries : copy
+
+func (mentries BookMoveEntries) Copy() BookMoveEntries {
+	copied := make(BookMoveEntries, len(mentries))
+	for algeb , mentry := range mentries {
+		copied[algeb] = mentry
+	}
+	return copied
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // GetBookEntry : get the book entry for position
+// positions not in memory are looked up in the binary book, if any
 // -> pos *Position : position
 // <- BookPositionEntry : book position entry
 // <- bool : true if position is in the book
 
 func (pos *Position) GetBookEntry() ( BookPositionEntry , bool ) {
+	bookLock.RLock()
 	posentry , found := Book.PositionEntries[pos.ZobristStr()]
+	bookLock.RUnlock()
+	if !found && ( BookStore != nil ) {
+		posentry , found = BookStore.Lookup(pos.Zobrist())
+	}
 	return posentry , found
 }
 
@@ -599,6 +838,7 @@ func (mentry *BookMoveEntry) GetEval() int {
 
 ///////////////////////////////////////////////
 // GetSortedMoveEntryList : get sorted move entry list for position entry
+// unscored moves imported from games come after the scored ones
 // -> bentry *BookPositionEntry : position entry
 // <- []BookMoveEntry : sorted move entry list
 
@@ -608,7 +848,7 @@ func (posentry *BookPositionEntry) GetSortedMoveEntryList() []BookMoveEntry {
 		// sort
 		inserted := false
 		for i := 0 ; i < len(mentrylist) ; i++ {
-			greater := ( mentry.GetEval() > mentrylist[i].GetEval() )
+			greater := ( mentry.ChoiceEval() > mentrylist[i].ChoiceEval() )
 			if ( mentry.Int1 != 0 ) && ( mentrylist[i].Int1 != 0 )	{
 				// both moves annotated
 				if mentry.Int1 != mentrylist[i].Int1 {
@@ -622,6 +862,10 @@ func (posentry *BookPositionEntry) GetSortedMoveEntryList() []BookMoveEntry {
 				// second move is annotated
 				greater = mentrylist[i].Int1 < 0
 			}
+			if mentry.Unscored() != mentrylist[i].Unscored() {
+				// their eval means nothing
+				greater = mentrylist[i].Unscored()
+			}
 			if greater {
 				sorted := []BookMoveEntry{}
 				for j := 0 ; j < i ; j++ {
@@ -711,7 +955,19 @@ func (mentry *BookMoveEntry) ToPrintable(pos *Position) string {
 			mstr = move.LAN()
 		}
 	}
-	return fmt.Sprintf(" ( %3s , %5d ) %8s %5s", SignedScore(mentry.Int1), mentry.Nodes, mstr, evalstr)
+	printable := fmt.Sprintf(" ( %3s , %5d ) %8s %5s", SignedScore(mentry.Int1), mentry.Nodes, mstr, evalstr)
+	if BookConfidence {
+		mark := " "
+		if mentry.Confidence() < BookLowConfidence {
+			// under-explored
+			mark = "?"
+		}
+		printable += fmt.Sprintf(" %3d%%%s", mentry.Confidence(), mark)
+	}
+	if mentry.Games() > 0 {
+		printable += fmt.Sprintf(" [ +%d =%d -%d ]", mentry.Wins, mentry.Draws, mentry.Losses)
+	}
+	return printable
 }
 
 ///////////////////////////////////////////////
@@ -783,7 +1039,10 @@ func (pos *Position) CalcLine(moves []Move) string {
 
 func (pos *Position) BookMovesToPrintable() string {
 	pentry , found := pos.GetBookEntry()
-	buff := fmt.Sprintf("book moves for position ( book size %d positions ) :", len(Book.PositionEntries))
+	bookLock.RLock()
+	size := len(Book.PositionEntries)
+	bookLock.RUnlock()
+	buff := fmt.Sprintf("book moves for position ( book size %d positions ) :", size)
 	if !found {
 		buff += " <none>\n"
 		return buff
@@ -844,6 +1103,8 @@ func (pos *Position) GetMoveEntry(algeb string) ( BookMoveEntry , bool ) {
 // -> pos *Position : position
 
 func (pos *Position) DeletePositionEntryMoves() {
+	bookLock.Lock()
+	defer bookLock.Unlock()
 	Book.PositionEntries[pos.ZobristStr()] = BookPositionEntry{
 		MoveEntries : make(BookMoveEntries),
 	}
@@ -858,158 +1119,283 @@ func (pos *Position) DeletePositionEntryMoves() {
 // -> mentry BookMoveEntry : move entry
 
 func (pos *Position) StoreMoveEntry(algeb string, mentry BookMoveEntry) {
-	pentry , pfound := pos.GetBookEntry()
-	if !pfound {
-		pentry = BookPositionEntry{
-			MoveEntries : make(BookMoveEntries),
-		}
+	bookLock.Lock()
+	defer bookLock.Unlock()
+	pentry , pfound := Book.PositionEntries[pos.ZobristStr()]
+	if !pfound && ( BookStore != nil ) {
+		pentry , pfound = BookStore.Lookup(pos.Zobrist())
 	}
+	pentry.MoveEntries = pentry.MoveEntries.Copy()
 	pentry.MoveEntries[algeb] = mentry
 	pentry.Fen = pos.String()
 	Book.PositionEntries[pos.ZobristStr()] = pentry
+	if BookStore != nil {
+		// the binary book is appended to instead of being rewritten
+		update := BookPositionEntry{
+			MoveEntries : BookMoveEntries{algeb : mentry},
+		}
+		if !pfound {
+			update.Fen = pentry.Fen
+		}
+		if err := BookStore.Append(pos.Zobrist(), update); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// ClearBook : creates Book as an empty book
+// ClearBook : creates Book as an empty book of the current variant
 
 func ClearBook() {
+	bookLock.Lock()
+	defer bookLock.Unlock()
 	Book.PositionEntries = make(map[string]BookPositionEntry)
+	BookVariant = Variant
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GetBookFile : get the file of Book
+// the default depends on the variant of the book, not the current variant,
+// so that switching variants never overwrites the book of another variant
+// <- string : file path
+
+func GetBookFile() string {
+	return GetVariantBookFile(BookVariant)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GetVariantBookFile : get the book file of a variant
+// -> variant int : variant
+// <- string : file path
+
+func GetVariantBookFile(variant int) string {
+	if BookFile != "" {
+		return BookFile
+	}
+	return BOOK_DEFAULT_FILES[variant]
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GetSimpleBookFile : get the file of the simple book built from Book
+// <- string : file path
+
+func GetSimpleBookFile() string {
+	if SimpleBookFile != "" {
+		return SimpleBookFile
+	}
+	return SIMPLE_BOOK_DEFAULT_FILES[BookVariant]
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
 // SaveBook : saves Book to disk
+// <- error : error
 
-func SaveBook() {
-	f,err:=os.Create("book.txt")
-	if err!=nil {
-		panic(err)
-	} else {
-		//b , err := json.MarshalIndent(Book, "", "    ")
-		b , err := json.Marshal(Book)
-		if err != nil {
-			panic(err)
-		}
-		f.Write(b)
-		f.Close()
+func SaveBook() error {
+	path := GetBookFile()
+	if strings.HasSuffix(path, BINARY_BOOK_EXTENSION) {
+		return SaveBinaryBook(path)
+	}
+	// positions only in the binary book are included, the copy is marshalled without locking
+	book := BookMainEntry{PositionEntries: make(map[string]BookPositionEntry)}
+	err := RangeBook(func(zobriststr string, posentry BookPositionEntry) {
+		book.PositionEntries[zobriststr] = posentry
+	})
+	if err != nil {
+		return err
 	}
+	//b , err := json.MarshalIndent(book, "", "    ")
+	b , err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
 // SaveSimpleBook : saves simple book to disk
+// <- error : error
 
-func SaveSimpleBook() {
+func SaveSimpleBook() error {
 	uci.SetVariant(VARIANT_CURRENT)
 	PrintBookPage()
-	MinimaxOutVerbose()
-	f,err:=os.Create("simplebook.txt")
-	if err!=nil {
-		panic(err)
-	} else {
-		simplebook := make(map[string]string)
-		poscnt := 0
-		for zobriststr, posentry := range Book.PositionEntries {
-			mentrylist := posentry.GetSortedMoveEntryList()
-			if len(mentrylist) > 0 {
-				bestentry := mentrylist[0]
-				if bestentry.Nodes >= MinSimpleBookNodes {
-					simplebook[zobriststr] = bestentry.Algeb
-					poscnt++
-				}
+	MinimaxOutVerbose(uci.Engine.Position)
+	simplebook := make(map[string]string)
+	poscnt := 0
+	err := RangeBook(func(zobriststr string, posentry BookPositionEntry) {
+		mentrylist := posentry.GetSortedMoveEntryList()
+		// unscored moves are sorted last, if the first is unscored all are
+		if ( len(mentrylist) > 0 ) && !mentrylist[0].Unscored() {
+			bestentry := mentrylist[0]
+			if bestentry.Nodes >= MinSimpleBookNodes {
+				simplebook[zobriststr] = bestentry.Algeb
+				poscnt++
 			}
 		}
-		fmt.Printf("number of positions %d\n", poscnt)
-		b , err := json.Marshal(simplebook)
-		if err != nil {
-			panic(err)
-		}
-		f.Write(b)
-		f.Close()
+	})
+	if err != nil {
+		return err
 	}
+	fmt.Printf("number of positions %d\n", poscnt)
+	b , err := json.Marshal(simplebook)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(GetSimpleBookFile(), b, 0644)
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
 // SaveBookVerbose : saves Book to disk and reports it
+// <- error : error
 
-func SaveBookVerbose() {
-	fmt.Printf("saving book ... ")
-	SaveBook()
+func SaveBookVerbose() error {
+	fmt.Printf("saving book %s ... ", GetBookFile())
+	if err := SaveBook(); err != nil {
+		fmt.Printf("failed: %v\n", err)
+		return err
+	}
 	fmt.Printf("done\n")
+	return nil
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
 // SaveBookAuto : saves Book to disk if book has been loaded from disk before
+// <- error : error
 
-func SaveBookAuto() {
+func SaveBookAuto() error {
 	if BookLoaded {
-		SaveBook()
+		return SaveBook()
 	}
+	return nil
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
 // SaveBookAuto : saves Book to disk if book has been loaded from disk before and reports it
+// <- error : error
 
-func SaveBookAutoVerbose() {
+func SaveBookAutoVerbose() error {
 	if BookLoaded {
 		fmt.Printf("auto ")
-		SaveBookVerbose()
+		return SaveBookVerbose()
 	}
+	return nil
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
 // LoadBookVerbose : loads Book from disk and reports is
+// <- error : error
 
-func LoadBookVerbose() {
+func LoadBookVerbose() error {
 	fmt.Printf("loading book ... ")
-	LoadBook()
-	fmt.Printf("done\n")
+	if err := LoadBook(); err != nil {
+		fmt.Printf("failed: %v\n", err)
+		return err
+	}
+	fmt.Printf("%s done\n", GetBookFile())
+	return nil
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// LoadBook : loads Book from disk
+// LoadBook : loads Book of the current variant from disk, Book is unchanged on error
+// a binary book is opened, its positions are read on demand
+// <- error : error
 
-func LoadBook() {
-	jsonBlob , err := ioutil.ReadFile("book.txt")
+func LoadBook() error {
+	path := GetVariantBookFile(Variant)
+	if strings.HasSuffix(path, BINARY_BOOK_EXTENSION) {
+		bb, err := OpenBinaryBook(path)
+		if err != nil {
+			return err
+		}
+		CloseBookStore()
+		ClearBook()
+		BookStore = bb
+		BookLoaded = true
+		return nil
+	}
+	jsonBlob , err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = json.Unmarshal(jsonBlob, &Book)
+	book := BookMainEntry{}
+	err = json.Unmarshal(jsonBlob, &book)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if book.PositionEntries == nil {
+		book.PositionEntries = make(map[string]BookPositionEntry)
 	}
+	CloseBookStore()
+	bookLock.Lock()
+	Book = book
+	bookLock.Unlock()
+	BookVariant = Variant
 	BookLoaded = true
+	return nil
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// LoadSimpleBook : loads simple book
+// LoadSimpleBook : loads simple book, SimpleBook is unchanged on error
+// -> bookblob *[]byte : json blob, nil for an empty book
+// <- error : error
 
-func LoadSimpleBook(bookblob *[]byte) {
+func LoadSimpleBook(bookblob *[]byte) error {
 	if bookblob == nil {
 		SimpleBook = make(map[string]string)
-		return
+		return nil
 	}
-	err := json.Unmarshal(*bookblob, &SimpleBook)
+	simplebook := make(map[string]string)
+	err := json.Unmarshal(*bookblob, &simplebook)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	SimpleBook = simplebook
 	//fmt.Printf("simple book size %d positions\n", len(SimpleBook))
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadSimpleBookFile : loads simple book from file, empty reloads the built in book
+// -> path string : file path
+// <- error : error
+
+func LoadSimpleBookFile(path string) error {
+	if path == "" {
+		return LoadSimpleBook(BookJsonBlob)
+	}
+	jsonBlob , err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := LoadSimpleBook(&jsonBlob); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
 }
 
 ///////////////////////////////////////////////
@@ -1035,72 +1421,97 @@ func AddNodeRecursive(depth int, line string) bool {
 	if depth >= MAX_BOOK_DEPTH {
 		return false
 	}
-	pos := uci.Engine.Position
-	mentrylist := pos.GetSortedMoveEntryList()
-	if len(mentrylist) <= 0 {
-		return AddMove(line)
-	} else if IsBookCutOff(int32(mentrylist[0].Score)) {
+	move , ok := WalkBookNode(uci.Engine.Position, depth, Rand)
+	if !ok {
 		return false
-	} else {
-		for _ , mentry := range mentrylist {
-			algeb := mentry.Algeb
+	}
+	if move == NullMove {
+		return AddMove(line)
+	}
+	uci.Engine.DoMove(move)
+	res := AddNodeRecursive(depth+1, line+" "+move.UCI())
+	uci.Engine.UndoMove()
+	return res
+}
 
-			r := Rand.Intn(100)
-			limit := SelectLimits[depth]
+///////////////////////////////////////////////
 
-			numlegals := len(pos.GetLegalMoves(GET_ALL))
+///////////////////////////////////////////////
+// WalkBookNode : decides how a random walk down the book continues at a position
+// book moves are followed at random with the probabilities of SelectLimits
+// -> pos *Position : position
+// -> depth int : depth of the position in the walk
+// -> rnd *rand.Rand : random source
+// <- Move : move to follow, NullMove if the position should get a new book move
+// <- bool : false if the walk ends without adding a move
+
+func WalkBookNode(pos *Position, depth int, rnd *rand.Rand) (Move, bool) {
+	mentrylist := pos.GetSortedMoveEntryList()
+	if len(mentrylist) <= 0 {
+		return NullMove, true
+	} else if !mentrylist[0].Unscored() && IsBookCutOff(int32(mentrylist[0].Score)) {
+		// unscored moves are sorted last, the cutoff is decided by the best scored move
+		return NullMove, false
+	}
+	for _ , mentry := range mentrylist {
+		algeb := mentry.Algeb
 
-			if numlegals > 0 {
+		r := rnd.Intn(100)
+		limit := SelectLimits[depth]
 
-				fairshare := 100 / numlegals
+		numlegals := len(pos.GetLegalMoves(GET_ALL))
 
-				share := 100 - limit
+		if numlegals > 0 {
 
-				if share < fairshare {
-					limit = 100 - fairshare
-				}
+			fairshare := 100 / numlegals
 
+			share := 100 - limit
+
+			if share < fairshare {
+				limit = 100 - fairshare
 			}
 
-			randok := ( r > limit )
+		}
 
-			version := mentry.BookVersion
-			versionok := ( version <= BookVersion )
+		randok := ( r > limit )
 
-			score := int32(mentry.Score)
-			scoreok := ( !IsBookCutOff(score) )
+		version := mentry.BookVersion
+		versionok := ( version <= BookVersion )
 
-			selectok := ( randok && scoreok )
+		score := int32(mentry.Score)
+		scoreok := ( !IsBookCutOff(score) )
 
-			if !versionok {
-				pos.DeletePositionEntryMoves()
-				break
-			} else if selectok {
-				move , err := uci.Engine.Position.UCIToMove(algeb)
-				if err == nil {
-					uci.Engine.DoMove(move)
-					res := AddNodeRecursive(depth+1, line+" "+algeb)
-					uci.Engine.UndoMove()
-					return res
-				}
+		selectok := ( randok && scoreok )
+
+		if !versionok {
+			pos.DeletePositionEntryMoves()
+			break
+		} else if selectok {
+			move , err := pos.UCIToMove(algeb)
+			if err == nil {
+				return move, true
 			}
 		}
-
-		return AddMove(line)
 	}
+
+	return NullMove, true
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// MinimaxOut : minimax out book wrt current position recursively
+// MinimaxOut : minimax out book wrt position recursively
+// -> pos *Position : position, restored on return
 // -> depth int : depth
 // -> line []uint64 : line in Zobrist keys
 // <- int : eval
 
 var MinimaxNodes = 0
 
-func MinimaxOutRecursive(depth int, line []uint64) int {
+// number of book moves leading to positions not in the book, counted by minimaxing
+var MinimaxLeaves = 0
+
+func MinimaxOutRecursive(pos *Position, depth int, line []uint64) int {
 	MinimaxNodes++
 	if depth > MinimaxMaxDepth {
 		MinimaxMaxDepth = depth
@@ -1109,7 +1520,6 @@ func MinimaxOutRecursive(depth int, line []uint64) int {
 	if depth >= MAX_BOOK_DEPTH {
 		return alpha
 	}
-	pos := uci.Engine.Position
 	zobrist := pos.Zobrist()
 	for _, z := range line {
 		if zobrist == z {
@@ -1117,15 +1527,24 @@ func MinimaxOutRecursive(depth int, line []uint64) int {
 		}
 	}
 	pentry , found := pos.GetBookEntry()
-	if found {
+	if !found {
+		MinimaxLeaves++
+	} else {
+		// the move entries are copied, readers may hold the old ones
+		pentry.MoveEntries = pentry.MoveEntries.Copy()
 		for algeb , mentry := range pentry.MoveEntries {
 			score := mentry.Score
 			move , err := pos.UCIToMove(algeb)
 			if err == nil {
 				startnodes := MinimaxNodes
-				uci.Engine.DoMove(move)
-				eval := -MinimaxOutRecursive(depth+1, append(line, zobrist))
+				pos.DoMove(move)
+				eval := -MinimaxOutRecursive(pos, depth+1, append(line, zobrist))
+				pos.UndoMove()
 				if eval == int(InfinityScore) {
+					if mentry.Unscored() {
+						// a leaf imported from games has no score to back up
+						continue
+					}
 					eval = score
 				}
 				mentry.Eval = eval
@@ -1136,10 +1555,9 @@ func MinimaxOutRecursive(depth int, line []uint64) int {
 					alpha = eval
 				}
 				pentry.MoveEntries[algeb] = mentry
-				uci.Engine.UndoMove()
 			}
 		}
-		Book.PositionEntries[pos.ZobristStr()] = pentry
+		pos.StoreMinimaxEvals(pentry)
 	}
 	return alpha
 }
@@ -1147,102 +1565,98 @@ func MinimaxOutRecursive(depth int, line []uint64) int {
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// MinimaxOut : minimax out book wrt current position
-// <- int : eval
-
-func MinimaxOut() int {
-	MinimaxNodes = 0
-	MinimaxMaxDepth = 0
-	return MinimaxOutRecursive(0,[]uint64{})
+// StoreMinimaxEvals : stores the minimaxed evals of a position entry
+// moves added since the entry was read, e.g. by book building workers, are kept
+// with a binary book only the changed moves are appended to it, positions are not loaded into memory
+// -> pos *Position : position
+// -> pentry BookPositionEntry : position entry with minimaxed evals
+
+func (pos *Position) StoreMinimaxEvals(pentry BookPositionEntry) {
+	bookLock.Lock()
+	defer bookLock.Unlock()
+	current , found := Book.PositionEntries[pos.ZobristStr()]
+	if BookStore != nil {
+		stored := current
+		if !found {
+			stored , _ = BookStore.Lookup(pos.Zobrist())
+		}
+		update := BookPositionEntry{
+			MoveEntries : make(BookMoveEntries),
+		}
+		for algeb , mentry := range pentry.MoveEntries {
+			if old , ok := stored.MoveEntries[algeb]; ok {
+				if ( old.Eval != mentry.Eval ) || ( old.HasEval != mentry.HasEval ) || ( old.Nodes != mentry.Nodes ) || ( old.Int2 != mentry.Int2 ) {
+					old.Eval, old.HasEval, old.Nodes, old.Int2 = mentry.Eval, mentry.HasEval, mentry.Nodes, mentry.Int2
+					update.MoveEntries[algeb] = old
+				}
+			}
+		}
+		if len(update.MoveEntries) == 0 {
+			return
+		}
+		if found {
+			// positions in memory take precedence over the binary book
+			current.MoveEntries = current.MoveEntries.Copy()
+			mergeBookEntry(&current, update)
+			Book.PositionEntries[pos.ZobristStr()] = current
+		}
+		if err := BookStore.Append(pos.Zobrist(), update); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	if found {
+		current.MoveEntries = current.MoveEntries.Copy()
+		for algeb , mentry := range pentry.MoveEntries {
+			if stored , ok := current.MoveEntries[algeb]; ok {
+				stored.Eval, stored.HasEval, stored.Nodes, stored.Int2 = mentry.Eval, mentry.HasEval, mentry.Nodes, mentry.Int2
+				current.MoveEntries[algeb] = stored
+			}
+		}
+		pentry = current
+	}
+	Book.PositionEntries[pos.ZobristStr()] = pentry
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// MinimaxOutVerbose : minimax out book wrt current position and report it
+// MinimaxOut : minimax out book wrt position, with confidence if BookConfidence is set
+// -> pos *Position : position, restored on return
 // <- int : eval
 
-func MinimaxOutVerbose() int {
-	fmt.Printf("minimaxing out ( no %d ) ... ", MinimaxCnt)
-	eval := MinimaxOut()
-	fmt.Printf("done ( nodes %d maxdepth %d )\n", MinimaxNodes, MinimaxMaxDepth)
-	return eval
-}
-
-///////////////////////////////////////////////
-
-///////////////////////////////////////////////
-// PrintBookPage : print book page
-
-func PrintBookPage() {
-	fmt.Print(uci.Engine.Position.BookMovesToPrintable())
-}
-
-///////////////////////////////////////////////
-
-///////////////////////////////////////////////
-// BuildBook : build book, should be run in go routine
-
-var BuildBookStopped bool
-
-var BuildBookReady chan int
-
-func BuildBook() {
-	DontPrintPV = true
-	trycnt := 0
-	okcnt := 0
-	totalokcnt := 0
-	for !BuildBookStopped {
-		trycnt++
-		if AddNodeRecursive(0,"*") {
-			okcnt++
-			totalokcnt++
-			if okcnt >= 10 {
-				MinimaxCnt++
-				fmt.Println()
-				MinimaxOutVerbose()
-				PrintBookPage()
-				if ( MinimaxCnt % SaveBookAfterMinimaxCnt ) == 0 {				
-					SaveBookAutoVerbose()
-				}
-				okcnt = 0
-			}
-		}
-		// if at least one node cannot be added per 1000 tries something is wrong, better to finish
-		if ( trycnt >= 1000 ) && ( ( totalokcnt*1000 ) < trycnt ) {
-			fmt.Printf("\n\nwarning: add move fails too much, book building auto stopped\n\n")
-			break	
-		}
+func MinimaxOut(pos *Position) int {
+	if BookConfidence {
+		eval, _ := MinimaxConfidence(pos)
+		return eval
 	}
-	BuildBookReady <- 0
-	DontPrintPV = false
+	MinimaxNodes = 0
+	MinimaxLeaves = 0
+	MinimaxMaxDepth = 0
+	return MinimaxOutRecursive(pos, 0, []uint64{})
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// StartBuildBook : start building book
+// MinimaxOutVerbose : minimax out book wrt position and report it
+// -> pos *Position : position, restored on return
+// <- int : eval
 
-func StartBuildBook() {
-	BuildBookReady = make(chan int)
-	BuildBookStopped = false
-	BookBuildingUnderWay = true
-	go BuildBook()
+func MinimaxOutVerbose(pos *Position) int {
+	fmt.Printf("minimaxing out ( no %d ) ... ", MinimaxCnt)
+	eval := MinimaxOut(pos)
+	fmt.Printf("done ( nodes %d maxdepth %d )\n", MinimaxNodes, MinimaxMaxDepth)
+	return eval
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// StopBuildBook : stop building book
+// PrintBookPage : print book page
 
-func StopBuildBook() {
-	BuildBookStopped = true
-	<- BuildBookReady
-	MinimaxOutVerbose()
-	PrintBookPage()
-	SaveBookAutoVerbose()
-	fmt.Printf("book building stopped\n")
-	BookBuildingUnderWay = false
+func PrintBookPage() {
+	fmt.Print(uci.Engine.Position.BookMovesToPrintable())
 }
 
 ///////////////////////////////////////////////
@@ -1479,36 +1893,338 @@ func ExecuteTest() error {
 			PrintPieceValues()
 			return errTestOk
 		case "sb":
-			SaveBook()
+			SaveBookVerbose()
 			return errTestOk
 		case "ssb":
-			SaveSimpleBook()
+			if err := SaveSimpleBook(); err != nil {
+				fmt.Printf("saving simple book failed: %v\n", err)
+			}
 			return errTestOk
 		case "lb":
-			LoadBook()
-			PrintBookPage()
+			if LoadBookVerbose() == nil {
+				PrintBookPage()
+			}
+			return errTestOk
+		case "bookbin":
+			// bookbin <json book> <binary book>
+			if numargs < 2 {
+				fmt.Printf("usage: bookbin <json book> <binary book>\n")
+				return errTestOk
+			}
+			if n, err := ConvertBookToBinary(args[0], args[1]); err != nil {
+				fmt.Printf("conversion failed: %v\n", err)
+			} else {
+				fmt.Printf("converted %d positions\n", n)
+			}
+			return errTestOk
+		case "bookjson":
+			// bookjson <binary book> <json book>
+			if numargs < 2 {
+				fmt.Printf("usage: bookjson <binary book> <json book>\n")
+				return errTestOk
+			}
+			if n, err := ConvertBookToJSON(args[0], args[1]); err != nil {
+				fmt.Printf("conversion failed: %v\n", err)
+			} else {
+				fmt.Printf("converted %d positions\n", n)
+			}
+			return errTestOk
+		case "pgx":
+			// pgx [file], exports the book to a Polyglot book
+			path := "book.bin"
+			if numargs > 0 {
+				path = args[0]
+			}
+			if n, err := ExportPolyglot(path); err != nil {
+				fmt.Printf("export failed: %v\n", err)
+			} else {
+				fmt.Printf("exported %d entries to %s\n", n, path)
+			}
+			return errTestOk
+		case "pgl":
+			// pgl <file>, loads a Polyglot book
+			if numargs < 1 {
+				fmt.Printf("usage: pgl <file>\n")
+				return errTestOk
+			}
+			if n, err := LoadPolyglot(args[0]); err != nil {
+				fmt.Printf("loading failed: %v\n", err)
+			} else {
+				fmt.Printf("loaded %d entries\n", n)
+			}
+			return errTestOk
+		case "pgnbook":
+			// pgnbook <file> [min elo] [min count], imports the games of a PGN file into the book
+			if numargs < 1 {
+				fmt.Printf("usage: pgnbook <file> [min elo] [min count]\n")
+				return errTestOk
+			}
+			minelo, mincount := PGN_BOOK_MIN_ELO, PGN_BOOK_MIN_COUNT
+			if numargs > 1 {
+				if elo, err := strconv.Atoi(args[1]); err == nil {
+					minelo = elo
+				}
+			}
+			if numargs > 2 {
+				if count, err := strconv.Atoi(args[2]); err == nil {
+					mincount = count
+				}
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				fmt.Printf("import failed: %v\n", err)
+				return errTestOk
+			}
+			defer f.Close()
+			if summary, err := ImportPGNBook(f, minelo, mincount); err != nil {
+				fmt.Printf("import failed: %v\n", err)
+			} else {
+				fmt.Printf("%v\n", summary)
+				PrintBookPage()
+			}
+			return errTestOk
+		case "bu":
+			// bu [limit], lists the under-explored book lines from the current position
+			limit := 20
+			if numargs > 0 {
+				if n, err := strconv.Atoi(args[0]); err == nil {
+					limit = n
+				}
+			}
+			if !BookConfidence {
+				fmt.Printf("confidence is not minimaxed, set BookConfidence\n")
+				return errTestOk
+			}
+			lines := uci.Engine.Position.UnderExploredLines(limit)
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+			fmt.Printf("%d under-explored lines\n", len(lines))
+			return errTestOk
+		case "bookmerge":
+			// bookmerge <book> <book> <merged book>
+			if numargs < 3 {
+				fmt.Printf("usage: bookmerge <book> <book> <merged book>\n")
+				return errTestOk
+			}
+			a, err := ReadBookFile(args[0])
+			if err != nil {
+				fmt.Printf("merge failed: %v\n", err)
+				return errTestOk
+			}
+			b, err := ReadBookFile(args[1])
+			if err != nil {
+				fmt.Printf("merge failed: %v\n", err)
+				return errTestOk
+			}
+			merged, stats := MergeBooks(a, b)
+			if err := WriteBookFile(args[2], merged); err != nil {
+				fmt.Printf("merge failed: %v\n", err)
+				return errTestOk
+			}
+			fmt.Printf("merged %d positions : added positions %d moves %d replaced %d kept %d\n",
+				len(merged.PositionEntries), stats.Positions, stats.Added, stats.Replaced, stats.Kept)
+			return errTestOk
+		case "bookdiff":
+			// bookdiff <book> <book> [threshold]
+			if numargs < 2 {
+				fmt.Printf("usage: bookdiff <book> <book> [threshold]\n")
+				return errTestOk
+			}
+			threshold := 20
+			if numargs > 2 {
+				if n, err := strconv.Atoi(args[2]); err == nil {
+					threshold = n
+				}
+			}
+			a, err := ReadBookFile(args[0])
+			if err != nil {
+				fmt.Printf("diff failed: %v\n", err)
+				return errTestOk
+			}
+			b, err := ReadBookFile(args[1])
+			if err != nil {
+				fmt.Printf("diff failed: %v\n", err)
+				return errTestOk
+			}
+			diff := DiffBooks(a, b, threshold)
+			for _, change := range diff.Changes {
+				fmt.Println(change.ToPrintable())
+			}
+			fmt.Printf("positions only in first %d only in second %d common %d changes %d\n",
+				diff.OnlyA, diff.OnlyB, diff.Common, len(diff.Changes))
+			return errTestOk
+		case "bookprune":
+			// bookprune <book> <pruned book> <min depth> <min nodes> [window]
+			if numargs < 4 {
+				fmt.Printf("usage: bookprune <book> <pruned book> <min depth> <min nodes> [window]\n")
+				return errTestOk
+			}
+			mindepth, errdepth := strconv.Atoi(args[2])
+			minnodes, errnodes := strconv.Atoi(args[3])
+			window := -1
+			var errwindow error
+			if numargs > 4 {
+				window, errwindow = strconv.Atoi(args[4])
+			}
+			if ( errdepth != nil ) || ( errnodes != nil ) || ( errwindow != nil ) {
+				fmt.Printf("usage: bookprune <book> <pruned book> <min depth> <min nodes> [window]\n")
+				return errTestOk
+			}
+			book, err := ReadBookFile(args[0])
+			if err != nil {
+				fmt.Printf("pruning failed: %v\n", err)
+				return errTestOk
+			}
+			root, _ := PositionFromFEN(START_FENS[Variant])
+			pruned, stats := PruneBook(book, root, mindepth, minnodes, window)
+			if err := WriteBookFile(args[1], pruned); err != nil {
+				fmt.Printf("pruning failed: %v\n", err)
+				return errTestOk
+			}
+			fmt.Printf("pruned %d moves %d positions %d unreachable positions, %d positions left\n",
+				stats.Moves, stats.Positions, stats.Unreachable, len(pruned.PositionEntries))
+			return errTestOk
+		case "bookpgn":
+			// bookpgn [file], exports the book from the current position to PGN
+			path := "book.pgn"
+			if numargs > 0 {
+				path = args[0]
+			}
+			f, err := os.Create(path)
+			if err != nil {
+				fmt.Printf("export failed: %v\n", err)
+				return errTestOk
+			}
+			n, err := ExportBookPGN(f, uci.Engine.Position)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				fmt.Printf("export failed: %v\n", err)
+			} else {
+				fmt.Printf("exported %d moves to %s\n", n, path)
+			}
 			return errTestOk
 		case "an":
 			AddNodeRecursive(0,"*")
 			return errTestOk
-		case "bb":
-			StartBuildBook()
+		case "bb", "br":
+			// bb [threads] builds from the current position, br [threads] resumes from the checkpoint
+			if numargs > 0 {
+				if threads, err := strconv.Atoi(args[0]); err == nil {
+					BookBuildThreads = threads
+				}
+			}
+			resume := ( command == "br" )
+			if resume && ( LoadBookVerbose() != nil ) {
+				return errTestOk
+			}
+			if err := StartBuildBook(resume); err != nil {
+				fmt.Printf("book building failed: %v\n", err)
+			}
 			return errTestOk
 		case "mo":
-			MinimaxOutVerbose()
+			MinimaxOutVerbose(uci.Engine.Position)
 			return errTestOk
 		case "q":
-			if BookBuildingUnderWay {
+			if IsBookBuilding() {
 				StopBuildBook()
 			} else {
-				LoadBookVerbose()
-				PrintBookPage()
-				StartBuildBook()
+				if LoadBookVerbose() == nil {
+					PrintBookPage()
+					if err := StartBuildBook(false); err != nil {
+						fmt.Printf("book building failed: %v\n", err)
+					}
+				}
 			}
 			return errTestOk
 		case "bs":
 			StopBuildBook()
 			return errTestOk
+		case "tune":
+			// tune <data file> [parameter file] [iterations]
+			if numargs < 1 {
+				fmt.Printf("usage: tune <data file> [parameter file] [iterations]\n")
+				return errTestOk
+			}
+			outpath, iterations := "params.json", 100
+			if numargs > 1 {
+				outpath = args[1]
+			}
+			if numargs > 2 {
+				if n, err := strconv.Atoi(args[2]); err == nil {
+					iterations = n
+				}
+			}
+			if err := RunTuner(args[0], outpath, iterations); err != nil {
+				fmt.Printf("tuning failed: %v\n", err)
+			}
+			return errTestOk
+		case "tbgen":
+			// tbgen <material, e.g. KQvK> [directory]
+			if numargs < 1 {
+				fmt.Printf("usage: tbgen <material, e.g. KQvK> [directory]\n")
+				return errTestOk
+			}
+			dir := TablebasePath
+			if numargs > 1 {
+				dir = args[1]
+			}
+			if dir == "" {
+				dir = "."
+			}
+			tables, err := GenerateTablebase(args[0], 0, func(code string) {
+				fmt.Printf("generated %s\n", code)
+			})
+			if err == nil {
+				err = SaveTablebases(dir, tables)
+			}
+			if err == nil {
+				_, err = LoadTablebases(dir)
+			}
+			if err != nil {
+				fmt.Printf("tablebase generation failed: %v\n", err)
+			}
+			return errTestOk
+		case "datagen":
+			// datagen <out file> [games=n] [depth=n] [nodes=n] [random=n] [maxplies=n] [threads=n] [seed=n]
+			if numargs < 1 {
+				fmt.Printf("usage: datagen <out file> [games=n] [depth=n] [nodes=n] [random=n] [maxplies=n] [threads=n] [seed=n]\n")
+				return errTestOk
+			}
+			opts := DATAGEN_DEFAULTS
+			opts.Binary = strings.HasSuffix(strings.ToLower(args[0]), ".bin")
+			for _, arg := range args[1:] {
+				parts := strings.SplitN(arg, "=", 2)
+				if len(parts) < 2 {
+					continue
+				}
+				n, err := strconv.ParseInt(parts[1], 10, 64)
+				if err != nil {
+					fmt.Printf("invalid value %s\n", arg)
+					return errTestOk
+				}
+				switch parts[0] {
+					case "games": opts.Games = int(n)
+					case "depth": opts.Depth = int32(n)
+					case "nodes": opts.Nodes = uint64(n)
+					case "random": opts.RandomPlies = int(n)
+					case "maxplies": opts.MaxPlies = int(n)
+					case "threads": opts.Threads = int(n)
+					case "seed": opts.Seed = n
+				}
+			}
+			if opts.Nodes > 0 {
+				opts.Depth = 0
+			}
+			err := RunDatagen(args[0], opts, func(games int) {
+				fmt.Printf("%d games\n", games)
+			})
+			if err != nil {
+				fmt.Printf("data generation failed: %v\n", err)
+			}
+			return errTestOk
 		case "sv":
 			if numargs>0 {
 				ok := false
@@ -1567,6 +2283,8 @@ func ExecuteXboard() error {
 		return errQuit
 	case "option":
 		return uci.XBOARD_option()
+	case "egtpath":
+		return uci.XBOARD_egtpath()
 	case "force":
 			err := uci.XBOARD_force()
 			if err != nil {
@@ -1620,6 +2338,10 @@ func ExecuteXboard() error {
 			Printu(fmt.Sprintf("feature myname=\"%s by Alexandru Mosoi\""+
 			" analyze=1 variants=\"atomic\""+
 			" option=\"UseBook -button\""+
+			" option=\"PolyglotFile -file \""+
+			" option=\"BookFile -file \""+
+			" option=\"SimpleBookFile -file \""+
+			" egt=\"syzygy\""+
 			" setboard=1 usermove=1 playother=1 done=1\n",GetEngineName()))
 			XBOARD_State = XBOARD_Observing
 			return nil
@@ -1719,7 +2441,7 @@ func ExecuteXboard() error {
 func XBOARD_Error(etype, evalue string) error {
 	estr := fmt.Sprintf("Error (%s): %s", etype, evalue)
 	fmt.Printf("%s\n", estr)
-	return fmt.Errorf(estr)
+	return fmt.Errorf("%s", estr)
 }
 
 ///////////////////////////////////////////////
@@ -1739,6 +2461,46 @@ func (uci *UCI) XBOARD_option() error {
 		UseBook = true
 		//Log("use book accepted\n")
 	}
+	// file options are sent as NAME=VALUE, the file name may contain spaces
+	nameValue := strings.SplitN(strings.Join(args, " "), "=", 2)
+	if len(nameValue) < 2 {
+		return nil
+	}
+	path := nameValue[1]
+	var err error
+	switch nameValue[0] {
+	case "PolyglotFile":
+		_, err = LoadPolyglot(path)
+	case "BookFile":
+		BookFile = path
+	case "SimpleBookFile":
+		if err = LoadSimpleBookFile(path); err == nil {
+			SimpleBookFile = path
+		}
+	}
+	if err != nil {
+		return XBOARD_Error("option", err.Error())
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// XBOARD_egtpath : XBOARD egtpath command, only Syzygy tables are supported
+// -> uci *UCI : UCI
+// <- error : error
+
+func (uci *UCI) XBOARD_egtpath() error {
+	if numargs < 2 {
+		return XBOARD_Error("wrong number of arguments for egtpath",fmt.Sprintf("%d",numargs))
+	}
+	if args[0] != "syzygy" {
+		return nil
+	}
+	if _, err := LoadSyzygy(strings.Join(args[1:], " ")); err != nil {
+		return XBOARD_Error("egtpath", err.Error())
+	}
 	return nil
 }
 
@@ -2079,6 +2841,9 @@ func (uci *UCI) XBOARD_Start_Thinking() error {
 		uci.timeControl.MovesToGo = 20
 	}
 
+	// weaker play caps depth and nodes
+	ApplySkillLimits(uci.timeControl)
+
 	if ponder {
 		// ponder was requested, so fill the channel
 		// next write to uci.ponder will block
@@ -2272,7 +3037,7 @@ func (ul *uciLogger) ReportInfoString(item MultiPVItem) string {
 		elapsed := uint64(maxDuration(now.Sub(ul.start), time.Microsecond))
 		nps := item.Stats.Nodes * uint64(time.Second) / elapsed
 		millis := elapsed / uint64(time.Millisecond)
-		buff += fmt.Sprintf("nodes %d time %d nps %d ", item.Stats.Nodes, millis, nps)
+		buff += fmt.Sprintf("nodes %d time %d nps %d tbhits %d ", item.Stats.Nodes, millis, nps, item.Stats.TBHits)
 
 		// write principal variation
 		buff += fmt.Sprintf("pv")
@@ -2381,6 +3146,8 @@ func (uci *UCI) Execute(line string) error {
 		return uci.go_(line)
 	case "setoption":
 		return uci.setoption(line)
+	case "eval":
+		return uci.eval(line)
 	default:
 		return fmt.Errorf("unhandled command %s", cmd)
 	}
@@ -2449,12 +3216,35 @@ func (uci *UCI) uci(line string) error {
 	fmt.Printf("option name MultiPV type spin default 1 min 1 max 500\n")
 	fmt.Printf("option name ClearHash type button\n")
 	fmt.Printf("option name UseBook type button\n")
+	fmt.Printf("option name PolyglotFile type string default <empty>\n")
+	fmt.Printf("option name BookFile type string default %s\n", BOOK_DEFAULT_FILES[Variant])
+	fmt.Printf("option name SimpleBookFile type string default <empty>\n")
+	fmt.Printf("option name BookConfidence type check default %v\n", BookConfidence)
+	fmt.Printf("option name BookDrawValue type spin default %d min -100 max 100\n", BookDrawValue)
+	fmt.Printf("option name BookGameWeight type spin default %d min 0 max 100\n", BookGameWeight)
+	fmt.Printf("option name Skill Level type spin default %d min 0 max %d\n", MAX_SKILL_LEVEL, MAX_SKILL_LEVEL)
+	fmt.Printf("option name UCI_LimitStrength type check default false\n")
+	cal := SKILL_CALIBRATIONS[Variant]
+	fmt.Printf("option name UCI_Elo type spin default %d min %d max %d\n", cal.MinElo, cal.MinElo, cal.MaxElo)
+	fmt.Printf("option name Contempt type spin default %d min -100 max 100\n", ContemptOptionDefault())
+	fmt.Printf("option name Dynamic Contempt type check default false\n")
+	fmt.Printf("option name EvalFile type string default <empty>\n")
+	fmt.Printf("option name UseNNUE type check default %v\n", UseNNUE)
+	fmt.Printf("option name NNUEFile type string default %s\n", NNUE_DEFAULT_FILES[Variant])
+	fmt.Printf("option name TablebasePath type string default <empty>\n")
+	fmt.Printf("option name SyzygyPath type string default <empty>\n")
+	for _, knob := range SEARCH_KNOBS {
+		fmt.Printf("option name %s type spin default %d min %d max %d\n", knob.Name, *knob.Value, knob.Min, knob.Max)
+	}
 	if IS_Racing_Kings {
 		for piece:=Knight ; piece<King ; piece++ {
 			fmt.Printf("option name %s Value type spin default %d min 0 max 1000\n", 
 					FigureToName[piece],RK_PIECE_VALUES[piece])
 		}
 		fmt.Printf("option name King Advance Value type spin default %d min 0 max 1000\n", KING_ADVANCE_VALUE)
+		fmt.Printf("option name Race Distance Value type spin default %d min 0 max 1000\n", RK_RACE_DISTANCE_VALUE)
+		fmt.Printf("option name Race Tempo Value type spin default %d min 0 max 1000\n", RK_RACE_TEMPO_VALUE)
+		fmt.Printf("option name Forward Control Value type spin default %d min 0 max 1000\n", RK_FORWARD_CONTROL_VALUE)
 	}
 	fmt.Println("uciok")
 	return nil
@@ -2559,7 +3349,7 @@ func (pos *Position) GetSimpleBookMove() (string, bool) {
 func (uci *UCI) go_(line string) error {
 	if UseBook {
 		pos := uci.Engine.Position
-		algeb, found := pos.GetSimpleBookMove()
+		algeb, found := pos.GetBookMove()
 		if found && UseBook {
 			_ , err := pos.UCIToMove(algeb)
 			if err == nil {
@@ -2573,6 +3363,7 @@ func (uci *UCI) go_(line string) error {
 	uci.timeControl = NewTimeControl(uci.Engine.Position, predicted)
 	uci.timeControl.MovesToGo = 30 // in case there is not time refresh
 	ponder := false
+	uci.mate = 0
 
 	args := strings.Fields(line)[1:]
 	for i := 0; i < len(args); i++ {
@@ -2613,9 +3404,20 @@ func (uci *UCI) go_(line string) error {
 			i++
 			d, _ := strconv.Atoi(args[i])
 			uci.timeControl.Depth = int32(d)
+		case "nodes":
+			i++
+			n, _ := strconv.ParseUint(args[i], 10, 64)
+			uci.timeControl.Nodes = n
+		case "mate":
+			i++
+			n, _ := strconv.Atoi(args[i])
+			uci.mate = n
 		}
 	}
 
+	// weaker play caps depth and nodes
+	ApplySkillLimits(uci.timeControl)
+
 	if ponder {
 		// ponder was requested, so fill the channel
 		// next write to uci.ponder will block
@@ -2628,6 +3430,20 @@ func (uci *UCI) go_(line string) error {
 	return nil
 }
 
+///////////////////////////////////////////////
+// eval : eval command, prints the evaluation breakdown of the current position
+// -> uci *UCI : UCI
+// -> line string : command line
+// <- error : error
+
+func (uci *UCI) eval(line string) error {
+	breakdown := EvaluateBreakdown(uci.Engine.Position)
+	fmt.Print(breakdown.String())
+	return nil
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // ponderhit : ponderhit command
 // -> uci *UCI : UCI
@@ -2667,6 +3483,37 @@ func (uci *UCI) stop(line string) error {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// playMate : searches for a mate requested by go mate
+// falls back to a normal search limited to the depth of the mate if no mate was proven
+// -> uci *UCI : UCI
+// <- []Move : best line
+
+func (uci *UCI) playMate() []Move {
+	start := time.Now()
+	result := uci.Engine.FindMate(uci.mate, uci.timeControl)
+	if !result.Proven {
+		Printu(fmt.Sprintf("info string no mate in %d proven nodes %d\n", uci.mate, result.Nodes))
+		// a bare go mate has no time limit, the regular search only looks as deep as the mate
+		if depth := int32(2*uci.mate - 1); uci.timeControl.Depth > depth {
+			uci.timeControl.Depth = depth
+		}
+		return uci.Engine.Play(uci.timeControl, IgnoreMoves)
+	}
+
+	buff := fmt.Sprintf("info depth %d score mate %d nodes %d time %d pv",
+		2*result.Moves-1, result.Moves, result.Nodes, time.Since(start)/time.Millisecond)
+	for _, m := range result.Line {
+		buff += fmt.Sprintf(" %v", m.UCI())
+	}
+	Printu(buff + "\n")
+	Printu(fmt.Sprintf("info string mate in %d proven\n", result.Moves))
+	LastScore = MateScore - int32(2*result.Moves-1)
+	return result.Line
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // play : starts the engine
 // should run in its own separate goroutine
@@ -2677,7 +3524,7 @@ func (uci *UCI) play() {
 
 	if UseBook && ( Protocol == PROTOCOL_XBOARD ) && ( XBOARD_State != XBOARD_Analyzing ) {
 		pos := uci.Engine.Position
-		algeb, found := pos.GetSimpleBookMove()
+		algeb, found := pos.GetBookMove()
 		if found {
 			move , err := pos.UCIToMove(algeb)
 			if err == nil {
@@ -2695,14 +3542,27 @@ func (uci *UCI) play() {
 		}
 	}
 
-	moves := uci.Engine.Play(uci.timeControl, IgnoreMoves)
+	var moves []Move
+	if uci.mate > 0 {
+		moves = uci.playMate()
+	} else {
+		moves = uci.Engine.Play(uci.timeControl, IgnoreMoves)
+	}
 
-	if MultiPV > 1 {
+	if ( MultiPV > 1 ) && ( uci.mate == 0 ) {
 		if MultiPVList.HasScore() {
 			moves = MultiPVList[0].Line
 		}
 	}
 
+	// with limited strength pick one of the candidate lines
+	skilllimited := IsSkillLimited() && ( XBOARD_State != XBOARD_Analyzing ) && ( uci.mate == 0 )
+	if skilllimited {
+		if MultiPVList.HasScore() {
+			moves = MultiPVList.PickSkillMove()
+		}
+	}
+
 	if len(moves) >= 2 {
 		uci.Engine.Position.DoMove(moves[0])
 		uci.Engine.Position.DoMove(moves[1])
@@ -2732,27 +3592,24 @@ func (uci *UCI) play() {
 
 		if len(moves) > 0 {
 			algeb := moves[0].UCI()
-			if StoreScores {
+			if StoreScores && !skilllimited {
 				depth := int(uci.Engine.Stats.Depth)
 				if depth >= StoreMinDepth {
 					mentry , found := uci.Engine.Position.GetMoveEntry(algeb)
-					ok := true
-					if found {
-						if depth < mentry.Depth {
-							// if depth is lower than that of stored move
-							// the score is only stored if book version is higher
-							ok = BookVersion > mentry.BookVersion
-						}
+					umentry := BookMoveEntry{
+						Algeb : algeb,
+						Score : int(LastScore),
+						Depth : depth,
+						BookVersion : BookVersion,
+						HasEval : false,
+						Eval : 0,
+						Wins : mentry.Wins,
+						Draws : mentry.Draws,
+						Losses : mentry.Losses,
 					}
-					if ok {
-						umentry := BookMoveEntry{
-							Algeb : algeb,
-							Score : int(LastScore),
-							Depth : depth,
-							BookVersion : BookVersion,
-							HasEval : false,
-							Eval : 0,
-						}
+					// if depth is lower than that of stored move
+					// the score is only stored if book version is higher
+					if !found || umentry.Supersedes(mentry) {
 						uci.Engine.Position.StoreMoveEntry(algeb, umentry)
 					}
 				}
@@ -2837,7 +3694,7 @@ func (uci *UCI) setoption(line string) error {
 			if err != nil {
 				return fmt.Errorf("wrong piece value")
 			}
-			RK_PIECE_VALUES[FigureNameToFigure(setPieceValue[1])]=int32(pieceValue)
+			setEvalParam(&RK_PIECE_VALUES[FigureNameToFigure(setPieceValue[1])], int32(pieceValue))
 			return nil
 		}
 		switch option[1] {
@@ -2846,7 +3703,28 @@ func (uci *UCI) setoption(line string) error {
 			if err != nil {
 				return fmt.Errorf("wrong king advance value")
 			}
-			KING_ADVANCE_VALUE = int32(kingAdvanceValue)
+			setEvalParam(&KING_ADVANCE_VALUE, int32(kingAdvanceValue))
+			return nil
+		case "Race Distance Value" :
+			raceDistanceValue , err := strconv.ParseInt(option[3], 10, 32)
+			if err != nil {
+				return fmt.Errorf("wrong race distance value")
+			}
+			setEvalParam(&RK_RACE_DISTANCE_VALUE, int32(raceDistanceValue))
+			return nil
+		case "Race Tempo Value" :
+			raceTempoValue , err := strconv.ParseInt(option[3], 10, 32)
+			if err != nil {
+				return fmt.Errorf("wrong race tempo value")
+			}
+			setEvalParam(&RK_RACE_TEMPO_VALUE, int32(raceTempoValue))
+			return nil
+		case "Forward Control Value" :
+			forwardControlValue , err := strconv.ParseInt(option[3], 10, 32)
+			if err != nil {
+				return fmt.Errorf("wrong forward control value")
+			}
+			setEvalParam(&RK_FORWARD_CONTROL_VALUE, int32(forwardControlValue))
 			return nil
 		}
 	}
@@ -2875,7 +3753,155 @@ func (uci *UCI) setoption(line string) error {
 			GlobalHashTable = NewHashTable(int(hashSizeMB))
 		}
 		return nil
+	case "Skill Level":
+		if level, err := strconv.Atoi(option[3]); err != nil {
+			return err
+		} else {
+			SkillLevel = level
+		}
+		return nil
+	case "UCI_LimitStrength":
+		if limit, err := strconv.ParseBool(option[3]); err != nil {
+			return err
+		} else {
+			UCI_LimitStrength = limit
+		}
+		return nil
+	case "Contempt":
+		if contempt, err := strconv.ParseInt(option[3], 10, 32); err != nil {
+			return err
+		} else {
+			Contempt = int32(contempt)
+			ContemptIsSet = true
+		}
+		return nil
+	case "EvalFile":
+		path := strings.TrimSpace(option[3])
+		if path == "<empty>" {
+			path = ""
+		}
+		return LoadEvalFile(path)
+	case "UseNNUE":
+		use, err := strconv.ParseBool(option[3])
+		if err != nil {
+			return err
+		}
+		if use && ( ( nnueNet == nil ) || ( nnueNet.Variant != Variant ) ) {
+			if err := LoadNNUE(NNUEFile); err != nil {
+				return err
+			}
+		}
+		UseNNUE = use
+		return nil
+	case "NNUEFile":
+		path := strings.TrimSpace(option[3])
+		if ( path == "<empty>" ) || ( path == NNUE_DEFAULT_FILES[Variant] ) {
+			path = ""
+		}
+		if UseNNUE {
+			if err := LoadNNUE(path); err != nil {
+				return err
+			}
+		} else {
+			// loaded when the network is switched on
+			nnueNet = nil
+		}
+		NNUEFile = path
+		return nil
+	case "TablebasePath":
+		path := strings.TrimSpace(option[3])
+		if path == "<empty>" {
+			path = ""
+		}
+		_, err := LoadTablebases(path)
+		return err
+	case "PolyglotFile":
+		path := strings.TrimSpace(option[3])
+		if path == "<empty>" {
+			path = ""
+		}
+		_, err := LoadPolyglot(path)
+		return err
+	case "BookFile":
+		path := strings.TrimSpace(option[3])
+		if ( path == "<empty>" ) || ( path == BOOK_DEFAULT_FILES[Variant] ) {
+			path = ""
+		}
+		BookFile = path
+		return nil
+	case "SimpleBookFile":
+		path := strings.TrimSpace(option[3])
+		if path == "<empty>" {
+			path = ""
+		}
+		if err := LoadSimpleBookFile(path); err != nil {
+			return err
+		}
+		SimpleBookFile = path
+		return nil
+	case "BookConfidence":
+		confidence, err := strconv.ParseBool(option[3])
+		if err != nil {
+			return err
+		}
+		BookConfidence = confidence
+		return nil
+	case "BookDrawValue":
+		value, err := strconv.Atoi(option[3])
+		if err != nil {
+			return err
+		}
+		if value < -100 {
+			value = -100
+		} else if value > 100 {
+			value = 100
+		}
+		BookDrawValue = value
+		return nil
+	case "BookGameWeight":
+		weight, err := strconv.Atoi(option[3])
+		if err != nil {
+			return err
+		}
+		if weight < 0 {
+			weight = 0
+		} else if weight > 100 {
+			weight = 100
+		}
+		BookGameWeight = weight
+		return nil
+	case "SyzygyPath":
+		path := strings.TrimSpace(option[3])
+		if path == "<empty>" {
+			path = ""
+		}
+		_, err := LoadSyzygy(path)
+		return err
+	case "Dynamic Contempt":
+		if dynamic, err := strconv.ParseBool(option[3]); err != nil {
+			return err
+		} else {
+			DynamicContempt = dynamic
+		}
+		return nil
+	case "UCI_Elo":
+		if elo, err := strconv.Atoi(option[3]); err != nil {
+			return err
+		} else {
+			UCI_Elo = elo
+		}
+		return nil
 	default:
+		for _, knob := range SEARCH_KNOBS {
+			if knob.Name == option[1] {
+				if value, err := strconv.ParseInt(option[3], 10, 32); err != nil {
+					return err
+				} else {
+					*knob.Value = min(max(int32(value), knob.Min), knob.Max)
+				}
+				return nil
+			}
+		}
 		return fmt.Errorf("unhandled option %s", option[1])
 	}
 }
@@ -2893,7 +3919,7 @@ func (uci *UCI) SetVariant(setVariant int) error {
 		case PROTOCOL_XBOARD: log.SetPrefix("Error ")
 	}
 	uci.Engine.SetVariant(setVariant)
-	return nil
+	return SwitchNNUEVariant()
 }
 
 ///////////////////////////////////////////////
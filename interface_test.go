@@ -0,0 +1,198 @@
+//////////////////////////////////////////////////////
+// interface_test.go
+// tests the reduced strength play: the candidate pick of PickSkillMove,
+// the limits of ApplySkillLimits and the line returned by a multipv search
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"math/rand"
+	"testing"
+)
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// skillTestSetup : sets up a skill level and a calibration for the test
+// the previous settings are restored when the test finishes
+// -> t *testing.T : test
+// -> level int : skill level
+// -> blunder int : blunder percent of the calibration
+
+func skillTestSetup(t *testing.T, level int, blunder int) {
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	oldlevel, oldlimit, oldelo, oldcal, oldrand := SkillLevel, UCI_LimitStrength, UCI_Elo, SKILL_CALIBRATIONS[Variant], Rand
+	t.Cleanup(func() {
+		SkillLevel, UCI_LimitStrength, UCI_Elo, SKILL_CALIBRATIONS[Variant], Rand = oldlevel, oldlimit, oldelo, oldcal, oldrand
+	})
+	SkillLevel = level
+	UCI_LimitStrength = false
+	SKILL_CALIBRATIONS[Variant].BlunderPercent = blunder
+	Rand = rand.New(rand.NewSource(1))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// skillTestCandidates : multipv candidates with a single move line each
+// -> scores ...int32 : scores of the candidates
+// <- MultiPVItemList : candidates, the line of candidate i is Move(i+1)
+
+func skillTestCandidates(scores ...int32) MultiPVItemList {
+	mpvl := MultiPVItemList{}
+	for i, score := range scores {
+		mpvl = append(mpvl, MultiPVItem{Score: score, Line: []Move{Move(i + 1)}})
+	}
+	return mpvl
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// skillTestPicks : counts the candidates picked in a number of tries
+// -> mpvl MultiPVItemList : candidates
+// -> tries int : number of picks
+// <- map[Move]int : number of times the first move of a line was picked
+
+func skillTestPicks(mpvl MultiPVItemList, tries int) map[Move]int {
+	picks := map[Move]int{}
+	for i := 0; i < tries; i++ {
+		line := mpvl.PickSkillMove()
+		if len(line) > 0 {
+			picks[line[0]]++
+		}
+	}
+	return picks
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPickSkillMoveMargin : without blunders only candidates within the margin are picked
+
+func TestPickSkillMoveMargin(t *testing.T) {
+	// level 10 misses 10 levels, the Standard margin is 15 centipawns a level
+	skillTestSetup(t, 10, 0)
+	margin := SKILL_CALIBRATIONS[Variant].Margin * int32(MAX_SKILL_LEVEL - 10)
+
+	picks := skillTestPicks(skillTestCandidates(100, 100 - margin / 2, 100 - margin - 1, -500), 1000)
+	if picks[1] == 0 || picks[2] == 0 {
+		t.Errorf("candidates within the margin not picked: %v", picks)
+	}
+	if picks[1] <= picks[2] {
+		t.Errorf("best candidate picked less often than a worse one: %v", picks)
+	}
+	if picks[3] != 0 || picks[4] != 0 {
+		t.Errorf("candidates outside the margin picked: %v", picks)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPickSkillMoveBlunder : a blunder can pick any candidate regardless of the margin
+
+func TestPickSkillMoveBlunder(t *testing.T) {
+	// at level 0 a blunder percent of 100 makes every pick a blunder
+	skillTestSetup(t, 0, 100)
+
+	picks := skillTestPicks(skillTestCandidates(100, 90, -500, -900), 1000)
+	for m := Move(1); m <= 4; m++ {
+		if picks[m] == 0 {
+			t.Errorf("candidate %d never picked by a blunder: %v", m, picks)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPickSkillMoveSingle : a single candidate is always picked, an empty list picks nothing
+
+func TestPickSkillMoveSingle(t *testing.T) {
+	skillTestSetup(t, 0, 100)
+
+	// candidates without a line do not count
+	mpvl := skillTestCandidates(100, 50)
+	mpvl[1].Line = []Move{}
+	picks := skillTestPicks(mpvl, 100)
+	if picks[1] != 100 {
+		t.Errorf("single candidate not always picked: %v", picks)
+	}
+
+	empty := MultiPVItemList{}
+	if line := empty.PickSkillMove(); len(line) != 0 {
+		t.Errorf("empty list picked %v", line)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestApplySkillLimits : depth and nodes are capped by the skill level only
+
+func TestApplySkillLimits(t *testing.T) {
+	skillTestSetup(t, MAX_SKILL_LEVEL, 0)
+	pos, _ := PositionFromFEN(START_FENS[VARIANT_Standard])
+
+	// full strength leaves the time control alone
+	tc := NewFixedDepthTimeControl(pos, 20)
+	ApplySkillLimits(tc)
+	if tc.Depth != 20 || tc.Nodes != 0 {
+		t.Errorf("full strength: got depth %d nodes %d, want depth 20 nodes 0", tc.Depth, tc.Nodes)
+	}
+
+	// level 4 in Standard searches to depth 1 + 4/2 with 2000 << 4/2 nodes
+	SkillLevel = 4
+	tc = NewFixedDepthTimeControl(pos, 20)
+	ApplySkillLimits(tc)
+	if tc.Depth != 3 || tc.Nodes != 8000 {
+		t.Errorf("level 4: got depth %d nodes %d, want depth 3 nodes 8000", tc.Depth, tc.Nodes)
+	}
+
+	// tighter limits of the time control are kept
+	tc = NewFixedDepthTimeControl(pos, 2)
+	tc.Nodes = 500
+	ApplySkillLimits(tc)
+	if tc.Depth != 2 || tc.Nodes != 500 {
+		t.Errorf("level 4 with tighter limits: got depth %d nodes %d, want depth 2 nodes 500", tc.Depth, tc.Nodes)
+	}
+
+	// UCI_Elo at the bottom of the calibration is level 0
+	UCI_LimitStrength = true
+	UCI_Elo = SKILL_CALIBRATIONS[Variant].MinElo
+	if level := GetSkillLevel(); level != 0 {
+		t.Errorf("minimum elo: got level %d, want 0", level)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPlaySkillReturnsBestLine : with limited strength Play searches several lines
+// but returns the best one, not the last one searched
+
+func TestPlaySkillReturnsBestLine(t *testing.T) {
+	skillTestSetup(t, 3, 0)
+
+	pos, err := PositionFromFEN("6k1/5ppp/8/8/8/8/5PPP/R5K1 w - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := NewEngine(pos, nil, Options{})
+	tc := NewFixedDepthTimeControl(pos, 4)
+	tc.Start(false)
+	moves := eng.Play(tc, []Move{})
+	if SearchMultiPV() <= 1 || len(MultiPVList) <= 1 {
+		t.Fatalf("limited strength searched %d lines", len(MultiPVList))
+	}
+	if len(moves) == 0 || moves[0].UCI() != "a1a8" {
+		t.Errorf("got line %v, want the mate a1a8", moves)
+	}
+}
+
+///////////////////////////////////////////////
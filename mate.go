@@ -0,0 +1,359 @@
+//////////////////////////////////////////////////////
+// mate.go
+// implements the mate finder used by go mate
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"sort"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// mate finder node check interval
+const mateCheckpointStep = 4096
+
+// upper bound on mate length, marks positions that can never be won
+const maxMateMoves = 1 << 16
+
+// number of proof table entries, a power of two
+const mateTableSize = 1 << 18
+
+// MateResult : result of a mate search
+type MateResult struct {
+	Moves  int    // number of moves to mate, 0 if no mate was found
+	Line   []Move // mating line, longest resistance of the defender
+	Proven bool   // true if the mate was proven within the search
+	Nodes  uint64 // number of nodes searched
+}
+
+// mateEntry : proof state of a position with the attacker to move
+type mateEntry struct {
+	key    uint64 // Zobrist key of the position
+	mate   int    // shortest known mate in moves, 0 if unknown
+	nomate int    // longest number of moves known not to mate
+}
+
+// mateFinder : state of a mate search
+type mateFinder struct {
+	pos        *Position
+	tc         *TimeControl
+	table      []mateEntry
+	nodes      uint64
+	checkpoint uint64
+	stopped    bool
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// FindMate : searches for the shortest forced mate of the side to move
+// the search is an exhaustive AND/OR search over the legal moves, deepened by one move at a time
+// a loss is whatever leaves the defender without a legal move while checked
+// this covers exploded kings in Atomic, a won race in Racing Kings and captured pawns in Horde
+// repetitions and the fifty-move rule are ignored, a shortest mate never needs them
+// -> eng *Engine : engine
+// -> maxMoves int : maximum mate length in moves
+// -> tc *TimeControl : time control
+// <- MateResult : mate result
+
+func (eng *Engine) FindMate(maxMoves int, tc *TimeControl) MateResult {
+	mf := &mateFinder{
+		pos:        eng.Position,
+		tc:         tc,
+		table:      make([]mateEntry, mateTableSize),
+		checkpoint: mateCheckpointStep,
+	}
+
+	result := MateResult{}
+	for n := 1; n <= maxMoves; n++ {
+		if !tc.NextDepth(int32(2*n - 1)) {
+			break
+		}
+		proven := mf.attack(n)
+		if mf.stopped {
+			break
+		}
+		if proven {
+			result.Moves = n
+			result.Proven = true
+			result.Line = mf.line(n)
+			break
+		}
+	}
+
+	result.Nodes = mf.nodes
+	eng.Stats.Nodes += mf.nodes
+	return result
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// probe : returns the table entry of a position
+// -> mf *mateFinder : mate finder
+// -> key uint64 : Zobrist key of the position
+// <- mateEntry : entry, empty if not present
+
+func (mf *mateFinder) probe(key uint64) mateEntry {
+	entry := mf.table[key&( mateTableSize - 1 )]
+	if entry.key != key {
+		return mateEntry{key: key}
+	}
+	return entry
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// store : stores the proof state of a position, replacing the entry of any other position in its slot
+// the table is bounded, a replaced entry is only a lost shortcut as the search proves it again
+// -> mf *mateFinder : mate finder
+// -> entry mateEntry : entry
+
+func (mf *mateFinder) store(entry mateEntry) {
+	mf.table[entry.key&( mateTableSize - 1 )] = entry
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// visit : counts a node and checks the time control
+// -> mf *mateFinder : mate finder
+// <- bool : true if the search should stop
+
+func (mf *mateFinder) visit() bool {
+	mf.nodes++
+	if !mf.stopped && mf.nodes >= mf.checkpoint {
+		mf.checkpoint = mf.nodes + mateCheckpointStep
+		if mf.tc.Stopped() || mf.tc.NodesExhausted(mf.nodes) {
+			mf.stopped = true
+		}
+	}
+	return mf.stopped
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// eliminated : returns true if the side to move has lost its king or all its pawns
+// these losses have to be detected before generating moves
+// -> mf *mateFinder : mate finder
+// <- bool : true if lost
+
+func (mf *mateFinder) eliminated() bool {
+	us := mf.pos.SideToMove
+	if IS_Atomic && mf.pos.IsExploded(us) {
+		return true
+	}
+	if IS_Horde && ( us == HORDE_Pawns_Side ) && mf.pos.AllPawnsCaptured() {
+		return true
+	}
+	return false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// attackerMoves : legal moves of the attacker ordered for the mate search
+// checks (including explosions and won races) come first, then captures
+// on the last move only checks are returned as nothing else can mate
+// -> mf *mateFinder : mate finder
+// -> lastmove bool : true if this is the last move of the attacker
+// <- []Move : ordered moves
+
+func (mf *mateFinder) attackerMoves(lastmove bool) []Move {
+	moves := mf.pos.GetLegalMoves(GET_ALL)
+	them := mf.pos.SideToMove.Opposite()
+	keys := make([]int, len(moves))
+	ordered := moves[:0]
+	for _, m := range moves {
+		mf.pos.DoMove(m)
+		checks := mf.pos.IsChecked(them)
+		mf.pos.UndoMove()
+		key := 2
+		if checks {
+			key = 0
+		} else if lastmove {
+			continue
+		} else if m.Capture() != NoPiece {
+			key = 1
+		}
+		keys[len(ordered)] = key
+		ordered = append(ordered, m)
+	}
+	keys = keys[:len(ordered)]
+	sort.Stable(&mateMoveSorter{moves: ordered, keys: keys})
+	return ordered
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// attack : returns true if the side to move (attacker) mates in at most n moves
+// -> mf *mateFinder : mate finder
+// -> n int : number of moves left
+// <- bool : true if mate proven
+
+func (mf *mateFinder) attack(n int) bool {
+	if mf.visit() {
+		return false
+	}
+	entry := mf.probe(mf.pos.Zobrist())
+	if ( entry.mate != 0 ) && ( entry.mate <= n ) {
+		return true
+	}
+	if entry.nomate >= n {
+		return false
+	}
+
+	// the attacker cannot win after losing its king or in a drawn position
+	if mf.eliminated() || mf.pos.InsufficientMaterial() {
+		entry.nomate = maxMateMoves
+		mf.store(entry)
+		return false
+	}
+
+	for _, m := range mf.attackerMoves(n == 1) {
+		mf.pos.DoMove(m)
+		proven := mf.defend(n)
+		mf.pos.UndoMove()
+		if mf.stopped {
+			return false
+		}
+		if proven {
+			entry.mate = n
+			mf.store(entry)
+			return true
+		}
+	}
+
+	entry.nomate = n
+	mf.store(entry)
+	return false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// defend : returns true if every defence loses within n moves of the attacker
+// the attacker has already played the n-th last move
+// -> mf *mateFinder : mate finder
+// -> n int : number of attacker moves left including the one just played
+// <- bool : true if mate proven
+
+func (mf *mateFinder) defend(n int) bool {
+	if mf.visit() {
+		return false
+	}
+	if mf.eliminated() {
+		return true
+	}
+	moves := mf.pos.GetLegalMoves(GET_ALL)
+	if len(moves) == 0 {
+		// mate or stalemate
+		return mf.pos.IsChecked(mf.pos.SideToMove)
+	}
+	if ( n <= 1 ) || mf.pos.InsufficientMaterial() {
+		// draw or out of moves
+		return false
+	}
+	for _, m := range moves {
+		mf.pos.DoMove(m)
+		proven := mf.attack(n - 1)
+		mf.pos.UndoMove()
+		if !proven {
+			return false
+		}
+	}
+	return true
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// line : extracts the mating line of a proven mate in n
+// the attacker plays the fastest mate, the defender the longest resistance
+// -> mf *mateFinder : mate finder
+// -> n int : mate length in moves
+// <- []Move : mating line
+
+func (mf *mateFinder) line(n int) []Move {
+	line := []Move{}
+	for n > 0 {
+		attack := NullMove
+		for _, m := range mf.attackerMoves(n == 1) {
+			mf.pos.DoMove(m)
+			proven := mf.defend(n)
+			mf.pos.UndoMove()
+			if proven {
+				attack = m
+				break
+			}
+		}
+		if attack == NullMove {
+			break
+		}
+		mf.pos.DoMove(attack)
+		line = append(line, attack)
+
+		if mf.eliminated() {
+			break
+		}
+		moves := mf.pos.GetLegalMoves(GET_ALL)
+
+		// the defender picks the reply which delays the mate the longest
+		defence, length := NullMove, 0
+		for _, m := range moves {
+			mf.pos.DoMove(m)
+			for k := 1; k < n; k++ {
+				if mf.attack(k) {
+					if k > length {
+						defence, length = m, k
+					}
+					break
+				}
+			}
+			mf.pos.UndoMove()
+		}
+		if defence == NullMove {
+			break
+		}
+		mf.pos.DoMove(defence)
+		line = append(line, defence)
+		n = length
+	}
+
+	// take back the line
+	for range line {
+		mf.pos.UndoMove()
+	}
+	return line
+}
+
+///////////////////////////////////////////////
+// mateMoveSorter : sorts moves by ascending key
+
+type mateMoveSorter struct {
+	moves []Move
+	keys  []int
+}
+
+func (ms *mateMoveSorter) Len() int {
+	return len(ms.moves)
+}
+
+func (ms *mateMoveSorter) Less(i, j int) bool {
+	return ms.keys[i] < ms.keys[j]
+}
+
+func (ms *mateMoveSorter) Swap(i, j int) {
+	ms.moves[i], ms.moves[j] = ms.moves[j], ms.moves[i]
+	ms.keys[i], ms.keys[j] = ms.keys[j], ms.keys[i]
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,106 @@
+//////////////////////////////////////////////////////
+// mate_test.go
+// tests the mate finder on positions of known mate length in all variants,
+// including explosions in Atomic and won races in Racing Kings
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// mateTestCase : a position and the shortest mate of the side to move
+type mateTestCase struct {
+	name     string // description
+	variant  int    // variant
+	fen      string // position
+	maxMoves int    // maximum mate length searched
+	moves    int    // shortest mate in moves, 0 if none can be proven within maxMoves
+	first    string // first move of the mating line in UCI notation
+}
+
+var mateTestCases = []mateTestCase{
+	{"back rank mate in 1", VARIANT_Standard, "6k1/5ppp/8/8/8/8/5PPP/R5K1 w - - 0 1", 3, 1, "a1a8"},
+	{"rook mate in 2", VARIANT_Standard, "k7/8/2K5/8/8/8/8/7R w - - 0 1", 3, 2, "c6b6"},
+	{"mate in 2 beyond the limit", VARIANT_Standard, "k7/8/2K5/8/8/8/8/7R w - - 0 1", 1, 0, ""},
+	{"bare kings", VARIANT_Standard, "k7/8/2K5/8/8/8/8/8 w - - 0 1", 3, 0, ""},
+	{"explosion next to the king", VARIANT_Atomic, "k7/p7/8/8/8/8/8/K5Q1 w - - 0 1", 2, 1, "g1a7"},
+	{"king reaches the goal", VARIANT_Racing_Kings, "8/6K1/8/8/8/8/k7/8 w - - 0 1", 2, 1, ""},
+	{"king reaches the goal in 2", VARIANT_Racing_Kings, "8/8/6K1/8/8/8/8/k7 w - - 0 1", 3, 2, ""},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestFindMate : the mate finder proves the shortest mate and returns a legal mating line
+
+func TestFindMate(t *testing.T) {
+	for _, tc := range mateTestCases {
+		uci = NewUCI()
+		uci.SetVariant(tc.variant)
+		pos, err := PositionFromFEN(tc.fen)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		eng := NewEngine(pos, nil, Options{})
+		timecontrol := NewFixedDepthTimeControl(pos, 64)
+		timecontrol.Start(false)
+		result := eng.FindMate(tc.maxMoves, timecontrol)
+
+		if result.Moves != tc.moves || result.Proven != ( tc.moves > 0 ) {
+			t.Errorf("%s: got mate in %d proven %v, want mate in %d", tc.name, result.Moves, result.Proven, tc.moves)
+			continue
+		}
+		if tc.moves == 0 {
+			if len(result.Line) != 0 {
+				t.Errorf("%s: got line %v without a mate", tc.name, result.Line)
+			}
+			continue
+		}
+		if len(result.Line) != 2*tc.moves - 1 {
+			t.Errorf("%s: got line %v, want %d plies", tc.name, result.Line, 2*tc.moves - 1)
+			continue
+		}
+		if ( tc.first != "" ) && ( result.Line[0].UCI() != tc.first ) {
+			t.Errorf("%s: got first move %v, want %s", tc.name, result.Line[0].UCI(), tc.first)
+		}
+
+		// the line is legal and leaves the defender without a move or without its king
+		for _, m := range result.Line {
+			if !isLegalMoveOf(pos, m) {
+				t.Errorf("%s: illegal move %v in line %v", tc.name, m.UCI(), result.Line)
+				break
+			}
+			pos.DoMove(m)
+		}
+		lost := ( &mateFinder{pos: pos} ).eliminated()
+		if legal := pos.GetLegalMoves(GET_ALL); !lost && ( len(legal) != 0 ) {
+			t.Errorf("%s: defender has %d moves after the line %v", tc.name, len(legal), result.Line)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// isLegalMoveOf : checks if a move is among the legal moves of a position
+// -> pos *Position : position
+// -> m Move : move
+// <- bool : true if legal
+
+func isLegalMoveOf(pos *Position, m Move) bool {
+	for _, legal := range pos.GetLegalMoves(GET_ALL) {
+		if legal == m {
+			return true
+		}
+	}
+	return false
+}
+
+///////////////////////////////////////////////
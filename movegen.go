@@ -51,8 +51,14 @@ var (
 
 	// pov xor mask indexed by color
 	povMask = [ColorArraySize]Square{0x00, 0x38, 0x00}
+
+	// bits of the piece counts in a material signature indexed by figure
+	materialSignatureBits = [FigureArraySize]uint{0, 6, 4, 4, 4, 4, 1}
 )
 
+// bits of the piece counts of one side in a material signature
+const materialSignatureSideBits = 23
+
 // Color represents a side
 type Color uint
 
@@ -308,6 +314,7 @@ type state struct {
 	CastlingAbility Castle    // remaining castling rights
 	ExplosionInfo   [8]explosion  // slice of exploded pieces ( max 8 )
 	NumExplosions   int       // number of explosions
+	nnue            nnueState // piece changes for the network evaluation
 }
 
 // Position represents the chess board and keeps track of the move history
@@ -320,6 +327,9 @@ type Position struct {
 	fullmoveCounter int     // fullmove counter, incremented after black move
 	states          []state // a state for each Ply
 	curr            *state  // current state
+
+	accumulators   []int16  // network accumulators, two for each state
+	accumulatorNet *NNUENet // network the accumulators were computed for
 }
 
 type castleInfo struct {
@@ -2127,6 +2137,7 @@ func NewPosition() *Position {
 func (pos *Position) Put(sq Square, pi Piece) {
 	if pi != NoPiece {
 		pos.curr.Zobrist ^= zobristPiece[pi][sq]
+		pos.curr.nnue.record(sq, pi, true)
 		bb := sq.Bitboard()
 		pos.ByColor[pi.Color()] |= bb
 		pos.ByFigure[pi.Figure()] |= bb
@@ -2779,6 +2790,60 @@ func (pos *Position) HasNonPawns(col Color) bool {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// MaterialSignature : returns the numbers of pieces by side and figure packed in an exact key
+// per side 6 bits of pawns ( horde has 36 ), 4 bits of each of knights, bishops, rooks, queens and 1 bit of king
+// -> pos *Position : position
+// <- uint64 : signature
+
+func (pos *Position) MaterialSignature() uint64 {
+	sig := uint64(0)
+	for _, col := range []Color{White, Black} {
+		for fig := FigureMinValue; fig <= FigureMaxValue; fig++ {
+			count := uint64(pos.ByPiece(col, fig).Count())
+			if max := uint64(1)<<materialSignatureBits[fig] - 1; count > max {
+				count = max
+			}
+			sig |= count << materialSignatureShift(col, fig)
+		}
+	}
+	return sig
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// MaterialCount : returns the number of pieces of a side and figure in a material signature
+// -> sig uint64 : signature
+// -> col Color : side
+// -> fig Figure : figure
+// <- int : number of pieces
+
+func MaterialCount(sig uint64, col Color, fig Figure) int {
+	return int(sig >> materialSignatureShift(col, fig) & ( uint64(1)<<materialSignatureBits[fig] - 1 ))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// materialSignatureShift : returns the position of a count in a material signature
+// -> col Color : side
+// -> fig Figure : figure
+// <- uint : shift
+
+func materialSignatureShift(col Color, fig Figure) uint {
+	shift := uint(0)
+	if col == Black {
+		shift = materialSignatureSideBits
+	}
+	for f := FigureMinValue; f < fig; f++ {
+		shift += materialSignatureBits[f]
+	}
+	return shift
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // GetAttacker : returns the smallest figure of color them that attacks sq
 // -> pos *Position : position
@@ -2842,6 +2907,36 @@ func (pos *Position) PawnThreats(side Color) Bitboard {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// AttackedSquares : returns the set of squares attacked by side
+// sliders see through the opposite king so that it cannot step back along their line
+// -> pos *Position : position
+// -> side Color : side
+// <- Bitboard : squares attacked by side
+
+func (pos *Position) AttackedSquares(side Color) Bitboard {
+	all := ( pos.ByColor[White] | pos.ByColor[Black] ) &^ pos.ByPiece(side.Opposite(), King)
+	attacked := pos.PawnThreats(side)
+	for bb := pos.ByPiece(side, Knight); bb != 0; {
+		attacked |= KnightMobility(bb.Pop())
+	}
+	for bb := pos.ByPiece(side, Bishop); bb != 0; {
+		attacked |= BishopMobility(bb.Pop(), all)
+	}
+	for bb := pos.ByPiece(side, Rook); bb != 0; {
+		attacked |= RookMobility(bb.Pop(), all)
+	}
+	for bb := pos.ByPiece(side, Queen); bb != 0; {
+		attacked |= QueenMobility(bb.Pop(), all)
+	}
+	for bb := pos.ByPiece(side, King); bb != 0; {
+		attacked |= KingMobility(bb.Pop())
+	}
+	return attacked
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // IsPseudoLegal : returns true if m is a pseudo legal move for pos
 // it returns true iff m can be executed even if own king is in check
@@ -3514,6 +3609,7 @@ func (pos *Position) getMask(kind int) Bitboard {
 func (pos *Position) Remove(sq Square, pi Piece) {
 	if pi != NoPiece {
 		pos.curr.Zobrist ^= zobristPiece[pi][sq]
+		pos.curr.nnue.record(sq, pi, false)
 		bb := ^sq.Bitboard()
 		pos.ByColor[pi.Color()] &= bb
 		pos.ByFigure[pi.Figure()] &= bb
@@ -3662,6 +3758,7 @@ func (pos *Position) DoMove(move Move) {
 	pos.pushState()
 	curr := pos.curr
 	curr.Move = move
+	curr.nnue.reset()
 
 	// update castling rights
 	pi := move.Piece()
@@ -0,0 +1,522 @@
+//////////////////////////////////////////////////////
+// nnue.go
+// implements an efficiently updatable neural network evaluation
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// the network has HalfKP-like inputs, one set for each perspective
+// a feature is a (king square, piece, square) triple seen from the perspective's side
+// the pieces are own pawn..queen and opponent pawn..king, own king is the bucket
+// a side without king ( horde pawns, exploded atomic king ) uses the extra bucket
+const(
+	NNUE_KING_BUCKETS = 65
+	NNUE_PIECE_TYPES  = 11
+	NNUE_INPUTS       = NNUE_KING_BUCKETS * NNUE_PIECE_TYPES * 64
+	NNUE_MAX_HIDDEN   = 2048
+)
+
+// quantization of the network
+// the accumulators are clipped to [0, NNUE_QA], the output weights are scaled by NNUE_QB
+// and the output is multiplied by NNUE_OUTPUT_SCALE to get centipawns
+const(
+	NNUE_QA           = 255
+	NNUE_QB           = 64
+	NNUE_OUTPUT_SCALE = 400
+)
+
+// net file header
+const(
+	NNUE_MAGIC        = "VNUE"
+	NNUE_FILE_VERSION = 1
+)
+
+// number of plies the accumulators are updated over before being refreshed
+const NNUE_MAX_UPDATE_PLIES = 32
+
+// number of hidden neurons summed in int32, NNUE_QA * 32767 * 64 < 2^31
+const nnueOutputChunk = 64
+
+// maximum number of piece changes recorded in a state
+// a castling atomic capture changes at most 14 pieces
+const nnueMaxChanges = 16
+
+// NNUENet : a quantized network with one hidden layer
+// all weights are little endian in the file, after the header
+type NNUENet struct {
+	Variant       int     // variant the net was trained for
+	Hidden        int     // size of the hidden layer of one perspective
+	FeatureWeight []int16 // NNUE_INPUTS x Hidden, feature major
+	FeatureBias   []int16 // Hidden
+	OutputWeight  []int16 // 2 x Hidden, side to move first
+	OutputBias    int32   // output bias
+}
+
+// nnueChange : a piece put on or removed from a square
+type nnueChange struct {
+	sq    Square
+	piece Piece
+	add   bool
+}
+
+// nnueState : piece changes of a state and whether its accumulators are computed
+type nnueState struct {
+	changes  [nnueMaxChanges]nnueChange
+	num      int  // number of changes
+	overflow bool // too many changes, the accumulators have to be refreshed
+	computed bool // accumulators are up to date
+}
+
+// UseNNUE : evaluate with the network instead of the classical evaluation
+var UseNNUE = false
+
+// default net file by variant
+var NNUE_DEFAULT_FILES = [...]string{
+	"standard.nnue",
+	"racingkings.nnue",
+	"atomic.nnue",
+	"horde.nnue",
+}
+
+// NNUEFile : net file of the current variant, empty for the default
+var NNUEFile = ""
+
+// network of the current variant, nil if not loaded
+var nnueNet *NNUENet
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// NewNNUENet : creates a zero network
+// -> variant int : variant
+// -> hidden int : size of the hidden layer
+// <- *NNUENet : network
+
+func NewNNUENet(variant int, hidden int) *NNUENet {
+	return &NNUENet{
+		Variant:       variant,
+		Hidden:        hidden,
+		FeatureWeight: make([]int16, NNUE_INPUTS*hidden),
+		FeatureBias:   make([]int16, hidden),
+		OutputWeight:  make([]int16, 2*hidden),
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadNNUENet : reads a network
+// the format is the magic VNUE, then version, variant and hidden size as uint32
+// followed by the feature weights, feature biases, output weights as int16 and the output bias as int32
+// -> r io.Reader : reader
+// <- *NNUENet : network
+// <- error : error
+
+func ReadNNUENet(r io.Reader) (*NNUENet, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(NNUE_MAGIC))
+	if _, err := io.ReadFull(br, magic); err != nil || ( string(magic) != NNUE_MAGIC ) {
+		return nil, fmt.Errorf("not a net file")
+	}
+	var header [3]uint32
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("truncated net header")
+	}
+	version, variant, hidden := header[0], int(header[1]), int(header[2])
+	if version != NNUE_FILE_VERSION {
+		return nil, fmt.Errorf("net file version %d, expected %d", version, NNUE_FILE_VERSION)
+	}
+	if ( variant < 0 ) || ( variant >= len(VARIANT_TO_NAME) ) {
+		return nil, fmt.Errorf("unknown net variant %d", variant)
+	}
+	if ( hidden < 1 ) || ( hidden > NNUE_MAX_HIDDEN ) {
+		return nil, fmt.Errorf("invalid hidden layer size %d", hidden)
+	}
+
+	net := NewNNUENet(variant, hidden)
+	for _, data := range []interface{}{net.FeatureWeight, net.FeatureBias, net.OutputWeight, &net.OutputBias} {
+		if err := binary.Read(br, binary.LittleEndian, data); err != nil {
+			return nil, fmt.Errorf("truncated net file")
+		}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data in net file")
+	}
+	return net, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Write : writes the network in the format read by ReadNNUENet
+// -> net *NNUENet : network
+// -> w io.Writer : writer
+// <- error : error
+
+func (net *NNUENet) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(NNUE_MAGIC)
+	header := [3]uint32{NNUE_FILE_VERSION, uint32(net.Variant), uint32(net.Hidden)}
+	for _, data := range []interface{}{header, net.FeatureWeight, net.FeatureBias, net.OutputWeight, net.OutputBias} {
+		if err := binary.Write(bw, binary.LittleEndian, data); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadNNUE : loads the network of the current variant
+// an empty path loads the default net file of the variant
+// the current network is kept if the file is invalid
+// -> path string : path of the net file
+// <- error : error
+
+func LoadNNUE(path string) error {
+	if path == "" {
+		path = NNUE_DEFAULT_FILES[Variant]
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	net, err := ReadNNUENet(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if net.Variant != Variant {
+		return fmt.Errorf("%s: net is for %s, not %s", path, VARIANT_TO_NAME[net.Variant], VARIANT_TO_NAME[Variant])
+	}
+	nnueNet = net
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// SwitchNNUEVariant : drops a network of another variant after a variant change
+// the net file option belongs to the previous variant and is reset to the default
+// the default net of the new variant is loaded if the network is on
+// <- error : error
+
+func SwitchNNUEVariant() error {
+	if ( nnueNet == nil ) || ( nnueNet.Variant == Variant ) {
+		return nil
+	}
+	nnueNet = nil
+	NNUEFile = ""
+	if UseNNUE {
+		return LoadNNUE(NNUEFile)
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// NNUEActive : returns true if positions are evaluated by the network
+// a network of another variant is never used
+// <- bool : true if active
+
+func NNUEActive() bool {
+	return UseNNUE && ( nnueNet != nil ) && ( nnueNet.Variant == Variant )
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// record : records a piece change
+// -> ns *nnueState : state
+// -> sq Square : square
+// -> pi Piece : piece
+// -> add bool : true if pi is put on sq, false if removed
+
+func (ns *nnueState) record(sq Square, pi Piece, add bool) {
+	if ns.num == nnueMaxChanges {
+		ns.overflow = true
+		return
+	}
+	ns.changes[ns.num] = nnueChange{sq: sq, piece: pi, add: add}
+	ns.num++
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// reset : clears the changes of a new state
+// -> ns *nnueState : state
+
+func (ns *nnueState) reset() {
+	ns.num = 0
+	ns.overflow = false
+	ns.computed = false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// nnueKingBucket : returns the king bucket of a perspective
+// -> pos *Position : position
+// -> persp Color : perspective
+// <- int : bucket
+
+func nnueKingBucket(pos *Position, persp Color) int {
+	king := pos.ByPiece(persp, King)
+	if king == 0 {
+		return NNUE_KING_BUCKETS - 1
+	}
+	return int(king.AsSquare().POV(persp))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// nnueFeature : returns the input index of a piece on a square
+// -> persp Color : perspective
+// -> bucket int : king bucket of the perspective
+// -> pi Piece : piece
+// -> sq Square : square
+// <- int : index, -1 for the perspective's own king
+
+func nnueFeature(persp Color, bucket int, pi Piece, sq Square) int {
+	kind := int(pi.Figure() - Pawn)
+	if pi.Color() != persp {
+		kind += int(King - Pawn)
+	} else if pi.Figure() == King {
+		return -1
+	}
+	return ( bucket*NNUE_PIECE_TYPES + kind )*64 + int(sq.POV(persp))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// nnuePerspective : index of a perspective in the accumulators
+// -> persp Color : perspective
+// <- int : 0 for White, 1 for Black
+
+func nnuePerspective(persp Color) int {
+	if persp == White {
+		return 0
+	}
+	return 1
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// accumulator : returns the accumulator of a perspective in a state
+// -> pos *Position : position
+// -> state int : index of the state
+// -> persp Color : perspective
+// <- []int16 : accumulator
+
+func (pos *Position) accumulator(state int, persp Color) []int16 {
+	hidden := nnueNet.Hidden
+	from := ( 2*state + nnuePerspective(persp) ) * hidden
+	return pos.accumulators[from : from+hidden]
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// refreshAccumulator : computes an accumulator from scratch
+// -> pos *Position : position
+// -> acc []int16 : accumulator
+// -> persp Color : perspective
+
+func (pos *Position) refreshAccumulator(acc []int16, persp Color) {
+	copy(acc, nnueNet.FeatureBias)
+	bucket := nnueKingBucket(pos, persp)
+	for bb := pos.ByColor[White] | pos.ByColor[Black]; bb != 0; {
+		sq := bb.Pop()
+		if feature := nnueFeature(persp, bucket, pos.Get(sq), sq); feature >= 0 {
+			nnueAdd(acc, feature)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// nnueAdd : adds the weights of a feature to an accumulator
+// -> acc []int16 : accumulator
+// -> feature int : feature
+
+func nnueAdd(acc []int16, feature int) {
+	weights := nnueNet.FeatureWeight[feature*len(acc) : (feature+1)*len(acc)]
+	for i, w := range weights {
+		acc[i] += w
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// nnueSub : subtracts the weights of a feature from an accumulator
+// -> acc []int16 : accumulator
+// -> feature int : feature
+
+func nnueSub(acc []int16, feature int) {
+	weights := nnueNet.FeatureWeight[feature*len(acc) : (feature+1)*len(acc)]
+	for i, w := range weights {
+		acc[i] -= w
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// updateAccumulators : brings the accumulators of the current state up to date
+// the changes since the last computed state are applied to it
+// a perspective whose king moved, or a too distant state, is refreshed instead
+// -> pos *Position : position
+
+func (pos *Position) updateAccumulators() {
+	hidden := nnueNet.Hidden
+	size := 2 * hidden * len(pos.states)
+	if pos.accumulatorNet != nnueNet {
+		// the states were computed for another network
+		pos.accumulatorNet = nnueNet
+		pos.accumulators = nil
+		for i := range pos.states {
+			pos.states[i].nnue.computed = false
+		}
+	}
+	if len(pos.accumulators) < size {
+		grown := make([]int16, 2*size)
+		copy(grown, pos.accumulators)
+		pos.accumulators = grown
+	}
+
+	curr := len(pos.states) - 1
+	if pos.states[curr].nnue.computed {
+		return
+	}
+
+	// find the last computed state
+	base := -1
+	for k := curr; ( k >= 0 ) && ( curr-k <= NNUE_MAX_UPDATE_PLIES ); k-- {
+		if pos.states[k].nnue.computed {
+			base = k
+			break
+		}
+		if pos.states[k].nnue.overflow {
+			break
+		}
+	}
+
+	for _, persp := range []Color{White, Black} {
+		acc := pos.accumulator(curr, persp)
+		king := ColorFigure(persp, King)
+		refresh := base < 0
+		for k := base + 1; !refresh && ( k <= curr ); k++ {
+			ns := &pos.states[k].nnue
+			for _, change := range ns.changes[:ns.num] {
+				if change.piece == king {
+					refresh = true
+					break
+				}
+			}
+		}
+		if refresh {
+			pos.refreshAccumulator(acc, persp)
+			continue
+		}
+
+		copy(acc, pos.accumulator(base, persp))
+		bucket := nnueKingBucket(pos, persp)
+		for k := base + 1; k <= curr; k++ {
+			ns := &pos.states[k].nnue
+			for _, change := range ns.changes[:ns.num] {
+				feature := nnueFeature(persp, bucket, change.piece, change.sq)
+				if change.add {
+					nnueAdd(acc, feature)
+				} else {
+					nnueSub(acc, feature)
+				}
+			}
+		}
+	}
+	pos.states[curr].nnue.computed = true
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// EvaluateNNUE : evaluates position with the network from White's POV
+// -> pos *Position : position
+// <- int32 : eval, in the units of Evaluate
+
+func EvaluateNNUE(pos *Position) int32 {
+	pos.updateAccumulators()
+	curr := len(pos.states) - 1
+	us := pos.SideToMove
+	hidden := nnueNet.Hidden
+
+	output := int64(nnueNet.OutputBias)
+	output += nnueOutput(pos.accumulator(curr, us), nnueNet.OutputWeight[:hidden])
+	output += nnueOutput(pos.accumulator(curr, us.Opposite()), nnueNet.OutputWeight[hidden:])
+	score := output * NNUE_OUTPUT_SCALE / ( NNUE_QA * NNUE_QB )
+
+	// stay within the bounds of the evaluation
+	bound := int64(KnownWinScore/128 - 1)
+	if score > bound {
+		score = bound
+	} else if score < -bound {
+		score = -bound
+	}
+	return scoreMultiplier[us] * int32(score) * 128
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// nnueOutput : dot product of the clipped accumulator and the output weights
+// the sum is done in int32 over chunks small enough not to overflow
+// -> acc []int16 : accumulator
+// -> weights []int16 : output weights of the perspective
+// <- int64 : partial output
+
+func nnueOutput(acc []int16, weights []int16) int64 {
+	var sum int64
+	for len(acc) > 0 {
+		n := nnueOutputChunk
+		if n > len(acc) {
+			n = len(acc)
+		}
+		a, w := acc[:n], weights[:n]
+		var partial int32
+		for i, v := range a {
+			x := int32(v)
+			if x < 0 {
+				x = 0
+			}
+			if x > NNUE_QA {
+				x = NNUE_QA
+			}
+			partial += x * int32(w[i])
+		}
+		sum += int64(partial)
+		acc, weights = acc[n:], weights[n:]
+	}
+	return sum
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,219 @@
+//////////////////////////////////////////////////////
+// nnue_test.go
+// tests the incrementally updated accumulators against a refresh, writing and reading
+// net files and dropping a net of another variant, benchmarks the net against the classical evaluation
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// hidden layer size of the test networks
+const nnueTestHidden = 32
+
+// hidden layer size of the benchmarked network, the size of a trained net
+const nnueBenchHidden = 256
+
+// middlegame position the evaluations are benchmarked on
+const nnueBenchFEN = "r1bq1rk1/pp2bppp/2n1pn2/3p4/2PP4/2N1PN2/PP1B1PPP/R2QKB1R w KQ - 2 8"
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// nnueTestNet : returns a network with small random weights
+// -> variant int : variant
+// -> hidden int : hidden layer size
+// -> seed int64 : seed of the weights
+// <- *NNUENet : network
+
+func nnueTestNet(variant int, hidden int, seed int64) *NNUENet {
+	rnd := rand.New(rand.NewSource(seed))
+	net := NewNNUENet(variant, hidden)
+	for _, weights := range [][]int16{net.FeatureWeight, net.FeatureBias, net.OutputWeight} {
+		for i := range weights {
+			weights[i] = int16(rnd.Intn(129) - 64)
+		}
+	}
+	net.OutputBias = int32(rnd.Intn(2001) - 1000)
+	return net
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// checkNNUEAccumulators : compares the accumulators of the current state with a refresh
+// -> t *testing.T : test
+// -> pos *Position : position
+// -> line string : moves played, for the error message
+
+func checkNNUEAccumulators(t *testing.T, pos *Position, line string) {
+	t.Helper()
+	EvaluateNNUE(pos)
+	curr := len(pos.states) - 1
+	fresh := make([]int16, nnueNet.Hidden)
+	for _, persp := range []Color{White, Black} {
+		pos.refreshAccumulator(fresh, persp)
+		if acc := pos.accumulator(curr, persp); !reflect.DeepEqual(acc, fresh) {
+			t.Fatalf("%s: accumulator of %v differs from a refresh after %s", pos.String(), persp, line)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestNNUEIncrementalAccumulators : plays random games with take backs and checks
+// every incrementally updated accumulator against one computed from scratch
+
+func TestNNUEIncrementalAccumulators(t *testing.T) {
+	defer func() { nnueNet = nil }()
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings, VARIANT_Atomic, VARIANT_Horde} {
+		uci = NewUCI()
+		uci.SetVariant(variant)
+		nnueNet = nnueTestNet(variant, nnueTestHidden, int64(variant)+1)
+		rnd := rand.New(rand.NewSource(int64(variant)))
+		for game := 0; game < 8; game++ {
+			pos, err := PositionFromFEN(START_FENS[variant])
+			if err != nil {
+				t.Fatal(err)
+			}
+			line := ""
+			checkNNUEAccumulators(t, pos, "start")
+			for ply := 0; ply < 80; ply++ {
+				if IS_Atomic && ( pos.IsExploded(White) || pos.IsExploded(Black) ) {
+					// the game is over, the move generator assumes a king
+					break
+				}
+				moves := pos.GetLegalMoves(GET_ALL)
+				if len(moves) == 0 {
+					break
+				}
+				m := moves[rnd.Intn(len(moves))]
+				pos.DoMove(m)
+				line += " " + m.UCI()
+				checkNNUEAccumulators(t, pos, line)
+				if rnd.Intn(8) == 0 {
+					// take back and play another move from the computed state below
+					pos.UndoMove()
+					line += " undo"
+					checkNNUEAccumulators(t, pos, line)
+				}
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestNNUEReadWrite : writes a network and reads it back
+
+func TestNNUEReadWrite(t *testing.T) {
+	net := nnueTestNet(VARIANT_Atomic, nnueTestHidden, 7)
+	var buf bytes.Buffer
+	if err := net.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	read, err := ReadNNUENet(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, net) {
+		t.Errorf("read network differs from the written one")
+	}
+	if _, err := ReadNNUENet(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Errorf("truncated net file read without error")
+	}
+	if _, err := ReadNNUENet(bytes.NewReader(append(data, 0))); err == nil {
+		t.Errorf("net file with trailing data read without error")
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestNNUEVariantSwitch : checks that a net is not used after switching to another variant
+
+func TestNNUEVariantSwitch(t *testing.T) {
+	defer func() { nnueNet, UseNNUE = nil, false }()
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	nnueNet, UseNNUE = nnueTestNet(VARIANT_Standard, nnueTestHidden, 3), true
+	if !NNUEActive() {
+		t.Fatalf("net of the current variant is not active")
+	}
+	UseNNUE = false
+	if err := uci.SetVariant(VARIANT_Horde); err != nil {
+		t.Fatal(err)
+	}
+	UseNNUE = true
+	if NNUEActive() || ( nnueNet != nil ) {
+		t.Errorf("standard net still loaded after switching to horde")
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// benchmarkEvaluation : evaluates every move of a middlegame position after playing it
+// as the search does at its leaves, the parent position has been evaluated before
+// -> b *testing.B : benchmark
+// -> evaluate func(*Position) int32 : evaluation
+
+func benchmarkEvaluation(b *testing.B, evaluate func(*Position) int32) {
+	pos, err := PositionFromFEN(nnueBenchFEN)
+	if err != nil {
+		b.Fatal(err)
+	}
+	moves := pos.GetLegalMoves(GET_ALL)
+	evaluate(pos)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pos.DoMove(moves[i%len(moves)])
+		evaluate(pos)
+		pos.UndoMove()
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// BenchmarkEvaluateNNUE : network evaluation with the accumulators updated from the parent
+
+func BenchmarkEvaluateNNUE(b *testing.B) {
+	defer func() { nnueNet = nil }()
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	nnueNet = nnueTestNet(VARIANT_Standard, nnueBenchHidden, 1)
+	benchmarkEvaluation(b, EvaluateNNUE)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// BenchmarkEvaluateClassical : classical evaluation it replaces
+// the caches are disabled as the few benchmarked positions would always hit them
+
+func BenchmarkEvaluateClassical(b *testing.B) {
+	cache := disableCache
+	disableCache = true
+	defer func() {
+		disableCache = cache
+	}()
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	benchmarkEvaluation(b, Evaluate)
+}
+
+///////////////////////////////////////////////
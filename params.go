@@ -0,0 +1,228 @@
+//////////////////////////////////////////////////////
+// params.go
+// evaluation parameters and the parameter file format
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// version of the parameter file format, raised when parameters are added
+// version 2 : Racing Kings race terms
+// version 3 : Atomic king connection, explosion threat and safe square terms
+// version 4 : Horde chain, passed pawn, hole and king danger terms
+const PARAM_FILE_VERSION = 4
+
+// EvalParam : an evaluation parameter which can be tuned and loaded from a parameter file
+type EvalParam struct {
+	Name  string // name in the parameter file
+	Value *int32 // parameter
+	Step  int32  // step of the local search
+	Since int    // parameter file version the parameter was added in, 0 for the first version
+}
+
+// ParamFile : parameter file of a variant
+type ParamFile struct {
+	Version int              `json:"version"`
+	Variant string           `json:"variant"`
+	Params  map[string]int32 `json:"params"`
+}
+
+// current evaluation parameter file, empty for the built-in parameters
+var EvalFile = ""
+
+// built-in parameters by variant, saved before a parameter file is loaded first
+var defaultParams = map[int]map[string]int32{}
+
+///////////////////////////////////////////////
+// EvalParams : returns the evaluation parameters of the current variant
+// -> []EvalParam : parameters
+
+func EvalParams() []EvalParam {
+	params := []EvalParam{}
+	addScore := func(name string, score *Score, step int32, since int) {
+		params = append(params,
+			EvalParam{Name: name + ".M", Value: &score.M, Step: step, Since: since},
+			EvalParam{Name: name + ".E", Value: &score.E, Step: step, Since: since})
+	}
+
+	if IS_Racing_Kings {
+		// Racing Kings has its own evaluation in centipawns
+		for piece := Knight; piece < King; piece++ {
+			name := fmt.Sprintf("RK_PIECE_VALUES[%s]", FigureToName[piece])
+			params = append(params, EvalParam{Name: name, Value: &RK_PIECE_VALUES[piece], Step: 5})
+		}
+		params = append(params,
+			EvalParam{Name: "KING_ADVANCE_VALUE", Value: &KING_ADVANCE_VALUE, Step: 5},
+			EvalParam{Name: "KNIGHT_ADVANCE_VALUE", Value: &KNIGHT_ADVANCE_VALUE, Step: 1},
+			EvalParam{Name: "RK_RACE_DISTANCE_VALUE", Value: &RK_RACE_DISTANCE_VALUE, Step: 5, Since: 2},
+			EvalParam{Name: "RK_RACE_TEMPO_VALUE", Value: &RK_RACE_TEMPO_VALUE, Step: 5, Since: 2},
+			EvalParam{Name: "RK_FORWARD_CONTROL_VALUE", Value: &RK_FORWARD_CONTROL_VALUE, Step: 2, Since: 2})
+		return params
+	}
+
+	// weights are in 1/128 centipawns
+	for i := range Weights {
+		addScore(fmt.Sprintf("Weights[%d]", i), &Weights[i], 64, 0)
+	}
+	if IS_Atomic {
+		addScore("ATOMIC_PAWN_BONUS_SCORE", &ATOMIC_PAWN_BONUS_SCORE, 640, 0)
+		addScore("ATOMIC_QUEEN_BONUS_SCORE", &ATOMIC_QUEEN_BONUS_SCORE, 640, 0)
+		addScore("ATOMIC_KING_ATTACK_BONUS_SCORE", &ATOMIC_KING_ATTACK_BONUS_SCORE, 128, 0)
+		addScore("ATOMIC_KING_CONNECTION_SCORE", &ATOMIC_KING_CONNECTION_SCORE, 128, 3)
+		addScore("ATOMIC_EXPLOSION_THREAT_SCORE", &ATOMIC_EXPLOSION_THREAT_SCORE, 128, 3)
+		addScore("ATOMIC_KING_SAFE_SQUARE_SCORE", &ATOMIC_KING_SAFE_SQUARE_SCORE, 64, 3)
+		params = append(params, EvalParam{Name: "ATOMIC_MOBILITY_BONUS", Value: &ATOMIC_MOBILITY_BONUS, Step: 1})
+	}
+	if IS_Horde {
+		addScore("HORDE_BALANCE_SCORE", &HORDE_BALANCE_SCORE, 640, 0)
+		addScore("HORDE_CHAIN_SCORE", &HORDE_CHAIN_SCORE, 64, 4)
+		addScore("HORDE_PASSED_PAWN_SCORE", &HORDE_PASSED_PAWN_SCORE, 64, 4)
+		addScore("HORDE_HOLE_SCORE", &HORDE_HOLE_SCORE, 64, 4)
+		addScore("HORDE_KING_DANGER_SCORE", &HORDE_KING_DANGER_SCORE, 128, 4)
+		for i := range HORDE_CENTER_BONUS_WEIGHTS {
+			name := fmt.Sprintf("HORDE_CENTER_BONUS_WEIGHTS[%d]", i)
+			params = append(params, EvalParam{Name: name, Value: &HORDE_CENTER_BONUS_WEIGHTS[i], Step: 5})
+		}
+	}
+	return params
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// WriteParams : writes the current parameters of the variant as a parameter file
+// -> w io.Writer : writer
+// <- error : error
+
+func WriteParams(w io.Writer) error {
+	file := ParamFile{
+		Version: PARAM_FILE_VERSION,
+		Variant: VARIANT_TO_NAME[Variant],
+		Params:  map[string]int32{},
+	}
+	for _, param := range EvalParams() {
+		file.Params[param.Name] = *param.Value
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadParams : reads and validates a parameter file for the current variant
+// the file must have a known version, be for the current variant and contain every parameter
+// of the variant and nothing else, the parameters added after the version of the file may be missing
+// -> r io.Reader : reader
+// <- map[string]int32 : parameter values by name
+// <- error : error
+
+func ReadParams(r io.Reader) (map[string]int32, error) {
+	var file ParamFile
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid parameter file: %v", err)
+	}
+	if ( file.Version < 1 ) || ( file.Version > PARAM_FILE_VERSION ) {
+		return nil, fmt.Errorf("unsupported parameter file version %d, expected 1 to %d", file.Version, PARAM_FILE_VERSION)
+	}
+	if file.Variant != VARIANT_TO_NAME[Variant] {
+		return nil, fmt.Errorf("parameter file is for variant %q, current variant is %q", file.Variant, VARIANT_TO_NAME[Variant])
+	}
+
+	params := EvalParams()
+	known := map[string]bool{}
+	for _, param := range params {
+		known[param.Name] = true
+		if _, ok := file.Params[param.Name]; !ok && ( param.Since <= file.Version ) {
+			return nil, fmt.Errorf("parameter file is missing %s", param.Name)
+		}
+	}
+	for name := range file.Params {
+		if !known[name] {
+			return nil, fmt.Errorf("parameter file has unknown parameter %s", name)
+		}
+	}
+	return file.Params, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// SetParams : sets the evaluation parameters of the current variant
+// re-initializes the weights and clears the evaluation caches
+// -> values map[string]int32 : parameter values by name, missing ones are left unchanged
+
+func SetParams(values map[string]int32) {
+	for _, param := range EvalParams() {
+		if value, ok := values[param.Name]; ok {
+			*param.Value = value
+		}
+	}
+	initWeights()
+	clearEvalCaches()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadEvalFile : loads the evaluation parameters of the current variant from a file
+// an empty path restores the built-in parameters, so do the parameters missing in a file of an older version
+// nothing is changed if the file is invalid
+// -> path string : path of the parameter file
+// <- error : error
+
+func LoadEvalFile(path string) error {
+	// save the built-in parameters so that they can be restored
+	if _, ok := defaultParams[Variant]; !ok {
+		defaults := map[string]int32{}
+		for _, param := range EvalParams() {
+			defaults[param.Name] = *param.Value
+		}
+		defaultParams[Variant] = defaults
+	}
+
+	if path == "" {
+		SetParams(defaultParams[Variant])
+		EvalFile = ""
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	values, err := ReadParams(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	params := map[string]int32{}
+	for name, value := range defaultParams[Variant] {
+		params[name] = value
+	}
+	for name, value := range values {
+		params[name] = value
+	}
+	SetParams(params)
+	EvalFile = path
+	return nil
+}
+
+///////////////////////////////////////////////
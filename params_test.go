@@ -0,0 +1,189 @@
+//////////////////////////////////////////////////////
+// params_test.go
+// tests the versions of the parameters and reading parameter files of every version and rejecting incomplete ones
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// parameters added by each file version, by name prefix
+// a version is never reused once files of it may have been written
+var paramsTestHistory = map[int][]string{
+	2: {"RK_RACE_DISTANCE_VALUE", "RK_RACE_TEMPO_VALUE", "RK_FORWARD_CONTROL_VALUE"},
+	3: {"ATOMIC_KING_CONNECTION_SCORE", "ATOMIC_EXPLOSION_THREAT_SCORE", "ATOMIC_KING_SAFE_SQUARE_SCORE"},
+	4: {"HORDE_CHAIN_SCORE", "HORDE_PASSED_PAWN_SCORE", "HORDE_HOLE_SCORE", "HORDE_KING_DANGER_SCORE"},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// paramsTestFile : encodes a parameter file of the current variant
+// with the current values of the parameters known in a version
+// -> t *testing.T : test
+// -> version int : version of the file
+// -> skip string : name of a parameter left out, empty for none
+// <- []byte : parameter file
+
+func paramsTestFile(t *testing.T, version int, skip string) []byte {
+	file := ParamFile{Version: version, Variant: VARIANT_TO_NAME[Variant], Params: map[string]int32{}}
+	for _, param := range EvalParams() {
+		if ( param.Since <= version ) && ( param.Name != skip ) {
+			file.Params[param.Name] = *param.Value
+		}
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestEvalParamsSince : every parameter is marked with the file version it was added in
+
+func TestEvalParamsSince(t *testing.T) {
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings, VARIANT_Atomic, VARIANT_Horde} {
+		uci = NewUCI()
+		uci.SetVariant(variant)
+		for _, param := range EvalParams() {
+			since := 0
+			for version, prefixes := range paramsTestHistory {
+				for _, prefix := range prefixes {
+					if strings.HasPrefix(param.Name, prefix) {
+						since = version
+					}
+				}
+			}
+			if param.Since != since {
+				t.Errorf("%s: %s added in version %d, want %d", VARIANT_TO_NAME[variant], param.Name, param.Since, since)
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestReadParamsVersions : files of every version are read, a file of the current version
+// lacking a parameter of any version is rejected
+
+func TestReadParamsVersions(t *testing.T) {
+	for _, variant := range []int{VARIANT_Standard, VARIANT_Racing_Kings, VARIANT_Atomic, VARIANT_Horde} {
+		uci = NewUCI()
+		uci.SetVariant(variant)
+		name := VARIANT_TO_NAME[variant]
+
+		var written bytes.Buffer
+		if err := WriteParams(&written); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadParams(&written); err != nil {
+			t.Errorf("%s: written file rejected: %v", name, err)
+		}
+
+		for version := 1; version <= PARAM_FILE_VERSION; version++ {
+			if _, err := ReadParams(bytes.NewReader(paramsTestFile(t, version, ""))); err != nil {
+				t.Errorf("%s: version %d file rejected: %v", name, version, err)
+			}
+		}
+
+		for _, param := range EvalParams() {
+			_, err := ReadParams(bytes.NewReader(paramsTestFile(t, PARAM_FILE_VERSION, param.Name)))
+			if ( err == nil ) || !strings.Contains(err.Error(), "missing " + param.Name) {
+				t.Errorf("%s: file without %s: got error %v", name, param.Name, err)
+			}
+		}
+
+		for _, version := range []int{0, PARAM_FILE_VERSION + 1} {
+			if _, err := ReadParams(bytes.NewReader(paramsTestFile(t, version, ""))); err == nil {
+				t.Errorf("%s: version %d file accepted", name, version)
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestReadParamsInvalid : files of another variant or with unknown parameters are rejected
+
+func TestReadParamsInvalid(t *testing.T) {
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Atomic)
+	file := ParamFile{}
+	if err := json.Unmarshal(paramsTestFile(t, PARAM_FILE_VERSION, ""), &file); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Params["NO_SUCH_PARAM"] = 1
+	data, _ := json.Marshal(file)
+	if _, err := ReadParams(bytes.NewReader(data)); err == nil {
+		t.Errorf("unknown parameter accepted")
+	}
+
+	delete(file.Params, "NO_SUCH_PARAM")
+	file.Variant = VARIANT_TO_NAME[VARIANT_Horde]
+	data, _ = json.Marshal(file)
+	if _, err := ReadParams(bytes.NewReader(data)); err == nil {
+		t.Errorf("file of another variant accepted")
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestLoadEvalFileOlderVersion : the parameters missing in an older file keep their built-in values
+
+func TestLoadEvalFileOlderVersion(t *testing.T) {
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Horde)
+	defer LoadEvalFile("")
+
+	builtin := map[string]int32{}
+	for _, param := range EvalParams() {
+		builtin[param.Name] = *param.Value
+	}
+
+	// an old file with every parameter it knows raised by one
+	file := ParamFile{Version: 1, Variant: VARIANT_TO_NAME[Variant], Params: map[string]int32{}}
+	for _, param := range EvalParams() {
+		if param.Since <= 1 {
+			file.Params[param.Name] = *param.Value + 1
+		}
+	}
+	data, _ := json.Marshal(file)
+	path := filepath.Join(t.TempDir(), "horde.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadEvalFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, param := range EvalParams() {
+		want := builtin[param.Name]
+		if param.Since <= 1 {
+			want++
+		}
+		if *param.Value != want {
+			t.Errorf("%s: got %d, want %d", param.Name, *param.Value, want)
+		}
+	}
+}
+
+///////////////////////////////////////////////
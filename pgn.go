@@ -0,0 +1,320 @@
+//////////////////////////////////////////////////////
+// pgn.go
+// implements a minimal PGN reader
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// PGNGame : a game read from a PGN file
+type PGNGame struct {
+	Tags   map[string]string // tag pairs
+	Moves  []string          // SAN moves of the main line
+	Result string            // game result, 1-0, 0-1, 1/2-1/2 or *
+}
+
+// tag pair line
+var rePGNTag = regexp.MustCompile(`^\[\s*(\w+)\s+"(.*)"\s*\]$`)
+
+// move number, e.g. 12. or 12...
+var rePGNMoveNumber = regexp.MustCompile(`^\d+\.+`)
+
+// PGN game results
+var PGN_RESULTS = map[string]bool{"1-0": true, "0-1": true, "1/2-1/2": true, "*": true}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadPGN : reads all games from a PGN stream
+// comments, variations and NAGs are skipped
+// -> r io.Reader : reader
+// <- []*PGNGame : games
+// <- error : error
+
+func ReadPGN(r io.Reader) ([]*PGNGame, error) {
+	games := []*PGNGame{}
+	err := ScanPGN(r, func(game *PGNGame) error {
+		games = append(games, game)
+		return nil
+	})
+	return games, err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ScanPGN : reads the games from a PGN stream one by one, so that large collections are not held in memory
+// comments, variations and NAGs are skipped
+// -> r io.Reader : reader
+// -> fn func(game *PGNGame) error : called for each game, an error stops the scan and is returned
+// <- error : error
+
+func ScanPGN(r io.Reader, fn func(game *PGNGame) error) error {
+	game := &PGNGame{Tags: map[string]string{}}
+	comment := false // inside a { } comment
+	variation := 0   // nesting level of ( ) variations
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1<<16), 1<<24)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !comment && ( variation == 0 ) {
+			if tag := rePGNTag.FindStringSubmatch(line); tag != nil {
+				game.Tags[tag[1]] = tag[2]
+				continue
+			}
+			if strings.HasPrefix(line, "%") {
+				// escape mechanism
+				continue
+			}
+		}
+
+		// separate comment and variation delimiters from the tokens
+		for _, delim := range []string{"{", "}", "(", ")", ";"} {
+			line = strings.Replace(line, delim, " "+delim+" ", -1)
+		}
+
+		for _, token := range strings.Fields(line) {
+			if comment {
+				comment = token != "}"
+				continue
+			}
+			if token == ";" {
+				// rest of line comment
+				break
+			}
+			switch token {
+			case "{":
+				comment = true
+				continue
+			case "(":
+				variation++
+				continue
+			case ")":
+				variation--
+				continue
+			}
+			if ( variation > 0 ) || strings.HasPrefix(token, "$") {
+				continue
+			}
+			token = rePGNMoveNumber.ReplaceAllString(token, "")
+			if token == "" {
+				continue
+			}
+			if PGN_RESULTS[token] {
+				game.Result = token
+				if err := fn(game); err != nil {
+					return err
+				}
+				game = &PGNGame{Tags: map[string]string{}}
+				continue
+			}
+			game.Moves = append(game.Moves, strings.TrimRight(token, "!?"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// game without result at the end of the file
+	if len(game.Moves) > 0 {
+		if result, ok := game.Tags["Result"]; ok {
+			game.Result = result
+		} else {
+			game.Result = "*"
+		}
+		return fn(game)
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Variant : returns the variant of the game from the Variant tag
+// -> g *PGNGame : game
+// <- int : variant
+// <- bool : true if the variant is supported
+
+func (g *PGNGame) Variant() (int, bool) {
+	name, ok := g.Tags["Variant"]
+	if !ok || ( name == "" ) || ( name == "From Position" ) {
+		return VARIANT_Standard, true
+	}
+	variant, ok := VARIANT_NAME_TO_VARIANT[name]
+	return variant, ok
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// StartPosition : returns the starting position of the game in the current variant
+// uses the FEN tag if present
+// -> g *PGNGame : game
+// <- *Position : position
+// <- error : error
+
+func (g *PGNGame) StartPosition() (*Position, error) {
+	if fen, ok := g.Tags["FEN"]; ok {
+		return PositionFromFEN(fen)
+	}
+	return PositionFromFEN(START_FENS[Variant])
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Replay : replays the game calling visit before each move
+// -> g *PGNGame : game
+// -> visit func(pos *Position, move Move) bool : callback, return false to stop
+// <- error : error, in case of an illegal move
+
+func (g *PGNGame) Replay(visit func(pos *Position, move Move) bool) error {
+	pos, err := g.StartPosition()
+	if err != nil {
+		return err
+	}
+	for i, san := range g.Moves {
+		move, err := pos.LegalSANToMove(san)
+		if err != nil {
+			return fmt.Errorf("move %d %s: %v", i/2+1, san, err)
+		}
+		if !visit(pos, move) {
+			return nil
+		}
+		pos.DoMove(move)
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LegalSANToMove : parses a SAN move and verifies that it is legal
+// -> pos *Position : position
+// -> san string : move in SAN
+// <- Move : move
+// <- error : error
+
+func (pos *Position) LegalSANToMove(san string) (Move, error) {
+	move, err := pos.SANToMove(san)
+	legals := pos.GetLegalMoves(GET_ALL)
+	if err == nil {
+		for _, legal := range legals {
+			if legal == move {
+				return move, nil
+			}
+		}
+		err = fmt.Errorf("illegal move")
+	}
+	// SANToMove may pick a pinned piece when the SAN is only unambiguous among legal moves,
+	// or miss a move legal in the variant
+	san = strings.TrimRight(san, "+#")
+	for _, legal := range legals {
+		if strings.TrimRight(pos.MoveToSAN(legal), "+#") == san {
+			return legal, nil
+		}
+	}
+	return NullMove, err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// MoveToSAN : converts a legal move to SAN
+// -> pos *Position : position
+// -> m Move : move
+// <- string : move in SAN
+
+func (pos *Position) MoveToSAN(m Move) string {
+	san := ""
+	if m.MoveType() == Castling {
+		san = "O-O-O"
+		if m.To().File() == 6 {
+			san = "O-O"
+		}
+	} else {
+		capture := ( m.Capture() != NoPiece ) || ( m.MoveType() == Enpassant )
+		if m.Piece().Figure() == Pawn {
+			if capture {
+				san = m.From().String()[:1]
+			}
+		} else {
+			san = figureToSymbol[m.Piece().Figure()]
+			// disambiguation by file, rank or both
+			samefile, samerank, ambiguous := false, false, false
+			for _, other := range pos.GetLegalMoves(GET_ALL) {
+				if ( other.Piece() != m.Piece() ) || ( other.To() != m.To() ) || ( other.From() == m.From() ) {
+					continue
+				}
+				ambiguous = true
+				samefile = samefile || ( other.From().File() == m.From().File() )
+				samerank = samerank || ( other.From().Rank() == m.From().Rank() )
+			}
+			if ambiguous {
+				if !samefile {
+					san += m.From().String()[:1]
+				} else if !samerank {
+					san += m.From().String()[1:]
+				} else {
+					san += m.From().String()
+				}
+			}
+		}
+		if capture {
+			san += "x"
+		}
+		san += m.To().String()
+		if m.MoveType() == Promotion {
+			san += "=" + figureToSymbol[m.Promotion().Figure()]
+		}
+	}
+	pos.DoMove(m)
+	them := pos.SideToMove
+	// a lost king or lost pawns have to be detected before generating moves
+	if ( IS_Atomic && pos.IsExploded(them) ) || ( IS_Horde && ( them == HORDE_Pawns_Side ) && pos.AllPawnsCaptured() ) {
+		san += "#"
+	} else if pos.IsChecked(them) {
+		if len(pos.GetLegalMoves(GET_ALL)) == 0 {
+			san += "#"
+		} else {
+			san += "+"
+		}
+	}
+	pos.UndoMove()
+	return san
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// PGNResultScore : converts a PGN result to a score from White's POV
+// -> result string : result
+// <- float64 : 1 for white win, 0.5 for draw, 0 for black win
+// <- bool : false if the result is unknown
+
+func PGNResultScore(result string) (float64, bool) {
+	switch result {
+	case "1-0":
+		return 1, true
+	case "0-1":
+		return 0, true
+	case "1/2-1/2":
+		return 0.5, true
+	}
+	return 0, false
+}
+
+///////////////////////////////////////////////
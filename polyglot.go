@@ -0,0 +1,297 @@
+//////////////////////////////////////////////////////
+// polyglot.go
+// implements reading, writing and probing Polyglot .bin opening books
+// (http://hgm.nubati.net/book_format.html)
+// Polyglot keys don't encode the variant, a book of a non standard variant is keyed
+// by the Polyglot key xor the variant's POLYGLOT_VARIANT_KEYS constant, so that
+// standard books stay compatible with Polyglot tools and a book of one variant
+// never matches positions of another
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// PolyglotEntry : a 16 byte book entry
+type PolyglotEntry struct {
+	Key    uint64 // position key
+	Move   uint16 // move in Polyglot encoding
+	Weight uint16 // relative probability of playing the move, 0 is never
+	Learn  uint32 // learning data, not used
+}
+
+// size of an entry on disk
+const POLYGLOT_ENTRY_SIZE = 16
+
+// key of each variant xored to the Polyglot key, 0 for standard
+var POLYGLOT_VARIANT_KEYS = [...]uint64{
+	0x0000000000000000, // standard
+	0x5A17E9C8B3D42F61, // racing kings
+	0xA7C3F01E6B95D28D, // atomic
+	0x3E8D2B74C1F0A956, // horde
+}
+
+// centipawns a move can be worse than the best move to be exported
+var POLYGLOT_EXPORT_MARGIN = 50
+
+// Polyglot promotion codes by figure
+var polyglotPromotions = [FigureArraySize]uint16{Knight: 1, Bishop: 2, Rook: 3, Queen: 4}
+
+// book entries sorted by key
+var PolyglotBook = []PolyglotEntry{}
+
+// current Polyglot book file, empty if none
+var PolyglotFile = ""
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// PolyglotKey : returns the Polyglot book key of the position in the current variant
+// -> pos *Position : position
+// <- uint64 : key
+
+func (pos *Position) PolyglotKey() uint64 {
+	return pos.Zobrist() ^ POLYGLOT_VARIANT_KEYS[Variant]
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// PolyglotMove : returns the Polyglot encoding of a move
+// castling is encoded as the king capturing its rook
+// -> m Move : move
+// <- uint16 : encoded move
+
+func PolyglotMove(m Move) uint16 {
+	to := m.To()
+	if m.MoveType() == Castling {
+		if to.File() == 6 {
+			to = RankFile(to.Rank(), 7)
+		} else {
+			to = RankFile(to.Rank(), 0)
+		}
+	}
+	return uint16(to) | uint16(m.From())<<6 | polyglotPromotions[m.Promotion().Figure()]<<12
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadPolyglot : reads a Polyglot book
+// -> path string : file path
+// <- []PolyglotEntry : entries sorted by key
+// <- error : error
+
+func ReadPolyglot(path string) ([]PolyglotEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%POLYGLOT_ENTRY_SIZE != 0 {
+		return nil, fmt.Errorf("%s is not a Polyglot book, size %d", path, len(data))
+	}
+	entries := make([]PolyglotEntry, len(data)/POLYGLOT_ENTRY_SIZE)
+	for i := range entries {
+		b := data[i*POLYGLOT_ENTRY_SIZE:]
+		entries[i] = PolyglotEntry{
+			Key    : binary.BigEndian.Uint64(b[0:]),
+			Move   : binary.BigEndian.Uint16(b[8:]),
+			Weight : binary.BigEndian.Uint16(b[10:]),
+			Learn  : binary.BigEndian.Uint32(b[12:]),
+		}
+	}
+	// books written by other tools are expected to be sorted, but binary search relies on it
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// WritePolyglot : writes a Polyglot book, entries are sorted by key and descending weight
+// -> path string : file path
+// -> entries []PolyglotEntry : entries
+// <- error : error
+
+func WritePolyglot(path string, entries []PolyglotEntry) error {
+	sorted := append([]PolyglotEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Key != sorted[j].Key {
+			return sorted[i].Key < sorted[j].Key
+		}
+		return sorted[i].Weight > sorted[j].Weight
+	})
+	data := make([]byte, len(sorted)*POLYGLOT_ENTRY_SIZE)
+	for i, entry := range sorted {
+		b := data[i*POLYGLOT_ENTRY_SIZE:]
+		binary.BigEndian.PutUint64(b[0:], entry.Key)
+		binary.BigEndian.PutUint16(b[8:], entry.Move)
+		binary.BigEndian.PutUint16(b[10:], entry.Weight)
+		binary.BigEndian.PutUint32(b[12:], entry.Learn)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadPolyglot : loads the Polyglot book of the current variant and enables the book
+// -> path string : file path, empty unloads the book
+// <- int : number of entries
+// <- error : error, the previous book is kept
+
+func LoadPolyglot(path string) (int, error) {
+	if path == "" {
+		PolyglotBook = []PolyglotEntry{}
+		PolyglotFile = ""
+		return 0, nil
+	}
+	entries, err := ReadPolyglot(path)
+	if err != nil {
+		return 0, err
+	}
+	PolyglotBook = entries
+	PolyglotFile = path
+	UseBook = true
+	return len(entries), nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GetPolyglotEntries : returns the entries of the position in the Polyglot book
+// -> pos *Position : position
+// <- []PolyglotEntry : entries
+
+func (pos *Position) GetPolyglotEntries() []PolyglotEntry {
+	key := pos.PolyglotKey()
+	i := sort.Search(len(PolyglotBook), func(i int) bool { return PolyglotBook[i].Key >= key })
+	j := i
+	for ( j < len(PolyglotBook) ) && ( PolyglotBook[j].Key == key ) {
+		j++
+	}
+	return PolyglotBook[i:j]
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GetPolyglotBookMove : picks a legal move of the Polyglot book at random in proportion to the weights
+// -> pos *Position : position
+// <- string : algeb
+// <- bool : true if found
+
+func (pos *Position) GetPolyglotBookMove() (string, bool) {
+	entries := pos.GetPolyglotEntries()
+	if len(entries) == 0 {
+		return "", false
+	}
+	if _, over := pos.Terminal(); over {
+		return "", false
+	}
+	legal := map[uint16]Move{}
+	for _, m := range pos.GetLegalMoves(GET_ALL) {
+		legal[PolyglotMove(m)] = m
+	}
+	candidates, weights, total := []Move{}, []int{}, 0
+	for _, entry := range entries {
+		if m, ok := legal[entry.Move]; ok && ( entry.Weight > 0 ) {
+			candidates = append(candidates, m)
+			weights = append(weights, int(entry.Weight))
+			total += int(entry.Weight)
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+	r := Rand.Intn(total)
+	for i, w := range weights {
+		if r < w {
+			return candidates[i].UCI(), true
+		}
+		r -= w
+	}
+	return "", false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GetBookMove : gets a book move for the position, the Polyglot book is tried before the simple book
+// -> pos *Position : position
+// <- string : algeb
+// <- bool : true if found
+
+func (pos *Position) GetBookMove() (string, bool) {
+	if algeb, found := pos.GetPolyglotBookMove(); found {
+		return algeb, true
+	}
+	return pos.GetSimpleBookMove()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ExportPolyglot : exports Book to a Polyglot book in the current variant
+// the best move and the moves at most POLYGLOT_EXPORT_MARGIN worse are exported,
+// weighted by how close they are to the best move, negatively annotated moves are left out
+// -> path string : file path
+// <- int : number of entries
+// <- error : error
+
+func ExportPolyglot(path string) (int, error) {
+	entries := []PolyglotEntry{}
+	var fenerr error
+	err := RangeBook(func(zobriststr string, posentry BookPositionEntry) {
+		mentrylist := posentry.GetSortedMoveEntryList()
+		if ( fenerr != nil ) || ( len(mentrylist) == 0 ) || mentrylist[0].Unscored() || ( mentrylist[0].Nodes < MinSimpleBookNodes ) {
+			return
+		}
+		pos, err := PositionFromFEN(posentry.Fen)
+		if err != nil {
+			fenerr = fmt.Errorf("position %s: %v", zobriststr, err)
+			return
+		}
+		best := mentrylist[0].ChoiceEval()
+		for i, mentry := range mentrylist {
+			diff := best - mentry.ChoiceEval()
+			if ( i > 0 ) && ( ( diff > POLYGLOT_EXPORT_MARGIN ) || ( mentry.Int1 < 0 ) || mentry.Unscored() ) {
+				continue
+			}
+			m, err := pos.UCIToMove(mentry.Algeb)
+			if err != nil {
+				continue
+			}
+			if diff < 0 {
+				// annotated above the best move
+				diff = 0
+			} else if diff > POLYGLOT_EXPORT_MARGIN {
+				diff = POLYGLOT_EXPORT_MARGIN
+			}
+			entries = append(entries, PolyglotEntry{
+				Key    : pos.PolyglotKey(),
+				Move   : PolyglotMove(m),
+				Weight : uint16(1 + POLYGLOT_EXPORT_MARGIN - diff),
+			})
+		}
+	})
+	if err == nil {
+		err = fenerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), WritePolyglot(path, entries)
+}
+
+///////////////////////////////////////////////
@@ -0,0 +1,164 @@
+//////////////////////////////////////////////////////
+// polyglot_test.go
+// tests the Polyglot keys against the published test vectors, the move encoding,
+// the variant keys and writing and reading books
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// polyglotKeyTestCase : a line from the start position and the key of the position it reaches
+type polyglotKeyTestCase struct {
+	moves string // moves in UCI notation
+	key   uint64 // published key
+}
+
+// test vectors of the Polyglot book format specification
+var polyglotKeyTestCases = []polyglotKeyTestCase{
+	{"", 0x463b96181691fc9c},
+	{"e2e4", 0x823c9b50fd114196},
+	{"e2e4 d7d5", 0x0756b94461c50fb0},
+	{"e2e4 d7d5 e4e5", 0x662fafb965db29d4},
+	{"e2e4 d7d5 e4e5 f7f5", 0x22a48b5a8e47ff78},
+	{"e2e4 d7d5 e4e5 f7f5 e1e2", 0x652a607ca3f242c1},
+	{"e2e4 d7d5 e4e5 f7f5 e1e2 e8f7", 0x00fdd303c946bdd9},
+	{"a2a4 b7b5 h2h4 b5b4 c2c4", 0x3c8123ea7b067637},
+	{"a2a4 b7b5 h2h4 b5b4 c2c4 b4c3 a1a3", 0x5c3f9b829b279560},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// polyglotTestPosition : plays a line from the start position of a variant
+// -> t *testing.T : test
+// -> variant int : variant
+// -> moves string : moves in UCI notation
+// <- *Position : position reached
+
+func polyglotTestPosition(t *testing.T, variant int, moves string) *Position {
+	uci = NewUCI()
+	uci.SetVariant(variant)
+	pos, err := PositionFromFEN(START_FENS[variant])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algeb := range strings.Fields(moves) {
+		m, err := pos.UCIToMove(algeb)
+		if err != nil {
+			t.Fatalf("%s: %v", algeb, err)
+		}
+		pos.DoMove(m)
+	}
+	return pos
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPolyglotKey : checks the keys of standard chess against the test vectors
+
+func TestPolyglotKey(t *testing.T) {
+	for _, tc := range polyglotKeyTestCases {
+		pos := polyglotTestPosition(t, VARIANT_Standard, tc.moves)
+		if key := pos.PolyglotKey(); key != tc.key {
+			t.Errorf("%q: key %016x, expected %016x", tc.moves, key, tc.key)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPolyglotVariantKey : checks that the key of a variant is the standard key xored with the variant key
+
+func TestPolyglotVariantKey(t *testing.T) {
+	for _, variant := range []int{VARIANT_Atomic, VARIANT_Horde, VARIANT_Racing_Kings} {
+		pos := polyglotTestPosition(t, variant, "")
+		if key := pos.PolyglotKey(); key != pos.Zobrist()^POLYGLOT_VARIANT_KEYS[variant] {
+			t.Errorf("%s: key %016x, expected %016x", VARIANT_TO_NAME[variant], key, pos.Zobrist()^POLYGLOT_VARIANT_KEYS[variant])
+		}
+	}
+	// the start positions of standard and atomic chess are the same, only the variant key differs
+	pos := polyglotTestPosition(t, VARIANT_Atomic, "")
+	if key := pos.PolyglotKey(); key != polyglotKeyTestCases[0].key^POLYGLOT_VARIANT_KEYS[VARIANT_Atomic] {
+		t.Errorf("atomic start key %016x, expected %016x", key, polyglotKeyTestCases[0].key^POLYGLOT_VARIANT_KEYS[VARIANT_Atomic])
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPolyglotMove : checks the move encoding, castling is the king taking its rook
+
+func TestPolyglotMove(t *testing.T) {
+	cases := []struct {
+		fen   string
+		algeb string
+		move  uint16
+	}{
+		{START_FENS[VARIANT_Standard], "e2e4", uint16(SquareE4) | uint16(SquareE2)<<6},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", 263},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1c1", uint16(SquareA1) | uint16(SquareE1)<<6},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8g8", uint16(SquareH8) | uint16(SquareE8)<<6},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8c8", uint16(SquareA8) | uint16(SquareE8)<<6},
+		{"8/1P6/8/8/8/8/8/k6K w - - 0 1", "b7b8q", uint16(SquareB8) | uint16(SquareB7)<<6 | 4<<12},
+		{"8/1P6/8/8/8/8/8/k6K w - - 0 1", "b7b8n", uint16(SquareB8) | uint16(SquareB7)<<6 | 1<<12},
+	}
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	for _, tc := range cases {
+		pos, err := PositionFromFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := pos.UCIToMove(tc.algeb)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.algeb, err)
+		}
+		if move := PolyglotMove(m); move != tc.move {
+			t.Errorf("%s %s: move %d, expected %d", tc.fen, tc.algeb, move, tc.move)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestPolyglotReadWrite : writes a book and reads it back sorted by key and descending weight
+
+func TestPolyglotReadWrite(t *testing.T) {
+	entries := []PolyglotEntry{
+		{Key: 0x823c9b50fd114196, Move: 1, Weight: 5, Learn: 7},
+		{Key: 0x463b96181691fc9c, Move: 2, Weight: 1},
+		{Key: 0x463b96181691fc9c, Move: 3, Weight: 9},
+	}
+	path := filepath.Join(t.TempDir(), "book.bin")
+	if err := WritePolyglot(path, entries); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPolyglot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PolyglotEntry{entries[2], entries[1], entries[0]}
+	if len(read) != len(expected) {
+		t.Fatalf("%d entries, expected %d", len(read), len(expected))
+	}
+	for i := range expected {
+		if read[i] != expected[i] {
+			t.Errorf("entry %d is %+v, expected %+v", i, read[i], expected[i])
+		}
+	}
+}
+
+///////////////////////////////////////////////
@@ -12,6 +12,7 @@ package lib
 import(
 	"time"
 	"sync"
+	"sync/atomic"
 	"fmt"
 	"unsafe"
 )
@@ -35,6 +36,18 @@ var KING_ADVANCE_VALUE int32    = 250
 // knight advance value for racing kings
 var KNIGHT_ADVANCE_VALUE int32  = 5
 
+// racing kings value of each king step saved on the way to the 8th rank
+var RK_RACE_DISTANCE_VALUE int32 = 60
+
+// racing kings bonus for the side which wins the race counting tempo
+var RK_RACE_TEMPO_VALUE int32   = 150
+
+// racing kings value of each controlled square in front of the opponent king
+var RK_FORWARD_CONTROL_VALUE int32 = 20
+
+// racing kings race distance of a king which cannot reach the 8th rank
+const RK_RACE_UNREACHABLE int32 = 16
+
 // atomic pawn bonus
 var ATOMIC_PAWN_BONUS           = 225
 
@@ -56,6 +69,15 @@ var ATOMIC_QUEEN_BONUS_SCORE    = Score{ M: int32(ATOMIC_QUEEN_BONUS*128) , E: i
 // atomic king attack bonus score
 var ATOMIC_KING_ATTACK_BONUS_SCORE = Score{ M: int32(ATOMIC_KING_ATTACK_BONUS*128) , E: int32(ATOMIC_KING_ATTACK_BONUS*128) }
 
+// atomic bonus for the side behind in material when the kings are connected
+var ATOMIC_KING_CONNECTION_SCORE = Score{ M: 40*128 , E: 60*128 }
+
+// atomic bonus for each piece next to the opponent king which can be captured
+var ATOMIC_EXPLOSION_THREAT_SCORE = Score{ M: 60*128 , E: 40*128 }
+
+// atomic bonus for each king square safe from captures nearby
+var ATOMIC_KING_SAFE_SQUARE_SCORE = Score{ M: 8*128 , E: 4*128 }
+
 // horde pawn scores
 var HORDE_PAWN_SCORES [ColorArraySize]Score
 
@@ -68,6 +90,41 @@ var HORDE_CENTER_BONUS          = Score{ M: int32(128) , E: int32(128) }
 // horde center bonus weights
 var HORDE_CENTER_BONUS_WEIGHTS  = [...]int32{ 0, 80, 120, 150, 150, 120, 80, 0 }
 
+// horde bonus for each pawn defended by another pawn
+var HORDE_CHAIN_SCORE           = Score{ M: int32(8*128) , E: int32(6*128) }
+
+// horde bonus for a passed pawn of the horde, multiplied by its rank
+var HORDE_PASSED_PAWN_SCORE     = Score{ M: int32(4*128) , E: int32(10*128) }
+
+// horde penalty for each square behind the horde that its pawns can never attack
+var HORDE_HOLE_SCORE            = Score{ M: int32(-6*128) , E: int32(-3*128) }
+
+// horde penalty of the piece side for each horde pawn close to its king
+var HORDE_KING_DANGER_SCORE     = Score{ M: int32(-15*128) , E: int32(-8*128) }
+
+// default contempt in centipawns by variant and by the side the engine plays at root
+// a positive contempt makes the engine avoid draws
+var VARIANT_CONTEMPT = [...][ColorArraySize]int32{
+	// Standard
+	{ 0, 0, 0 },
+	// Racing Kings
+	{ 0, 0, 0 },
+	// Atomic
+	{ 0, 15, 15 },
+	// Horde : the pieces side ( Black ) should play for a win, the pawns side ( White ) is happier with a draw
+	// the Contempt option advertises 0 for it, see ContemptOptionDefault
+	{ 0, 25, 10 },
+}
+
+// contempt in centipawns set by the Contempt option
+var Contempt int32 = 0
+
+// true if Contempt was set by the user, otherwise the variant default is used
+var ContemptIsSet = false
+
+// scale contempt by game phase
+var DynamicContempt = false
+
 const (
 	KnownWinScore  int32 = 25000000       // KnownWinScore is strictly greater than all evaluation scores (mate not included).
 	KnownLossScore int32 = -KnownWinScore // KnownLossScore is strictly smaller than all evaluation scores (mated not included).
@@ -76,11 +133,35 @@ const (
 	InfinityScore  int32 = 32000000       // InfinityScore is possible score. -InfinityScore is the minimum possible score.
 )
 
+// enumeration of evaluation caches
+const(
+	EvalCachePawnsAndShelter = iota
+	EvalCacheHorde
+	EvalCachePosition
+	EvalCacheMaterial
+	EvalCacheCount
+)
+
+// names of evaluation caches
+var EVAL_CACHE_NAMES = [EvalCacheCount]string{
+	"pawns and shelter",
+	"horde",
+	"position",
+	"material",
+}
+
+// CacheStats counts the lookups of a cache
+type CacheStats struct {
+	Hit  uint64 // number of entries found
+	Miss uint64 // number of entries computed
+}
+
 // cache implements a fixed size cache
 type cache struct {
 	table []cacheEntry
+	stats *CacheStats // lookup counters
 	hash  func(*Position, Color) uint64
-	comp  func(*Position, Color) Eval
+	comp  func(*Position, Color, *EvalBreakdown) Eval
 }
 
 // cacheEntry is a cache entry
@@ -89,6 +170,53 @@ type cacheEntry struct {
 	eval Eval
 }
 
+// positionCache caches the evaluation of whole positions keyed by Zobrist
+type positionCache struct {
+	table []positionCacheEntry
+	stats *CacheStats // lookup counters
+}
+
+// positionCacheEntry is a position cache entry
+type positionCacheEntry struct {
+	lock  uint64
+	score int32
+}
+
+// materialCache caches the knowledge of material configurations keyed by material signature
+type materialCache struct {
+	table []materialCacheEntry
+	stats *CacheStats // lookup counters
+}
+
+// materialCacheEntry is a material cache entry
+type materialCacheEntry struct {
+	lock uint64
+	info materialInfo
+}
+
+// evalCaches holds the evaluation caches and their lookup counters
+// the caches are not safe for parallel use, engines searching in parallel have their own
+type evalCaches struct {
+	pawnsAndShelter *cache
+	horde           *cache
+	position        *positionCache
+	material        *materialCache
+	stats           [EvalCacheCount]CacheStats // lookups since the last reset
+	generation      uint64                     // evalCacheGeneration the entries were computed in
+}
+
+// materialInfo is the knowledge of a material configuration
+type materialInfo struct {
+	scale   [ColorArraySize]int32 // scaling factors by side
+	endgame endgameEntry          // endgame module, nil module if none
+}
+
+// non pawn material in pawn units indexed by figure, used by the material scaling
+var MATERIAL_POINTS = [FigureArraySize]int32{0, 1, 3, 3, 5, 9, 0}
+
+// full scale of the material scaling factors, the evaluation of the winning side is multiplied by scale / MATERIAL_SCALE_FULL
+const MATERIAL_SCALE_FULL int32 = 128
+
 var (
 	// weights stores all evaluation parameters under one array for easy handling
 	// Zurichess' evaluation is a very simple neural network with no hidden layers,
@@ -137,20 +265,72 @@ var (
 	wRookOnOpenFile     Score
 	wRookOnHalfOpenFile Score
 
-	// evaluation caches
-	pawnsAndShelterCache *cache
+	// evaluation caches of the engines without their own
+	globalEvalCaches *evalCaches
+
+	// incremented when the cached evaluations become stale, private caches are cleared
+	// by their engine when they see a new generation since it may be searching
+	evalCacheGeneration uint64
 )
 
-const (
+// search knobs, variables so that they can be tuned through options
+var (
 	CheckDepthExtension    int32 = 1 // how much to extend search in case of checks
 	NullMoveDepthLimit     int32 = 1 // disable null-move below this limit
 	NullMoveDepthReduction int32 = 1 // default null-move depth reduction, can reduce more in some situations
+	NullMoveExtraPieces    int32 = 3 // reduce null-move one more with at least this many minor/major pieces
 	PVSDepthLimit          int32 = 0 // do not do PVS below and including this limit
 	LMRDepthLimit          int32 = 3 // do not do LMR below and including this limit
+	LMRQuietDivisor        int32 = 5 // quiet moves are reduced by 1 + min(depth, numQuiet) / LMRQuietDivisor
+	LMRBadCapture          int32 = 1 // reduction of bad captures (SEE<0)
 	FutilityDepthLimit     int32 = 3 // maximum depth to do futility pruning
+	FutilityMargin         int32 = 150 // ~one and a halfpawn
+	HistoryPruneRatio      int32 = 16 // prune moves close to the frontier failing this many times more than succeeding
+
+	SingularDepthLimit     int32 = 8 // do not try singular extension below this limit
+	SingularHashDepth      int32 = 3 // hash entry may be at most this much shallower than the current depth
+	SingularMargin         int32 = 2 // margin per depth the other moves must fail below the hash score
+	SingularExtension      int32 = 1 // how much to extend a singular hash move
+	AtomicThreatExtension  int32 = 1 // how much to extend moves threatening a king exploding capture in Atomic
+	RKAdvanceExtension     int32 = 1 // how much to extend king advances in Racing Kings
+	RKAdvanceRank          int32 = 4 // extend king advances only to this rank (0 based, own POV) or beyond
+	MaxMoveExtension       int32 = 1 // maximum total extension of a single move
+	ExtensionPlyPercent    int32 = 100 // variant extensions only up to this percent of the root depth in plies
+)
+
+// SearchKnob : search parameter exposed as an option
+type SearchKnob struct {
+	Name  string
+	Value *int32
+	Min   int32
+	Max   int32
+}
+
+// list of tunable search parameters
+var SEARCH_KNOBS = []SearchKnob{
+	{"CheckDepthExtension", &CheckDepthExtension, 0, 2},
+	{"NullMoveDepthLimit", &NullMoveDepthLimit, 0, 10},
+	{"NullMoveDepthReduction", &NullMoveDepthReduction, 0, 5},
+	{"NullMoveExtraPieces", &NullMoveExtraPieces, 0, 16},
+	{"LMRDepthLimit", &LMRDepthLimit, 0, 20},
+	{"LMRQuietDivisor", &LMRQuietDivisor, 1, 20},
+	{"LMRBadCapture", &LMRBadCapture, 0, 5},
+	{"FutilityDepthLimit", &FutilityDepthLimit, 0, 10},
+	{"FutilityMargin", &FutilityMargin, 0, 1000},
+	{"HistoryPruneRatio", &HistoryPruneRatio, 1, 1000},
+	{"SingularDepthLimit", &SingularDepthLimit, 1, 64},
+	{"SingularHashDepth", &SingularHashDepth, 0, 10},
+	{"SingularMargin", &SingularMargin, 0, 100},
+	{"SingularExtension", &SingularExtension, 0, 2},
+	{"AtomicThreatExtension", &AtomicThreatExtension, 0, 2},
+	{"RKAdvanceExtension", &RKAdvanceExtension, 0, 2},
+	{"RKAdvanceRank", &RKAdvanceRank, 0, 7},
+	{"MaxMoveExtension", &MaxMoveExtension, 0, 3},
+	{"ExtensionPlyPercent", &ExtensionPlyPercent, 0, 400},
+}
 
+const (
 	initialAspirationWindow = 21  // ~a quarter of a pawn
-	futilityMargin          = 150 // ~one and a halfpawn
 	checkpointStep          = 10000
 )
 
@@ -163,16 +343,19 @@ var (
 // Options keeps engine's options
 type Options struct {
 	AnalyseMode bool // true to display info strings
+	Private     bool // own hash table and no reporting, so that engines can search in parallel
 }
 
 // stats stores some basic stats of the search
 // statistics are reset every iteration of the iterative deepening search
 type Stats struct {
-	CacheHit  uint64 // number of times the position was found transposition table
-	CacheMiss uint64 // number of times the position was not found in the transposition table
-	Nodes     uint64 // number of nodes searched
-	Depth     int32  // depth search
-	SelDepth  int32  // maximum depth reached on PV (doesn't include the hash moves)
+	CacheHit  uint64                     // number of times the position was found transposition table
+	CacheMiss uint64                     // number of times the position was not found in the transposition table
+	Nodes     uint64                     // number of nodes searched
+	Depth     int32                      // depth search
+	SelDepth  int32                      // maximum depth reached on PV (doesn't include the hash moves)
+	TBHits    uint64                     // number of positions found in the tablebases
+	EvalCache [EvalCacheCount]CacheStats // lookups of the evaluation caches
 }
 
 // CacheHitRatio returns the ration of hits over total number of lookups
@@ -180,6 +363,19 @@ func (s *Stats) CacheHitRatio() float32 {
 	return float32(s.CacheHit) / float32(s.CacheHit+s.CacheMiss)
 }
 
+// EvalCacheHitRatio returns the ratio of hits over total number of lookups of an evaluation cache
+func (s *Stats) EvalCacheHitRatio(kind int) float32 {
+	return s.EvalCache[kind].HitRatio()
+}
+
+// HitRatio returns the ratio of hits over total number of lookups
+func (c CacheStats) HitRatio() float32 {
+	if c.Hit+c.Miss == 0 {
+		return 0
+	}
+	return float32(c.Hit) / float32(c.Hit+c.Miss)
+}
+
 // Logger logs search progress
 type Logger interface {
 	// BeginSearch signals a new search is started
@@ -273,6 +469,7 @@ type TimeControl struct {
 	WTime, WInc time.Duration // time and increment for white
 	BTime, BInc time.Duration // time and increment for black
 	Depth       int32         // maximum depth search (including)
+	Nodes       uint64        // maximum number of nodes searched, 0 for no limit
 	MovesToGo   int           // number of remaining moves
 
 	sideToMove Color
@@ -305,6 +502,17 @@ type Engine struct {
 	timeControl *TimeControl
 	stopped     bool
 	checkpoint  uint64
+
+	rootSide Color // side to move at the start of the search
+	contempt int32 // contempt of the current search from rootSide's POV
+
+	excluded Move // move excluded by the singular extension search of the next node
+
+	LastScore   int32      // score of the principal variation of the last completed depth
+	searchDepth int32      // depth of the current iteration
+	pvIndex     int        // index of the multipv line being searched, starting from 1
+	hashTable   *HashTable // private transposition table, nil for GlobalHashTable
+	evalCaches  *evalCaches // private evaluation caches, nil for globalEvalCaches
 }
 
 const (
@@ -312,7 +520,8 @@ const (
 	pvTableMask = pvTableSize - 1
 )
 
-const disableCache = false
+// disables the evaluation caches, the tuner evaluates in parallel and changes the weights
+var disableCache = false
 
 // Score represents a pair of mid and end game scores.
 type Score struct {
@@ -326,6 +535,7 @@ type Eval struct {
 
 var (
 	DefaultHashTableSizeMB = 64       // DefaultHashTableSizeMB is the default size in MB
+	PrivateHashTableSizeMB = 16       // PrivateHashTableSizeMB is the size in MB of the table of a private engine
 	GlobalHashTable        *HashTable // GlobalHashTable is the global transposition table
 )
 
@@ -412,37 +622,8 @@ func init() {
 	GlobalHashTable = NewHashTable(DefaultHashTableSizeMB)
 
 	// initialize caches
-	pawnsAndShelterCache = newCache(9, hashPawnsAndShelter, evaluatePawnsAndShelter)
+	globalEvalCaches = newEvalCaches()
 	initWeights()
-
-	slice := func(w []Score, out []Score) []Score {
-		copy(out, w)
-		return w[len(out):]
-	}
-	entry := func(w []Score, out *Score) []Score {
-		*out = w[0]
-		return w[1:]
-	}
-
-	w := Weights[:]
-	w = slice(w, wFigure[:])
-	w = slice(w, wMobility[:])
-	w = slice(w, wPawn[:])
-	w = slice(w, wPassedPawn[:])
-	w = slice(w, wKingRank[:])
-	w = slice(w, wKingFile[:])
-	w = entry(w, &wConnectedPawn)
-	w = entry(w, &wDoublePawn)
-	w = entry(w, &wIsolatedPawn)
-	w = entry(w, &wPawnThreat)
-	w = entry(w, &wKingShelter)
-	w = entry(w, &wBishopPair)
-	w = entry(w, &wRookOnOpenFile)
-	w = entry(w, &wRookOnHalfOpenFile)
-
-	if len(w) != 0 {
-		panic(fmt.Sprintf("not all weights used, left with %d out of %d", len(w), len(Weights)))
-	}
 }
 
 ///////////////////////////////////////////////
@@ -491,9 +672,10 @@ func hashPawnsAndShelter(pos *Position, us Color) uint64 {
 // evaluatePawns : evaluate pawns
 // -> pos *Position : position
 // -> us Color : color
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
 // <- Eval : eval
 
-func evaluatePawns(pos *Position, us Color) Eval {
+func evaluatePawns(pos *Position, us Color, trace *EvalBreakdown) Eval {
 	var eval Eval
 	ours := pos.ByPiece(us, Pawn)
 	theirs := pos.ByPiece(us.Opposite(), Pawn)
@@ -528,20 +710,20 @@ func evaluatePawns(pos *Position, us Color) Eval {
 		povSq := sq.POV(us)
 		rank := povSq.Rank()
 
-		eval.Add(wFigure[Pawn])
-		eval.Add(wPawn[povSq-8])
+		eval.AddTerm(TermMaterial, us, wFigure[Pawn], trace)
+		eval.AddTerm(TermPawnSquare, us, wPawn[povSq-8], trace)
 
 		if passed.Has(sq) {
-			eval.Add(wPassedPawn[rank])
+			eval.AddTerm(TermPassedPawn, us, wPassedPawn[rank], trace)
 		}
 		if connected.Has(sq) {
-			eval.Add(wConnectedPawn)
+			eval.AddTerm(TermConnectedPawn, us, wConnectedPawn, trace)
 		}
 		if double.Has(sq) {
-			eval.Add(wDoublePawn)
+			eval.AddTerm(TermDoublePawn, us, wDoublePawn, trace)
 		}
 		if isolated.Has(sq) {
-			eval.Add(wIsolatedPawn)
+			eval.AddTerm(TermIsolatedPawn, us, wIsolatedPawn, trace)
 		}
 	}
 
@@ -554,28 +736,29 @@ func evaluatePawns(pos *Position, us Color) Eval {
 // evaluateShelter : evaluate shelter
 // -> pos *Position : position
 // -> us Color : color
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
 // <- Eval : eval
 
-func evaluateShelter(pos *Position, us Color) Eval {
+func evaluateShelter(pos *Position, us Color, trace *EvalBreakdown) Eval {
 	var eval Eval
 	pawns := pos.ByPiece(us, Pawn)
 	king := pos.ByPiece(us, King)
 
 	sq := king.AsSquare().POV(us)
-	eval.Add(wKingFile[sq.File()])
-	eval.Add(wKingRank[sq.Rank()])
+	eval.AddTerm(TermKingPosition, us, wKingFile[sq.File()], trace)
+	eval.AddTerm(TermKingPosition, us, wKingRank[sq.Rank()], trace)
 
 	if pos.ByPiece(us.Opposite(), Queen) != 0 {
 		king = ForwardSpan(us, king)
 		file := sq.File()
 		if file > 0 && West(king)&pawns == 0 {
-			eval.Add(wKingShelter)
+			eval.AddTerm(TermKingShelter, us, wKingShelter, trace)
 		}
 		if king&pawns == 0 {
-			eval.AddN(wKingShelter, 2)
+			eval.AddNTerm(TermKingShelter, us, wKingShelter, 2, trace)
 		}
 		if file < 7 && East(king)&pawns == 0 {
-			eval.Add(wKingShelter)
+			eval.AddTerm(TermKingShelter, us, wKingShelter, trace)
 		}
 	}
 	return eval
@@ -587,12 +770,85 @@ func evaluateShelter(pos *Position, us Color) Eval {
 // evaluatePawnsAndShelter : evaluate pawn and shelter
 // -> pos *Position : position
 // -> us Color : color
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
 // <- Eval : eval
 
-func evaluatePawnsAndShelter(pos *Position, us Color) Eval {
+func evaluatePawnsAndShelter(pos *Position, us Color, trace *EvalBreakdown) Eval {
 	var eval Eval
-	eval.Merge(evaluatePawns(pos, us))
-	eval.Merge(evaluateShelter(pos, us))
+	eval.Merge(evaluatePawns(pos, us, trace))
+	eval.Merge(evaluateShelter(pos, us, trace))
+	return eval
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// hashHorde : hashes pawns and king for the horde evaluation
+// -> pos *Position : position
+// -> us Color : color
+// <- uint64 : h
+
+func hashHorde(pos *Position, us Color) uint64 {
+	h := murmurSeed[us]
+	h = murmurMix(h, uint64(pos.ByPiece(us, Pawn)))
+	h = murmurMix(h, uint64(pos.ByPiece(us.Opposite(), Pawn)))
+	h = murmurMix(h, uint64(pos.ByPiece(us, King)))
+	return h
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluateHorde : evaluate pawn structure and king danger in horde
+// the horde is rewarded for chains and passed pawns and penalized for holes
+// the piece side is penalized for horde pawns close to its king
+// -> pos *Position : position
+// -> us Color : color
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
+// <- Eval : eval
+
+func evaluateHorde(pos *Position, us Color, trace *EvalBreakdown) Eval {
+	var eval Eval
+	ours := pos.ByPiece(us, Pawn)
+	theirs := pos.ByPiece(us.Opposite(), Pawn)
+
+	// pawn material
+	for bb := ours; bb > 0; {
+		sq := bb.Pop()
+		eval.AddTerm(TermHordePawn, us, HORDE_PAWN_SCORES[us], trace)
+		eval.AddTerm(TermHordeCenter, us, HORDE_CENTER_BONUS.Multiply(HORDE_CENTER_BONUS_WEIGHTS[sq.File()]), trace)
+	}
+
+	if us != HORDE_Pawns_Side {
+		// horde pawns within two squares of the king
+		zone := pos.ByPiece(us, King)
+		for i := 0; i < 2; i++ {
+			zone |= East(zone) | West(zone)
+			zone |= North(zone) | South(zone)
+		}
+		eval.AddTerm(TermHordeKingDanger, us, HORDE_KING_DANGER_SCORE.Multiply((zone & theirs).Count()), trace)
+		return eval
+	}
+
+	// add balance for pawns
+	eval.AddTerm(TermHordeBalance, us, HORDE_BALANCE_SCORE, trace)
+
+	attacks := East(Forward(us, ours)) | West(Forward(us, ours))
+	chain := ours & attacks
+	eval.AddTerm(TermHordeChain, us, HORDE_CHAIN_SCORE.Multiply(chain.Count()), trace)
+
+	// no pawn in front and no enemy pawn on the adjacent files
+	block := BackwardSpan(us, East(theirs) | theirs | West(theirs)) | BackwardSpan(us, ours)
+	for bb := ours &^ block; bb > 0; {
+		sq := bb.Pop()
+		eval.AddTerm(TermHordePassedPawn, us, HORDE_PASSED_PAWN_SCORE.Multiply(int32(sq.POV(us).Rank())), trace)
+	}
+
+	// squares behind the pawns which no pawn can attack, now or after advancing
+	span := ours | ForwardSpan(us, ours)
+	holes := BackwardSpan(us, ours) &^ ours &^ ( East(span) | West(span) )
+	eval.AddTerm(TermHordeHole, us, HORDE_HOLE_SCORE.Multiply(holes.Count()), trace)
+
 	return eval
 }
 
@@ -821,16 +1077,82 @@ func passed(pos *Position, m Move) bool {
 func isFutile(pos *Position, static, α, margin int32, m Move) bool {
 	if m.MoveType() == Promotion {
 		// promotion and passed pawns can increase static evaluation
-		// by more than futilityMargin
+		// by more than FutilityMargin
 		return false
 	}
 	f := m.Capture().Figure()
 	δ := ScaleToCentiPawn(max(wFigure[f].M, wFigure[f].E))
+	if IS_Atomic && m.Capture() != NoPiece {
+		// the capture gains the exploded material, or wins if the king explodes
+		δ = seeAtomic(pos, m)
+	}
 	return static+δ+margin < α && !passed(pos, m)
 }
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// ExplosionThreats : returns the pieces next to the opponent king that side can capture
+// captures next to side's own king are excluded as they would explode it too
+// and so are pieces only the king attacks, kings cannot capture in Atomic
+// -> pos *Position : position
+// -> side Color : side
+// <- Bitboard : threatened pieces
+
+func (pos *Position) ExplosionThreats(side Color) Bitboard {
+	them := side.Opposite()
+	if pos.IsExploded(them) || pos.IsExploded(side) {
+		return 0
+	}
+	kingSq := pos.GetKingBitboard(them).AsSquare()
+	ownKingSq := pos.GetKingBitboard(side).AsSquare()
+	candidates := explosionbitboards[kingSq] & pos.ByColor[them] &^ explosionbitboards[ownKingSq]
+	threats := Bitboard(0)
+	for bb := candidates; bb != 0; {
+		sq := bb.Pop()
+		if fig := pos.GetAttacker(sq, side); ( fig != NoFigure ) && ( fig != King ) {
+			threats |= sq.Bitboard()
+		}
+	}
+	return threats
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// SafeKingSquares : returns the squares where side's king is safe from explosions
+// a square is unsafe if it is attacked or next to a piece of side the opponent can capture
+// -> pos *Position : position
+// -> side Color : side
+// <- Bitboard : safe squares
+
+func (pos *Position) SafeKingSquares(side Color) Bitboard {
+	attacked := pos.AttackedSquares(side.Opposite())
+	unsafe := attacked | pos.ByColor[side]
+	for bb := pos.ByColor[side] &^ pos.ByPiece(side, King) & attacked; bb != 0; {
+		unsafe |= explosionbitboards[bb.Pop()]
+	}
+	return ^unsafe
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// isKingAdvance : returns true if the move advances the king towards the goal in Racing Kings
+// only advances reaching at least RKAdvanceRank count
+// -> m Move : move
+// <- bool : true if king advance
+
+func isKingAdvance(m Move) bool {
+	if m.Piece().Figure() != King {
+		return false
+	}
+	to := m.To().Rank()
+	return to > m.From().Rank() && to >= int(RKAdvanceRank)
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // SetPosition : sets current position
 // if pos is nil, the starting position is set
@@ -865,12 +1187,45 @@ func NewEngine(pos *Position, log Logger, options Options) *Engine {
 		pvTable: newPvTable(),
 		history: newHistoryTable(),
 	}
+	if options.Private {
+		eng.hashTable = NewHashTable(PrivateHashTableSizeMB)
+		eng.evalCaches = newEvalCaches()
+	}
 	eng.SetPosition(pos)
 	return eng
 }
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// hash : returns the transposition table of the engine
+// -> eng *Engine : engine
+// <- *HashTable : transposition table
+
+func (eng *Engine) hash() *HashTable {
+	if eng.hashTable != nil {
+		return eng.hashTable
+	}
+	return GlobalHashTable
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// caches : returns the evaluation caches of the engine
+// -> eng *Engine : engine
+// <- *evalCaches : evaluation caches
+
+func (eng *Engine) caches() *evalCaches {
+	if eng.evalCaches != nil {
+		eng.evalCaches.refresh()
+		return eng.evalCaches
+	}
+	return globalEvalCaches
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // FigureNameToFigure : figure name to figure
 // -> figureString string : figure name as string
@@ -924,6 +1279,8 @@ func SetVariantFlags() {
 	if Variant == VARIANT_Horde {
 		IS_Horde = true
 	}
+	// cached evaluations belong to the previous variant
+	clearEvalCaches()
 }
 
 ///////////////////////////////////////////////
@@ -971,27 +1328,105 @@ func (eng *Engine) UndoMove() {
 // EvaluateSideRk : evaluate side for Racing Kings
 // -> pos *Position : position
 // -> side Color : side
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
 // <- int32 : eval
 
-func EvaluateSideRk(pos *Position, side Color) int32 {
+func EvaluateSideRk(pos *Position, side Color, trace *EvalBreakdown) int32 {
 	var val int32 = 0
 	// piece values
 	for piece := Knight ; piece < King ; piece++ {
 		num := pos.ByPiece(side, piece).Count()
-		val += num * RK_PIECE_VALUES[piece]
+		val += trace.rk(TermRkMaterial, side, num * RK_PIECE_VALUES[piece])
 	}
 	// king advance value
-	val += int32(pos.ByPiece(side, King).AsSquare().Rank())*KING_ADVANCE_VALUE
+	val += trace.rk(TermRkKingAdvance, side, int32(pos.ByPiece(side, King).AsSquare().Rank())*KING_ADVANCE_VALUE)
 	// knight advance value
 	for bb := pos.ByPiece(side, Knight); bb > 0; {
 		sq := bb.Pop()
-		val += int32(sq.Rank())*KNIGHT_ADVANCE_VALUE
+		val += trace.rk(TermRkKnightAdvance, side, int32(sq.Rank())*KNIGHT_ADVANCE_VALUE)
 	}
 	return val
 }
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// RaceDistanceRk : returns the number of king moves side needs to reach the 8th rank
+// the king only steps on squares not attacked by the opponent
+// own pieces are assumed to step aside
+// -> pos *Position : position
+// -> side Color : side
+// -> attacked Bitboard : squares attacked by the opponent
+// <- int32 : distance, RK_RACE_UNREACHABLE if there is no path
+
+func RaceDistanceRk(pos *Position, side Color, attacked Bitboard) int32 {
+	frontier := pos.ByPiece(side, King)
+	visited := frontier
+	for dist := int32(0); frontier != 0; dist++ {
+		if frontier&BbRank8 != 0 {
+			return dist
+		}
+		next := Bitboard(0)
+		for bb := frontier; bb != 0; {
+			next |= KingMobility(bb.Pop())
+		}
+		frontier = next &^ attacked &^ visited
+		visited |= frontier
+	}
+	return RK_RACE_UNREACHABLE
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// EvaluateRaceRk : evaluates the king race for Racing Kings from White's POV
+// the race is counted in plies with the side to move one ply ahead
+// White arriving first only wins if Black cannot arrive on the next ply
+// -> pos *Position : position
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
+// <- int32 : eval
+
+func EvaluateRaceRk(pos *Position, trace *EvalBreakdown) int32 {
+	var dist, arrival, control [ColorArraySize]int32
+	var attacks [ColorArraySize]Bitboard
+	for _, side := range []Color{White, Black} {
+		attacks[side] = pos.AttackedSquares(side)
+	}
+	for _, side := range []Color{White, Black} {
+		them := side.Opposite()
+		dist[side] = RaceDistanceRk(pos, side, attacks[them])
+		arrival[side] = 2*dist[side]
+		if side == pos.SideToMove {
+			arrival[side]--
+		}
+		// squares in front of the opponent king
+		king := pos.ByPiece(them, King)
+		forward := KingMobility(king.AsSquare()) & NorthSpan(king)
+		control[side] = ( forward & attacks[side] ).Count()
+	}
+
+	winner := NoColor
+	if ( dist[White] < RK_RACE_UNREACHABLE ) || ( dist[Black] < RK_RACE_UNREACHABLE ) {
+		if arrival[Black] < arrival[White] {
+			winner = Black
+		} else if arrival[White]+1 < arrival[Black] {
+			winner = White
+		}
+	}
+
+	var val [ColorArraySize]int32
+	for _, side := range []Color{White, Black} {
+		val[side] += trace.rk(TermRkRace, side, ( RK_RACE_UNREACHABLE - dist[side] )*RK_RACE_DISTANCE_VALUE)
+		if side == winner {
+			val[side] += trace.rk(TermRkTempo, side, RK_RACE_TEMPO_VALUE)
+		}
+		val[side] += trace.rk(TermRkControl, side, control[side]*RK_FORWARD_CONTROL_VALUE)
+	}
+	return val[White] - val[Black]
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // Feed : eval feed
 // -> e *Eval : eval
@@ -1049,6 +1484,42 @@ func (e *Eval) AddN(s Score, n int32) {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// AddTerm : add score to eval and record it as term of side us when tracing
+// -> e *Eval : eval
+// -> term int : evaluation term
+// -> us Color : side
+// -> s Score : score to be added
+// -> trace *EvalBreakdown : breakdown the term is recorded in, nil if not tracing
+
+func (e *Eval) AddTerm(term int, us Color, s Score, trace *EvalBreakdown) {
+	e.Add(s)
+	if trace != nil {
+		trace.add(term, us, s.M, s.E)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// AddNTerm : add score to eval n times and record it as term of side us when tracing
+// -> e *Eval : eval
+// -> term int : evaluation term
+// -> us Color : side
+// -> s Score : score to be added
+// -> n int32 : times score to be added
+// -> trace *EvalBreakdown : breakdown the term is recorded in, nil if not tracing
+
+func (e *Eval) AddNTerm(term int, us Color, s Score, n int32, trace *EvalBreakdown) {
+	before := *e
+	e.AddN(s, n)
+	if trace != nil {
+		trace.add(term, us, e.M-before.M, e.E-before.E)
+	}
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // Neg : negate eval
 // -> e *Eval : eval
@@ -1062,75 +1533,311 @@ func (e *Eval) Neg() {
 
 ///////////////////////////////////////////////
 // initWeights : init weights
+// sets the named chunks from Weights, has to be called after Weights change
 
 func initWeights() {
+	slice := func(w []Score, out []Score) []Score {
+		copy(out, w)
+		return w[len(out):]
+	}
+	entry := func(w []Score, out *Score) []Score {
+		*out = w[0]
+		return w[1:]
+	}
+
+	w := Weights[:]
+	w = slice(w, wFigure[:])
+	w = slice(w, wMobility[:])
+	w = slice(w, wPawn[:])
+	w = slice(w, wPassedPawn[:])
+	w = slice(w, wKingRank[:])
+	w = slice(w, wKingFile[:])
+	w = entry(w, &wConnectedPawn)
+	w = entry(w, &wDoublePawn)
+	w = entry(w, &wIsolatedPawn)
+	w = entry(w, &wPawnThreat)
+	w = entry(w, &wKingShelter)
+	w = entry(w, &wBishopPair)
+	w = entry(w, &wRookOnOpenFile)
+	w = entry(w, &wRookOnHalfOpenFile)
+
+	if len(w) != 0 {
+		panic(fmt.Sprintf("not all weights used, left with %d out of %d", len(w), len(Weights)))
+	}
 }
 
 
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// newEvalCaches : creates the evaluation caches
+// <- *evalCaches : evaluation caches
+
+func newEvalCaches() *evalCaches {
+	ec := &evalCaches{}
+	ec.pawnsAndShelter = newCache(9, &ec.stats[EvalCachePawnsAndShelter], hashPawnsAndShelter, evaluatePawnsAndShelter)
+	ec.horde = newCache(9, &ec.stats[EvalCacheHorde], hashHorde, evaluateHorde)
+	ec.position = newPositionCache(14, &ec.stats[EvalCachePosition])
+	ec.material = newMaterialCache(8, &ec.stats[EvalCacheMaterial])
+	ec.generation = atomic.LoadUint64(&evalCacheGeneration)
+	return ec
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// clear : removes all entries from the evaluation caches
+// -> ec *evalCaches : evaluation caches
+
+func (ec *evalCaches) clear() {
+	ec.pawnsAndShelter.clear()
+	ec.horde.clear()
+	ec.position.clear()
+	ec.material.clear()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// refresh : clears the evaluation caches if they were filled before the last clearEvalCaches
+// -> ec *evalCaches : evaluation caches
+
+func (ec *evalCaches) refresh() {
+	if generation := atomic.LoadUint64(&evalCacheGeneration); ec.generation != generation {
+		ec.clear()
+		ec.generation = generation
+	}
+}
+
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
 // newCache : creates a new cache of size 1<<bits
 // -> bits uint : bits
+// -> stats *CacheStats : lookup counters
 // -> hash func(*Position, Color) uint64 : hash func
-// -> comp func(*Position, Color) Eval : comp func
+// -> comp func(*Position, Color, *EvalBreakdown) Eval : comp func
 // <- *cache : cache
 
-func newCache(bits uint, hash func(*Position, Color) uint64, comp func(*Position, Color) Eval) *cache {
-	return &cache{
-		table: make([]cacheEntry, 1<<bits),
-		hash:  hash,
-		comp:  comp,
-	}
+func newCache(bits uint, stats *CacheStats, hash func(*Position, Color) uint64, comp func(*Position, Color, *EvalBreakdown) Eval) *cache {
+	return &cache{
+		table: make([]cacheEntry, 1<<bits),
+		stats: stats,
+		hash:  hash,
+		comp:  comp,
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// put : puts a new entry in the cache
+// -> c *cache : cache
+// -> lock uint64 : lock
+// -> eval Eval : eval
+
+func (c *cache) put(lock uint64, eval Eval) {
+	indx := lock & uint64(len(c.table)-1)
+	c.table[indx] = cacheEntry{lock: lock, eval: eval}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// get : gets an entry from the cache
+// -> c *cache : cache
+// -> lock uint64 : lock
+// <- Eval : eval
+// <- bool : ok
+
+func (c *cache) get(lock uint64) (Eval, bool) {
+	indx := lock & uint64(len(c.table)-1)
+	return c.table[indx].eval, c.table[indx].lock == lock
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// clear : removes all entries from the cache
+// -> c *cache : cache
+
+func (c *cache) clear() {
+	for i := range c.table {
+		c.table[i] = cacheEntry{}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// clearEvalCaches : clears all evaluation caches
+// needed when the evaluation parameters change
+// the private caches of the engines are cleared by their engines on their next evaluation
+
+func clearEvalCaches() {
+	globalEvalCaches.clear()
+	atomic.AddUint64(&evalCacheGeneration, 1)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// setEvalParam : sets an evaluation parameter, the cached evaluations are stale if its value changes
+// -> param *int32 : parameter
+// -> value int32 : value
+
+func setEvalParam(param *int32, value int32) {
+	if *param != value {
+		*param = value
+		clearEvalCaches()
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// resetEvalCacheStats : resets the lookup counters of the evaluation caches
+
+func resetEvalCacheStats() {
+	globalEvalCaches.stats = [EvalCacheCount]CacheStats{}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// load : evaluates position, using the cache if possible
+// the cache is bypassed when tracing so that every term is recorded
+// -> c *cache : cache
+// -> pos *Position : position
+// -> us Color : side
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
+// <- Eval : eval
+
+func (c *cache) load(pos *Position, us Color, trace *EvalBreakdown) Eval {
+	if disableCache || ( trace != nil ) {
+		return c.comp(pos, us, trace)
+	}
+	h := c.hash(pos, us)
+	if e, ok := c.get(h); ok {
+		c.stats.Hit++
+		return e
+	}
+	c.stats.Miss++
+	e := c.comp(pos, us, nil)
+	c.put(h, e)
+	return e
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// newPositionCache : creates a new position cache of size 1<<bits
+// -> bits uint : bits
+// -> stats *CacheStats : lookup counters
+// <- *positionCache : cache
+
+func newPositionCache(bits uint, stats *CacheStats) *positionCache {
+	return &positionCache{table: make([]positionCacheEntry, 1<<bits), stats: stats}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// load : evaluates position, using the cache if possible
+// -> c *positionCache : cache
+// -> pos *Position : position
+// -> comp func(*Position, *EvalBreakdown) int32 : evaluation
+// <- int32 : score
+
+func (c *positionCache) load(pos *Position, comp func(*Position, *EvalBreakdown) int32) int32 {
+	if disableCache {
+		return comp(pos, nil)
+	}
+	lock := pos.Zobrist()
+	entry := &c.table[lock&uint64(len(c.table)-1)]
+	if entry.lock == lock {
+		c.stats.Hit++
+		return entry.score
+	}
+	c.stats.Miss++
+	score := comp(pos, nil)
+	*entry = positionCacheEntry{lock: lock, score: score}
+	return score
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// clear : removes all entries from the cache
+// -> c *positionCache : cache
+
+func (c *positionCache) clear() {
+	for i := range c.table {
+		c.table[i] = positionCacheEntry{}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// newMaterialCache : creates a new material cache of size 1<<bits
+// -> bits uint : bits
+// -> stats *CacheStats : lookup counters
+// <- *materialCache : cache
+
+func newMaterialCache(bits uint, stats *CacheStats) *materialCache {
+	return &materialCache{table: make([]materialCacheEntry, 1<<bits), stats: stats}
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// put : puts a new entry in the cache
-// -> c *cache : cache
-// -> lock uint64 : lock
-// -> eval Eval : eval
+// load : returns the knowledge of the material configuration, using the cache if possible
+// the signature is exact, so it is its own lock
+// -> c *materialCache : cache
+// -> pos *Position : position
+// <- materialInfo : knowledge
 
-func (c *cache) put(lock uint64, eval Eval) {
-	indx := lock & uint64(len(c.table)-1)
-	c.table[indx] = cacheEntry{lock: lock, eval: eval}
+func (c *materialCache) load(pos *Position) materialInfo {
+	sig := pos.MaterialSignature()
+	if disableCache {
+		return newMaterialInfo(sig)
+	}
+	// murmur mixing spreads the signature over the table, 0 marks an empty entry
+	entry := &c.table[murmurMix(sig, murmurSeed[NoColor])&uint64(len(c.table)-1)]
+	if ( entry.lock == sig+1 ) {
+		c.stats.Hit++
+		return entry.info
+	}
+	c.stats.Miss++
+	info := newMaterialInfo(sig)
+	*entry = materialCacheEntry{lock: sig+1, info: info}
+	return info
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// get : gets an entry from the cache
-// -> c *cache : cache
-// -> lock uint64 : lock
-// <- Eval : eval
-// <- bool : ok
+// newMaterialInfo : computes the knowledge of a material configuration
+// -> sig uint64 : material signature
+// <- materialInfo : knowledge
 
-func (c *cache) get(lock uint64) (Eval, bool) {
-	indx := lock & uint64(len(c.table)-1)
-	return c.table[indx].eval, c.table[indx].lock == lock
+func newMaterialInfo(sig uint64) materialInfo {
+	info := materialInfo{scale: materialScale(sig)}
+	info.endgame, _ = LookupEndgame(sig)
+	return info
 }
 
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// load : evaluates position, using the cache if possible
-// -> c *cache : cache
-// -> pos *Position : position
-// -> us Color : side
-// <- Eval : eval
+// clear : removes all entries from the cache
+// -> c *materialCache : cache
 
-func (c *cache) load(pos *Position, us Color) Eval {
-	if disableCache {
-		return c.comp(pos, us)
-	}
-	h := c.hash(pos, us)
-	if e, ok := c.get(h); ok {
-		return e
+func (c *materialCache) clear() {
+	for i := range c.table {
+		c.table[i] = materialCacheEntry{}
 	}
-	e := c.comp(pos, us)
-	c.put(h, e)
-	return e
 }
 
 
@@ -1162,99 +1869,107 @@ func (score Score) Multiply(n int32) Score {
 
 ///////////////////////////////////////////////
 // evaluateSide : evaluates position for a single side
+// -> ec *evalCaches : evaluation caches
 // -> pos *Position : position
 // -> us Color : us
 // -> eval *Eval : eval
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
 
-func evaluateSide(pos *Position, us Color, eval *Eval) {
+func (ec *evalCaches) evaluateSide(pos *Position, us Color, eval *Eval, trace *EvalBreakdown) {
 	if !IS_Horde {
 		// in horde ignore this and use simply the pawn material
-		eval.Merge(pawnsAndShelterCache.load(pos, us))
+		eval.Merge(ec.pawnsAndShelter.load(pos, us, trace))
 	} else {
-		// calculate pawn material for horde
-		for bb := pos.ByPiece(us, Pawn); bb > 0; {
-			sq := bb.Pop()
-			eval.Add(HORDE_PAWN_SCORES[us])
-			eval.Add(HORDE_CENTER_BONUS.Multiply(HORDE_CENTER_BONUS_WEIGHTS[sq.File()]))
-		}
-		if us == HORDE_Pawns_Side {
-			// add balance for pawns
-			eval.Add(HORDE_BALANCE_SCORE)
-		}
+		// calculate pawn material and structure for horde
+		eval.Merge(ec.horde.load(pos, us, trace))
 	}
 	all := pos.ByColor[White] | pos.ByColor[Black]
 	them := us.Opposite()
 
 	// Pawn
 	mobility := Forward(us, pos.ByPiece(us, Pawn)) &^ all
-	eval.AddN(wMobility[Pawn], mobility.Count())
+	eval.AddNTerm(TermMobility, us, wMobility[Pawn], mobility.Count(), trace)
 	mobility = pos.PawnThreats(us) & pos.ByColor[us.Opposite()]
-	eval.AddN(wPawnThreat, mobility.Count())
+	eval.AddNTerm(TermPawnThreat, us, wPawnThreat, mobility.Count(), trace)
 
 	if IS_Atomic {
 		// in atomic add bonus for pawns
 		for bb := pos.ByPiece(us, Pawn); bb > 0; {
 			bb.Pop()
-			eval.Add(ATOMIC_PAWN_BONUS_SCORE)
+			eval.AddTerm(TermAtomicPawn, us, ATOMIC_PAWN_BONUS_SCORE, trace)
 		}
 		for bb := pos.ByPiece(us, Queen); bb > 0; {
 			bb.Pop()
-			eval.Add(ATOMIC_QUEEN_BONUS_SCORE)
+			eval.AddTerm(TermAtomicQueen, us, ATOMIC_QUEEN_BONUS_SCORE, trace)
+		}
+		if pos.KingsAdjacent() {
+			// connected kings cannot be exploded, the defending side holds
+			if pos.ByColor[us].Count() < pos.ByColor[them].Count() {
+				eval.AddTerm(TermAtomicConnection, us, ATOMIC_KING_CONNECTION_SCORE, trace)
+			}
+		} else {
+			// add bonus for squares attacked around opponent king
+			eval.AddTerm(TermAtomicKingAttack, us, ATOMIC_KING_ATTACK_BONUS_SCORE.Multiply(int32(pos.NumKingAttackers(them))), trace)
+			eval.AddTerm(TermAtomicThreat, us, ATOMIC_EXPLOSION_THREAT_SCORE.Multiply(pos.ExplosionThreats(us).Count()), trace)
 		}
-		// add bonus for squares attacked around opponent king
-		eval.Add(ATOMIC_KING_ATTACK_BONUS_SCORE.Multiply(int32(pos.NumKingAttackers(them))))
 	}
 
 	// Knight
 	excl := pos.ByPiece(us, Pawn) | pos.PawnThreats(them)
 	for bb := pos.ByPiece(us, Knight); bb > 0; {
 		sq := bb.Pop()
-		eval.Add(wFigure[Knight])
+		eval.AddTerm(TermMaterial, us, wFigure[Knight], trace)
 		mobility := KnightMobility(sq) &^ excl
-		eval.AddN(wMobility[Knight], mobility.Count())
+		eval.AddNTerm(TermMobility, us, wMobility[Knight], mobility.Count(), trace)
 	}
 	// Bishop
 	numBishops := int32(0)
 	for bb := pos.ByPiece(us, Bishop); bb > 0; {
 		sq := bb.Pop()
-		eval.Add(wFigure[Bishop])
+		eval.AddTerm(TermMaterial, us, wFigure[Bishop], trace)
 		mobility := BishopMobility(sq, all) &^ excl
-		eval.AddN(wMobility[Bishop], mobility.Count())
+		eval.AddNTerm(TermMobility, us, wMobility[Bishop], mobility.Count(), trace)
 		numBishops++
 	}
-	eval.AddN(wBishopPair, numBishops/2)
+	eval.AddNTerm(TermBishopPair, us, wBishopPair, numBishops/2, trace)
 
 	// Rook
 	for bb := pos.ByPiece(us, Rook); bb > 0; {
 		sq := bb.Pop()
-		eval.Add(wFigure[Rook])
+		eval.AddTerm(TermMaterial, us, wFigure[Rook], trace)
 		mobility := RookMobility(sq, all) &^ excl
-		eval.AddN(wMobility[Rook], mobility.Count())
+		eval.AddNTerm(TermMobility, us, wMobility[Rook], mobility.Count(), trace)
 
 		// evaluate rook on open and semi open files
 		// https://chessprogramming.wikispaces.com/Rook+on+Open+File
 		f := FileBb(sq.File())
 		if pos.ByPiece(us, Pawn)&f == 0 {
 			if pos.ByPiece(them, Pawn)&f == 0 {
-				eval.Add(wRookOnOpenFile)
+				eval.AddTerm(TermRookFile, us, wRookOnOpenFile, trace)
 			} else {
-				eval.Add(wRookOnHalfOpenFile)
+				eval.AddTerm(TermRookFile, us, wRookOnHalfOpenFile, trace)
 			}
 		}
 	}
 	// Queen
 	for bb := pos.ByPiece(us, Queen); bb > 0; {
 		sq := bb.Pop()
-		eval.Add(wFigure[Queen])
+		eval.AddTerm(TermMaterial, us, wFigure[Queen], trace)
 		mobility := QueenMobility(sq, all) &^ excl
-		eval.AddN(wMobility[Queen], mobility.Count())
+		eval.AddNTerm(TermMobility, us, wMobility[Queen], mobility.Count(), trace)
 	}
 
 	// King, each side has one.
 	{
 		sq := pos.ByPiece(us, King).AsSquare()
-		mobility := KingMobility(sq) &^ excl
-		eval.AddN(wMobility[King], mobility.Count())
+		if IS_Atomic {
+			// in atomic only squares out of reach of explosions count
+			mobility := KingMobility(sq) & pos.SafeKingSquares(us)
+			eval.AddTerm(TermAtomicKingSafety, us, ATOMIC_KING_SAFE_SQUARE_SCORE.Multiply(mobility.Count()), trace)
+		} else {
+			mobility := KingMobility(sq) &^ excl
+			eval.AddNTerm(TermMobility, us, wMobility[King], mobility.Count(), trace)
+		}
 	}
 }
 
@@ -1263,13 +1978,27 @@ func evaluateSide(pos *Position, us Color, eval *Eval) {
 ///////////////////////////////////////////////
 // evaluatePosition : evalues position
 // -> pos *Position : position
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
+// <- Eval : eval
+
+func EvaluatePosition(pos *Position, trace *EvalBreakdown) Eval {
+	return globalEvalCaches.evaluatePosition(pos, trace)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluatePosition : evalues position with the given caches
+// -> ec *evalCaches : evaluation caches
+// -> pos *Position : position
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
 // <- Eval : eval
 
-func EvaluatePosition(pos *Position) Eval {
+func (ec *evalCaches) evaluatePosition(pos *Position, trace *EvalBreakdown) Eval {
 	var eval Eval
-	evaluateSide(pos, Black, &eval)
+	ec.evaluateSide(pos, Black, &eval, trace)
 	eval.Neg()
-	evaluateSide(pos, White, &eval)
+	ec.evaluateSide(pos, White, &eval, trace)
 	return eval
 }
 
@@ -1295,25 +2024,62 @@ func Phase(pos *Position) int32 {
 
 ///////////////////////////////////////////////
 // Evaluate : evaluates position from White's POV
+// the hand crafted evaluation is cached by Zobrist
 // -> pos *Position : position
 // <- int32 : eval
 
 func Evaluate(pos *Position) int32 {
+	return globalEvalCaches.evaluate(pos)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluate : evaluates position from White's POV with the given caches
+// -> ec *evalCaches : evaluation caches
+// -> pos *Position : position
+// <- int32 : eval
+
+func (ec *evalCaches) evaluate(pos *Position) int32 {
+	if NNUEActive() {
+		return EvaluateNNUE(pos)
+	}
+	return ec.position.load(pos, ec.evaluateClassical)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// evaluateClassical : evaluates position with the hand crafted evaluation
+// -> ec *evalCaches : evaluation caches
+// -> pos *Position : position
+// -> trace *EvalBreakdown : breakdown the terms are recorded in, nil if not tracing
+// <- int32 : the score
+
+func (ec *evalCaches) evaluateClassical(pos *Position, trace *EvalBreakdown) int32 {
+	info := ec.material.load(pos)
+	if module := info.endgame.module; ( module != nil ) && ( module.Evaluate != nil ) {
+		if trace != nil {
+			trace.Endgame = module.Name
+		}
+		return scoreMultiplier[info.endgame.strong] * module.Evaluate(pos, info.endgame.strong)
+	}
 	///////////////////////////////////////////////////
 	// NEW
 	if IS_Racing_Kings {
-		evalw := EvaluateSideRk(pos, White)
-		evalb := EvaluateSideRk(pos, Black)
+		evalw := EvaluateSideRk(pos, White, trace)
+		evalb := EvaluateSideRk(pos, Black, trace)
 
-		eval := evalw - evalb
+		eval := evalw - evalb + EvaluateRaceRk(pos, trace)
 
 		score := eval*128
 
 		return score
 	}
 	///////////////////////////////////////////////////
-	eval := EvaluatePosition(pos)
+	eval := ec.evaluatePosition(pos, trace)
 	score := eval.Feed(Phase(pos))
+	score = scaleMaterial(pos, info, score, trace)
 	if KnownLossScore >= score || score >= KnownWinScore {
 		panic(fmt.Sprintf("score %d should be between %d and %d",
 			score, KnownLossScore, KnownWinScore))
@@ -1323,13 +2089,100 @@ func Evaluate(pos *Position) int32 {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// scaleMaterial : scales the score down in material configurations the leading side can hardly win
+// the scaling of an endgame module replaces the generic one of its strong side
+// -> pos *Position : position
+// -> info materialInfo : knowledge of the material configuration
+// -> score int32 : score from White's POV
+// -> trace *EvalBreakdown : breakdown the scaling is recorded in, nil if not tracing
+// <- int32 : scaled score
+
+func scaleMaterial(pos *Position, info materialInfo, score int32, trace *EvalBreakdown) int32 {
+	scale := info.scale
+	if module := info.endgame.module; ( module != nil ) && ( module.Scale != nil ) {
+		scale[info.endgame.strong] = module.Scale(pos, info.endgame.strong)
+		if trace != nil {
+			trace.Endgame = module.Name
+		}
+	}
+	leading := White
+	if score < 0 {
+		leading = Black
+	}
+	if trace != nil {
+		trace.Scale = scale[leading]
+	}
+	return score * scale[leading] / MATERIAL_SCALE_FULL
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// materialScale : computes the scaling factors of a material configuration
+// without pawns a small material advantage is hard to convert, in horde a lone king cannot win
+// -> sig uint64 : material signature
+// <- [ColorArraySize]int32 : scaling factors by side, MATERIAL_SCALE_FULL is no scaling
+
+func materialScale(sig uint64) [ColorArraySize]int32 {
+	scale := [ColorArraySize]int32{MATERIAL_SCALE_FULL, MATERIAL_SCALE_FULL, MATERIAL_SCALE_FULL}
+	if IS_Racing_Kings || IS_Atomic {
+		// captures and the race decide these variants, not material
+		return scale
+	}
+	for _, us := range []Color{White, Black} {
+		them := us.Opposite()
+		if IS_Horde {
+			if ( us == HORDE_Pieces_Side ) && ( materialPoints(sig, us) == 0 ) {
+				scale[us] = 0
+			}
+			continue
+		}
+		if MaterialCount(sig, us, Pawn) > 0 {
+			continue
+		}
+		ours, theirs := materialPoints(sig, us), materialPoints(sig, them)
+		if ( MaterialCount(sig, us, Knight) == 2 ) && ( ours == 6 ) && ( MaterialCount(sig, them, Pawn) == 0 ) {
+			// two knights cannot force mate
+			scale[us] = 0
+		} else if ours - theirs <= MATERIAL_POINTS[Bishop] {
+			if ours < MATERIAL_POINTS[Rook] {
+				scale[us] = 0
+			} else if theirs <= MATERIAL_POINTS[Bishop] {
+				scale[us] = 8
+			} else {
+				scale[us] = 28
+			}
+		}
+	}
+	return scale
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// materialPoints : returns the non pawn material of a side in pawn units
+// -> sig uint64 : material signature
+// -> us Color : side
+// <- int32 : material
+
+func materialPoints(sig uint64, us Color) int32 {
+	points := int32(0)
+	for fig := Knight; fig <= Queen; fig++ {
+		points += int32(MaterialCount(sig, us, fig)) * MATERIAL_POINTS[fig]
+	}
+	return points
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // Score : evaluates current position from current player's POV
 // -> eng *Engine : engine
 // <- int32 : score
 
 func (eng *Engine) Score() int32 {
-	score := Evaluate(eng.Position)
+	score := eng.caches().evaluate(eng.Position)
 	score = ScaleToCentiPawn(score)
 	return scoreMultiplier[eng.Position.SideToMove] * score
 }
@@ -1420,74 +2273,124 @@ func (pos *Position) ThreeFoldRepetition() int {
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// endPosition : determines whether the current position is an end game
-// returns score and a bool if the game has ended
+// GetContempt : contempt for a search started from pos with side to move side
+// with dynamic contempt the contempt is halved gradually towards the late end game
+// -> pos *Position : root position
+// -> side Color : side to move at root
+// <- int32 : contempt in centipawns
+
+func GetContempt(pos *Position, side Color) int32 {
+	contempt := Contempt
+	if !ContemptIsSet {
+		contempt = VARIANT_CONTEMPT[Variant][side]
+	}
+	if DynamicContempt {
+		contempt = contempt * (512 - Phase(pos)) / 512
+	}
+	return contempt
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ContemptOptionDefault : contempt advertised as the default of the Contempt option
+// a variant default differing by side cannot be shown as one value, the side-neutral 0 is
+// advertised for it and the per-side defaults apply until the option is set
+// <- int32 : contempt in centipawns
+
+func ContemptOptionDefault() int32 {
+	if ContemptIsSet {
+		return Contempt
+	}
+	if VARIANT_CONTEMPT[Variant][White] != VARIANT_CONTEMPT[Variant][Black] {
+		return 0
+	}
+	return VARIANT_CONTEMPT[Variant][White]
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// drawScore : score of a drawn position from current player's POV
+// the side to move at root considers a draw worse by contempt
 // -> eng *Engine : engine
-// <- int32 : score
-// <- bool : true if game ended
+// <- int32 : draw score
 
-// need to export this
-func (eng *Engine) EndPosition() (int32, bool) {
-	return eng.endPosition()
+func (eng *Engine) drawScore() int32 {
+	if eng.Position.SideToMove == eng.rootSide {
+		return -eng.contempt
+	}
+	return eng.contempt
 }
 
-func (eng *Engine) endPosition() (int32, bool) {
-	pos := eng.Position // shortcut
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Terminal : tells whether the game is decided by the material on the board
+// covers captured horde pawns, missing kings and insufficient material
+// mate, stalemate and the draw rules are left to the search
+// -> pos *Position : position
+// <- Color : winner, NoColor for a draw
+// <- bool : true if game ended
+
+func (pos *Position) Terminal() (Color, bool) {
 	// in horde all pawns captured for the pawns side is mate
 	if IS_Horde {
 		if pos.AllPawnsCaptured() {
-			if HORDE_Pawns_Side == White {
-				return scoreMultiplier[pos.SideToMove] * (MatedScore + eng.ply()), true
-			} else {
-				return scoreMultiplier[pos.SideToMove] * (MateScore - eng.ply()), true
-			}
+			return HORDE_Pieces_Side, true
 		}
 	}
 	// trivial cases when kings are missing
 	if pos.ByPiece(White, King) == 0 && pos.ByPiece(Black, King) == 0 {
-		return 0, true
-	}
-	if pos.ByPiece(White, King) == 0 {
-		mateok := true
-		if IS_Horde {
-			// in horde pawns having no king is not mate
-			if HORDE_Pawns_Side == White {
-				mateok = false
-			}
-		}
-		if mateok {
-			return scoreMultiplier[pos.SideToMove] * (MatedScore + eng.ply()), true
-		}
+		return NoColor, true
 	}
-	if pos.ByPiece(Black, King) == 0 {
-		mateok := true
-		if IS_Horde {
-			// in horde pawns having no king is not mate
-			if HORDE_Pawns_Side == Black {
-				mateok = false
-			}
-		}
-		if mateok {
-			return scoreMultiplier[pos.SideToMove] * (MateScore - eng.ply()), true
+	for _, col := range []Color{White, Black} {
+		// in horde pawns having no king is not mate
+		if ( pos.ByPiece(col, King) == 0 ) && !( IS_Horde && ( col == HORDE_Pawns_Side ) ) {
+			return col.Opposite(), true
 		}
 	}
 	// Neither side cannot mate.
-	if pos.InsufficientMaterial() {
-		if IS_Horde {
-			// handle insufficient material in horde
-		} else {
-			return 0, true
+	if pos.InsufficientMaterial() && !IS_Horde {
+		return NoColor, true
+	}
+	return NoColor, false
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// endPosition : determines whether the current position is an end game
+// returns score and a bool if the game has ended
+// -> eng *Engine : engine
+// <- int32 : score
+// <- bool : true if game ended
+
+// need to export this
+func (eng *Engine) EndPosition() (int32, bool) {
+	return eng.endPosition()
+}
+
+func (eng *Engine) endPosition() (int32, bool) {
+	pos := eng.Position // shortcut
+	if winner, over := pos.Terminal(); over {
+		if winner == NoColor {
+			return eng.drawScore(), true
+		}
+		if winner == pos.SideToMove {
+			return MateScore - eng.ply(), true
 		}
+		return MatedScore + eng.ply(), true
 	}
 	// Fifty full moves without a capture or a pawn move.
 	if pos.FiftyMoveRule() {
-		return 0, true
+		return eng.drawScore(), true
 	}
 	// Repetition is a draw.
 	// At root we need to continue searching even if we saw two repetitions already,
 	// however we can prune deeper search only at two repetitions.
 	if r := pos.ThreeFoldRepetition(); eng.ply() > 0 && r >= 2 || r >= 3 {
-		return 0, true
+		return eng.drawScore(), true
 	}
 	return 0, false
 }
@@ -1600,12 +2503,12 @@ func (ht *HashTable) Clear() {
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// retrieveHash : gets from GlobalHashTable the current position
+// retrieveHash : gets from the transposition table the current position
 // -> eng *Engine : engine
 // <- hashEntry : hash entry
 
 func (eng *Engine) retrieveHash() hashEntry {
-	entry := GlobalHashTable.get(eng.Position)
+	entry := eng.hash().get(eng.Position)
 
 	if entry.kind == noEntry {
 		eng.Stats.CacheMiss++
@@ -1636,7 +2539,7 @@ func (eng *Engine) retrieveHash() hashEntry {
 ///////////////////////////////////////////////
 
 ///////////////////////////////////////////////
-// updateHash : updates GlobalHashTable with the current position
+// updateHash : updates the transposition table with the current position
 // -> eng *Engine : engine
 // -> α int32 : alpha
 // -> β int32 : beta
@@ -1672,7 +2575,7 @@ func (eng *Engine) updateHash(α, β, depth, score int32, move Move) {
 		}
 	}
 
-	GlobalHashTable.put(eng.Position, hashEntry{
+	eng.hash().put(eng.Position, hashEntry{
 		kind:  kind,
 		score: score,
 		depth: int8(depth),
@@ -1733,7 +2636,12 @@ func (st *stack) generateMoves(kind int) {
 	}
 	st.position.GenerateMoves(ms.kind&kind, &ms.moves)
 	for _, m := range ms.moves {
-		ms.order = append(ms.order, mvvlva(m))
+		if IS_Atomic && m.Capture() != NoPiece {
+			// order Atomic captures by the exploded material
+			ms.order = append(ms.order, int16(seeAtomic(st.position, m)))
+		} else {
+			ms.order = append(ms.order, mvvlva(m))
+		}
 	}
 }
 
@@ -1965,6 +2873,10 @@ func seeScore(m Move) int32 {
 // <- bool : true if see(m) < 0
 
 func seeSign(pos *Position, m Move) bool {
+	if IS_Atomic && m.Capture() != NoPiece {
+		// in Atomic capturing a more valuable piece can still lose material
+		return seeAtomic(pos, m) < 0
+	}
 	if m.Piece().Figure() <= m.Capture().Figure() {
 		// Even if m.Piece() is captured, we are still positive.
 		return false
@@ -1974,6 +2886,52 @@ func seeSign(pos *Position, m Move) bool {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// seeAtomic : static exchange evaluation of a capture in Atomic
+// the capture explodes the capturing piece and all non-pawn neighbours
+// so there is no exchange, the score is the balance of the exploded material
+// exploding the opponent's king scores at least seeBonus[King], i.e. decisive
+// the position can be either before or right after executing m
+// -> pos *Position : position
+// -> m Move : capture
+// <- int32 : score from the capturing side's POV
+
+func seeAtomic(pos *Position, m Move) int32 {
+	us := m.Piece().Color()
+	// the captured piece is won, the capturing piece (promoted if promotion) explodes
+	score := seeScore(m) - seeBonus[m.Target().Figure()]
+
+	if pos.LastMove() == m {
+		// the move was executed so the exploded pieces are recorded in the state
+		for i := 0; i < pos.curr.NumExplosions; i++ {
+			pi := pos.curr.ExplosionInfo[i].piece
+			if pi.Color() == us {
+				score -= seeBonus[pi.Figure()]
+			} else {
+				score += seeBonus[pi.Figure()]
+			}
+		}
+		return score
+	}
+
+	for _, sq := range explosionsquares[m.To()] {
+		if sq == m.From() {
+			// the capturing piece was already counted
+			continue
+		}
+		if pi := pos.Get(sq); ( pi != NoPiece ) && ( pi.Figure() != Pawn ) {
+			if pi.Color() == us {
+				score -= seeBonus[pi.Figure()]
+			} else {
+				score += seeBonus[pi.Figure()]
+			}
+		}
+	}
+	return score
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // see : returns the static exchange evaluation for m, where is
 // the last move executed
@@ -1988,6 +2946,9 @@ func seeSign(pos *Position, m Move) bool {
 // <- int32 : score
 
 func see(pos *Position, m Move) int32 {
+	if IS_Atomic && m.Capture() != NoPiece {
+		return seeAtomic(pos, m)
+	}
 	us := pos.SideToMove
 	sq := m.To()
 	bb := sq.Bitboard()
@@ -2104,12 +3065,6 @@ func see(pos *Position, m Move) int32 {
 // <- int32 : score
 
 func (eng *Engine) searchQuiescence(α, β int32) int32 {
-
-	if IS_Atomic {
-		// quiescence problematic in atomic because of captures cause explosions
-		return eng.Score()
-	}
-
 	eng.Stats.Nodes++
 	if score, done := eng.endPosition(); done {
 		return score
@@ -2137,7 +3092,7 @@ func (eng *Engine) searchQuiescence(α, β int32) int32 {
 	for move := eng.stack.PopMove(); move != NullMove; move = eng.stack.PopMove() {
 		// prune futile moves that would anyway result in a stand-pat
 		// at that next depth
-		if !inCheck && isFutile(pos, static, localα, futilityMargin, move) {
+		if !inCheck && isFutile(pos, static, localα, FutilityMargin, move) {
 			// TODO: should it update localα?
 			continue
 		}
@@ -2427,6 +3382,27 @@ func (tc *TimeControl) Stopped() bool {
 
 ///////////////////////////////////////////////
 
+///////////////////////////////////////////////
+// NodesExhausted : returns true if the node limit has been reached
+// the limit is ignored at the first few depths, otherwise no move would be found
+// -> tc *TimeControl : time control
+// -> nodes uint64 : number of nodes searched so far
+// <- bool : true if node limit reached
+
+func (tc *TimeControl) NodesExhausted(nodes uint64) bool {
+	if tc.Nodes == 0 || tc.currDepth <= 2 {
+		return false
+	}
+	if nodes < tc.Nodes {
+		return false
+	}
+	// node limit reached so flip the stopped flag
+	tc.stopped.set()
+	return true
+}
+
+///////////////////////////////////////////////
+
 ///////////////////////////////////////////////
 // searchTree : implements searchTree framework
 // searchTree fails soft, i.e. the score returned can be outside the bounds
@@ -2449,6 +3425,10 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 	pos := eng.Position
 	us := pos.SideToMove
 
+	// the move excluded by a singular extension search applies to this node only
+	excluded := eng.excluded
+	eng.excluded = NullMove
+
 	// update statistics
 	eng.Stats.Nodes++
 	if !eng.stopped && eng.Stats.Nodes >= eng.checkpoint {
@@ -2457,6 +3437,9 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 			eng.stopped = true
 		}
 	}
+	if !eng.stopped && eng.timeControl.NodesExhausted(eng.Stats.Nodes) {
+		eng.stopped = true
+	}
 	if eng.stopped {
 		return α
 	}
@@ -2469,18 +3452,50 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 		return score
 	}
 
-	// mate pruning: if an ancestor already has a mate in ply moves then
-	// the search will always fail low so we return the lowest wining score
-	if MateScore-ply <= α {
-		return KnownWinScore
+	// mate distance pruning: even mating at the next ply cannot score better
+	// than MateScore-ply-1 and being mated now cannot score worse than MatedScore+ply
+	// if the window falls outside these bounds an ancestor already has a shorter mate
+	// https://chessprogramming.wikispaces.com/Mate+Distance+Pruning
+	if ply > 0 {
+		α = max(α, MatedScore+ply)
+		β = min(β, MateScore-ply-1)
+		if α >= β {
+			return α
+		}
+	}
+
+	// the tablebases know the result, at root a move is needed so search anyway
+	if ply > 0 {
+		if result, ok := ProbeTablebase(pos); ok {
+			eng.Stats.TBHits++
+			switch result.Winner {
+				case NoColor: return eng.drawScore()
+				case us: return MateScore - ply - result.Plies
+				default: return MatedScore + ply + result.Plies
+			}
+		}
+	}
+
+	// the Syzygy tables only know the result, probe after captures and pawn moves
+	// where the fifty move rule counter does not matter yet
+	if ( ply > 0 ) && ( pos.HalfmoveClock() == 0 ) {
+		if wdl, ok := ProbeSyzygyWDL(pos); ok {
+			eng.Stats.TBHits++
+			switch {
+				case wdl == SyzygyWin: return SYZYGY_WIN_SCORE - ply
+				case wdl == SyzygyLoss: return -SYZYGY_WIN_SCORE + ply
+				default: return eng.drawScore()
+			}
+		}
 	}
 
 	entry := eng.retrieveHash()
 	hash := entry.move
 
-	isfirstpv := ( MultiPVIndex <= 1 )
+	isfirstpv := ( eng.pvIndex <= 1 )
 
-	if isfirstpv || ( ( !isfirstpv ) && ( depth < CurrentSearchDepth ) ) {
+	// the hash entry does not apply when a move is excluded
+	if excluded == NullMove && ( isfirstpv || ( ( !isfirstpv ) && ( depth < eng.searchDepth ) ) ) {
 		// check the transposition table		
 		if entry.kind != noEntry && depth <= int32(entry.depth) {
 			if entry.kind == exact {
@@ -2510,7 +3525,9 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 	if depth <= 0 {
 		// depth can be < 0 due to aggressive LMR
 		score := eng.searchQuiescence(α, β)
-		eng.updateHash(α, β, depth, score, NullMove)
+		if excluded == NullMove {
+			eng.updateHash(α, β, depth, score, NullMove)
+		}
 		return score
 	}
 
@@ -2521,13 +3538,14 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 	// verification that we are not in check is done by tryMove
 	// which bails out if after the null move we are still in check
 	if depth > NullMoveDepthLimit && // not very close to leafs
+		excluded == NullMove && // not while verifying a singular move
 		!sideIsChecked && // nullmove is illegal when in check
 		pos.HasNonPawns(us) && // at least one minor/major piece
 		KnownLossScore < α && β < KnownWinScore { // disable in lost or won positions
 
 		reduction := NullMoveDepthReduction
-		if pos.NumNonPawns(us) >= 3 {
-			// reduce more when there are three minor/major pieces
+		if pos.NumNonPawns(us) >= int(NullMoveExtraPieces) {
+			// reduce more when there are many minor/major pieces
 			reduction++
 		}
 
@@ -2538,6 +3556,27 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 		}
 	}
 
+	// singular extension: if all moves but the hash move fail low by a margin
+	// in a reduced search excluding the hash move, the hash move is singular
+	// https://chessprogramming.wikispaces.com/Singular+Extensions
+	singular := false
+	extendable := ply*100 < eng.searchDepth*ExtensionPlyPercent
+	if depth >= SingularDepthLimit &&
+		extendable &&
+		ply > 0 && // root moves are not extended
+		excluded == NullMove && len(ignoremoves) == 0 && // no recursive singular searches
+		hash != NullMove && entry.kind != failedLow && // the hash move was good
+		int32(entry.depth) >= depth-SingularHashDepth && // and searched deep enough
+		KnownLossScore < entry.score && entry.score < KnownWinScore { // disable when searching for a mate
+		singularβ := entry.score - SingularMargin*depth
+		eng.excluded = hash
+		score := eng.searchTree(singularβ-1, singularβ, depth/2, []Move{})
+		singular = score < singularβ
+	}
+
+	// in Atomic extend moves creating a new threat to explode the opponent's king
+	atomicThreat := IS_Atomic && extendable && ( pos.ExplosionThreats(us) != 0 )
+
 	bestMove, bestScore := NullMove, -InfinityScore
 
 	// futility and history pruning at frontier nodes
@@ -2566,6 +3605,10 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 
 	eng.stack.GenerateMoves(All, hash)
 	for move := eng.stack.PopMove(); move != NullMove; move = eng.stack.PopMove() {
+		// skip the move excluded by the singular extension search
+		if move == excluded {
+			continue
+		}
 		// skip moves that are on the ignore list
 		if len(ignoremoves) > 0 {
 			found := false
@@ -2610,38 +3653,53 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 		// see discussion: http://www.talkchess.com/forum/viewtopic.php?t=56361
 		// when the move gives check, history pruning and futility pruning are also disabled
 		givesCheck := pos.IsChecked(us.Opposite())
+		extension := int32(0)
 		if givesCheck {
 			if pos.GetAttacker(move.To(), us.Opposite()) == NoFigure ||
 				pos.GetAttacker(move.To(), us) != NoFigure {
-				newDepth += CheckDepthExtension
+				extension += CheckDepthExtension
 			}
 		}
+		// extend the singular hash move
+		if singular && move == hash {
+			extension += SingularExtension
+		}
+		// in Atomic extend moves which threaten to explode the opponent's king
+		if IS_Atomic && extendable && !atomicThreat && ( pos.ExplosionThreats(us) != 0 ) {
+			extension += AtomicThreatExtension
+		}
+		// in Racing Kings extend king advances close to the goal
+		if IS_Racing_Kings && extendable && isKingAdvance(move) {
+			extension += RKAdvanceExtension
+		}
+		newDepth += min(extension, MaxMoveExtension)
 
 		// reduce late quiet moves and bad captures
+		// extended moves are not reduced
 		// TODO: do not compute see when in check
 		lmr := int32(0)
-		if allowLateMove && !givesCheck && !critical {
+		if allowLateMove && !givesCheck && !critical && extension == 0 {
 			if move.IsQuiet() {
 				// reduce quiet moves more at high depths and after many quiet moves
 				// large numQuiet means it's likely not a CUT node
 				// large depth means reductions are less risky
-				lmr = 1 + min(depth, numQuiet)/5
+				lmr = 1 + min(depth, numQuiet)/LMRQuietDivisor
 			} else if seeSign(pos, move) {
 				// bad captures (SEE<0) can be reduced, too
-				lmr = 1
+				lmr = LMRBadCapture
 			}
 		}
 
 		// prune moves close to frontier
-		if allowLeafsPruning && !givesCheck && !critical {
+		if allowLeafsPruning && !givesCheck && !critical && extension == 0 {
 			// prune quiet moves that performed bad historically
-			if bad, good := eng.history.get(move); bad > 16*good && (move.IsQuiet() || seeSign(pos, move)) {
+			if bad, good := eng.history.get(move); bad > int(HistoryPruneRatio)*good && (move.IsQuiet() || seeSign(pos, move)) {
 				dropped = true
 				eng.UndoMove()
 				continue
 			}
 			// prune moves that do not raise alphas
-			if isFutile(pos, static, localα, depth*futilityMargin, move) {
+			if isFutile(pos, static, localα, depth*FutilityMargin, move) {
 				bestScore = max(bestScore, static)
 				dropped = true
 				eng.UndoMove()
@@ -2655,7 +3713,9 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 		}
 		if score >= β { // fail high, cut node
 			eng.stack.SaveKiller(move)
-			eng.updateHash(α, β, depth, score, move)
+			if excluded == NullMove {
+				eng.updateHash(α, β, depth, score, move)
+			}
 			return score
 		}
 		if score > bestScore {
@@ -2665,13 +3725,19 @@ func (eng *Engine) searchTree(α, β, depth int32, ignoremoves []Move) int32 {
 		}
 	}
 
+	// the score of a singular extension search is not stored
+	// without other moves it fails low so the excluded move is singular
+	if excluded != NullMove {
+		return bestScore
+	}
+
 	if !dropped {
 		// if no move was found then the game is over
 		if bestMove == NullMove {
 			if sideIsChecked {
 				bestScore = MatedScore + ply
 			} else {
-				bestScore = 0
+				bestScore = eng.drawScore()
 			}
 		}
 		// update hash and principal variation tables
@@ -2780,12 +3846,23 @@ func (eng *Engine) search(depth, estimated int32, ignoremoves []Move) int32 {
 func (eng *Engine) Play(tc *TimeControl, ignoremoves []Move) (moves []Move) {
 	eng.Log.BeginSearch()
 	eng.Stats = Stats{Depth: -1}
+	if !eng.Options.Private {
+		resetEvalCacheStats()
+	}
 
 	eng.rootPly = eng.Position.Ply
 	eng.timeControl = tc
 	eng.stopped = false
 	eng.checkpoint = checkpointStep
 	eng.stack.Reset(eng.Position)
+	eng.rootSide = eng.Position.SideToMove
+	eng.contempt = GetContempt(eng.Position, eng.rootSide)
+
+	// the Syzygy tables exclude the root moves that spoil the result
+	if excluded, ok := ProbeSyzygyRoot(eng.Position); ok {
+		eng.Stats.TBHits++
+		ignoremoves = excludeRootMoves(eng.Position, ignoremoves, excluded)
+	}
 
 	score := int32(0)
 
@@ -2798,15 +3875,26 @@ func (eng *Engine) Play(tc *TimeControl, ignoremoves []Move) (moves []Move) {
 
 		eng.Stats.Depth = depth
 
-		CurrentSearchDepth = depth
+		eng.searchDepth = depth
 
 		legalmoves := eng.Position.GetLegalMoves(GET_ALL)
 		numlegalmoves := len(legalmoves)
 		ignoremovescurrent := ignoremoves
 
-		MultiPVList = MultiPVItemList{}
+		pvlist := MultiPVItemList{}
+
+		numpv := 1
+		if !eng.Options.Private {
+			// a private engine searches a single pv and leaves the global search state alone
+			CurrentSearchDepth = depth
+			numpv = SearchMultiPV()
+		}
+
+		for eng.pvIndex = 1 ; eng.pvIndex <= numpv ; eng.pvIndex++ {
 
-		for MultiPVIndex = 1 ; MultiPVIndex <= MultiPV ; MultiPVIndex++ {
+			if !eng.Options.Private {
+				MultiPVIndex = eng.pvIndex
+			}
 
 			searchok := ( len(ignoremovescurrent) == 0 )
 
@@ -2822,16 +3910,22 @@ func (eng *Engine) Play(tc *TimeControl, ignoremoves []Move) (moves []Move) {
 
 				if !eng.stopped {
 					// if eng has not been stopped then this is a legit pv
-					moves = eng.pvTable.Get(eng.Position)
+					pv := eng.pvTable.Get(eng.Position)
+
+					if len(pv) > 0 {
+						ignoremovescurrent = append(ignoremovescurrent, pv[0])
+					}
 
-					if len(moves) > 0 {
-						ignoremovescurrent = append(ignoremovescurrent, moves[0])
+					// the best line is returned, the further lines are only candidates
+					if eng.pvIndex == 1 {
+						moves = pv
+						eng.LastScore = score
 					}
 
-					//eng.Log.PrintPV(eng.Stats, score, moves)
-					item := eng.Log.CreateMultiPVItem(eng.Stats, score, moves)
+					//eng.Log.PrintPV(eng.Stats, score, pv)
+					item := eng.Log.CreateMultiPVItem(eng.Stats, score, pv)
 
-					MultiPVList = append(MultiPVList, item)
+					pvlist = append(pvlist, item)
 
 				}
 
@@ -2839,9 +3933,14 @@ func (eng *Engine) Play(tc *TimeControl, ignoremoves []Move) (moves []Move) {
 
 		}
 
-		MultiPVIndex = 1
+		eng.pvIndex = 1
 
-		ReportPV()
+		if !eng.Options.Private {
+			eng.Stats.EvalCache = globalEvalCaches.stats
+			MultiPVList = pvlist
+			MultiPVIndex = 1
+			ReportPV()
+		}
 		
 	}
 
@@ -2849,4 +3948,33 @@ func (eng *Engine) Play(tc *TimeControl, ignoremoves []Move) (moves []Move) {
 	return moves
 }
 
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// excludeRootMoves : adds moves to the ignored root moves unless no legal move would be left
+// -> pos *Position : root position
+// -> ignoremoves []Move : ignored moves
+// -> excluded []Move : moves to exclude
+// <- []Move : ignored moves
+
+func excludeRootMoves(pos *Position, ignoremoves, excluded []Move) []Move {
+	ignored := map[Move]bool{}
+	for _, m := range ignoremoves {
+		ignored[m] = true
+	}
+	merged := append([]Move{}, ignoremoves...)
+	for _, m := range excluded {
+		if !ignored[m] {
+			ignored[m] = true
+			merged = append(merged, m)
+		}
+	}
+	for _, m := range pos.GetLegalMoves(GET_ALL) {
+		if !ignored[m] {
+			return merged
+		}
+	}
+	return ignoremoves
+}
+
 ///////////////////////////////////////////////
\ No newline at end of file
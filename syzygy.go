@@ -0,0 +1,1410 @@
+//////////////////////////////////////////////////////
+// syzygy.go
+// implements probing of Syzygy WDL and DTZ tablebases for standard and atomic chess
+// the file format, the index encoding and the decompression follow the reference prober
+// tables are read in memory the first time they are probed
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// maximum number of pieces, kings included, of a Syzygy table
+const SYZYGY_MAX_PIECES = 7
+
+// score of a tablebase win, minus the ply, below mate scores and above evaluation scores
+const SYZYGY_WIN_SCORE = KnownWinScore - 1024
+
+// results of a WDL probe from the side to move's POV
+// cursed wins and blessed losses are decided by the fifty move rule
+const(
+	SyzygyLoss        = -2
+	SyzygyBlessedLoss = -1
+	SyzygyDraw        = 0
+	SyzygyCursedWin   = 1
+	SyzygyWin         = 2
+)
+
+// states of a probe
+const(
+	syzygyFail            = iota // the tables do not cover the position
+	syzygyOk                     // value found
+	syzygyChangeSTM              // DTZ table stores the other side to move
+	syzygyZeroingBestMove        // the best move is a capture or a pawn move
+)
+
+// flags of a table part
+const(
+	syzygyFlagSTM         byte = 1
+	syzygyFlagMapped      byte = 2
+	syzygyFlagWinPlies    byte = 4
+	syzygyFlagLossPlies   byte = 8
+	syzygyFlagWide        byte = 16
+	syzygyFlagSingleValue byte = 128
+)
+
+// syzygyFormat : files of the tables of a variant
+type syzygyFormat struct {
+	Suffix         [2]string  // WDL and DTZ file suffixes
+	Magic          [2][4]byte // WDL and DTZ magic numbers
+	ConnectedKings bool       // kings may stand next to each other
+}
+
+// Syzygy formats by variant
+var SYZYGY_FORMATS = map[int]syzygyFormat{
+	VARIANT_Standard: {
+		Suffix: [2]string{".rtbw", ".rtbz"},
+		Magic:  [2][4]byte{{0x71, 0xe8, 0x23, 0x5d}, {0xd7, 0x66, 0x0c, 0xa5}},
+	},
+	VARIANT_Atomic: {
+		Suffix:         [2]string{".atbw", ".atbz"},
+		Magic:          [2][4]byte{{0x55, 0x8d, 0xa4, 0x49}, {0x91, 0xa9, 0x5e, 0xeb}},
+		ConnectedKings: true,
+	},
+}
+
+// directories of the Syzygy files, separated by the list separator of the system, empty if none
+var SyzygyPath = ""
+
+// registered tables by variant and material signature, WDL tables first
+var syzygyTables = map[int]*[2]map[uint64]*syzygyTable{}
+
+// largest number of pieces of the registered WDL tables by variant
+var syzygyMaxPieces = map[int]int{}
+
+// syzygyPairs : a part of a table, by side to move and file of the leading pawn
+type syzygyPairs struct {
+	flags           byte
+	pieces          [SYZYGY_MAX_PIECES]byte     // piece order of the index
+	groupLen        [SYZYGY_MAX_PIECES + 1]int  // pieces by group, zero terminated
+	groupIdx        [SYZYGY_MAX_PIECES + 1]uint64 // index factor by group, the last one is the size
+	sizeofBlock     uint64
+	span            uint64
+	sparseIndexSize uint64
+	blocksNum       uint64
+	blockLengthSize uint64
+	minSymLen       int
+	base64          []uint64 // lowest code of each symbol length, left aligned
+	symlen          []byte   // number of values minus one of each symbol
+	lowestSym       int      // offsets in the file
+	btree           int
+	sparseIndex     int
+	blockLength     int
+	data            int
+	mapIdx          [4]int // DTZ value maps by WDL result
+}
+
+// syzygyTable : a WDL or DTZ table file
+type syzygyTable struct {
+	variant         int
+	dtz             bool
+	code            string // material configuration, e.g. KRvK
+	path            string
+	key             uint64 // signature with White having the first part of the configuration
+	key2            uint64 // signature with Black having the first part of the configuration
+	pieceCount      int
+	hasPawns        bool
+	hasUniquePieces bool
+	pawnCount       [2]int // pawns of the leading color, pawns of the other color
+
+	once  sync.Once
+	err   error
+	data  []byte
+	mapAt int
+	pairs [2][4]syzygyPairs
+}
+
+// index tables
+var(
+	syzygyBinomial      [SYZYGY_MAX_PIECES][64]uint64
+	syzygyMapPawns      [64]int
+	syzygyLeadPawnIdx   [SYZYGY_MAX_PIECES][64]uint64
+	syzygyLeadPawnsSize [SYZYGY_MAX_PIECES][4]uint64
+	syzygyMapB1H1H7     [64]int
+	syzygyMapA1D1D4     [64]int
+	syzygyMapKK         [2][10][64]int // separated and connected kings
+	syzygyKKSize        [2]uint64
+)
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// init : initializes the index tables
+
+func init() {
+	// squares below the a1-h8 diagonal
+	code := 0
+	for sq := 0; sq < 64; sq++ {
+		if syzygyOffDiagonal(Square(sq)) < 0 {
+			syzygyMapB1H1H7[sq] = code
+			code++
+		}
+	}
+
+	// squares of the a1-d1-d4 triangle, the diagonal last
+	code = 0
+	diagonal := []int{}
+	for sq := 0; sq <= int(SquareD4); sq++ {
+		if ( syzygyOffDiagonal(Square(sq)) < 0 ) && ( Square(sq).File() <= 3 ) {
+			syzygyMapA1D1D4[sq] = code
+			code++
+		} else if ( syzygyOffDiagonal(Square(sq)) == 0 ) && ( Square(sq).File() <= 3 ) {
+			diagonal = append(diagonal, sq)
+		}
+	}
+	for _, sq := range diagonal {
+		syzygyMapA1D1D4[sq] = code
+		code++
+	}
+
+	// pairs of kings with the first one in the triangle, both on the diagonal last
+	for connected := 0; connected < 2; connected++ {
+		code = 0
+		both := [][2]int{}
+		for idx := 0; idx < 10; idx++ {
+			for s1 := Square(0); s1 <= SquareD4; s1++ {
+				if ( syzygyMapA1D1D4[s1] != idx ) || ( ( idx == 0 ) && ( s1 != SquareB1 ) ) {
+					continue
+				}
+				for s2 := Square(0); s2 < 64; s2++ {
+					switch {
+						case s1 == s2:
+						case ( connected == 0 ) && KingMobility(s1).Has(s2):
+						case ( syzygyOffDiagonal(s1) == 0 ) && ( syzygyOffDiagonal(s2) > 0 ):
+						case ( syzygyOffDiagonal(s1) == 0 ) && ( syzygyOffDiagonal(s2) == 0 ):
+							both = append(both, [2]int{idx, int(s2)})
+						default:
+							syzygyMapKK[connected][idx][s2] = code
+							code++
+					}
+				}
+			}
+		}
+		for _, p := range both {
+			syzygyMapKK[connected][p[0]][p[1]] = code
+			code++
+		}
+		syzygyKKSize[connected] = uint64(code)
+	}
+
+	// ways to choose k squares of n
+	syzygyBinomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; ( k < SYZYGY_MAX_PIECES ) && ( k <= n ); k++ {
+			if k > 0 {
+				syzygyBinomial[k][n] += syzygyBinomial[k-1][n-1]
+			}
+			if k < n {
+				syzygyBinomial[k][n] += syzygyBinomial[k][n-1]
+			}
+		}
+	}
+
+	// pawn squares, the leading pawn is the one with the highest value
+	// i.e. the closest to the edge and among those the lowest one
+	available := 47
+	for cnt := 1; cnt < SYZYGY_MAX_PIECES; cnt++ {
+		for f := 0; f < 4; f++ {
+			idx := uint64(0)
+			for r := 1; r < 7; r++ {
+				sq := RankFile(r, f)
+				if cnt == 1 {
+					syzygyMapPawns[sq] = available
+					available--
+					syzygyMapPawns[sq^7] = available
+					available--
+				}
+				syzygyLeadPawnIdx[cnt][sq] = idx
+				idx += syzygyBinomial[cnt-1][syzygyMapPawns[sq]]
+			}
+			syzygyLeadPawnsSize[cnt][f] = idx
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyOffDiagonal : returns the signed distance of a square from the a1-h8 diagonal
+// -> sq Square : square
+// <- int : positive above the diagonal, negative below
+
+func syzygyOffDiagonal(sq Square) int {
+	return sq.Rank() - sq.File()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadSyzygy : registers the Syzygy tables of the directories of a path
+// the files are only read when probed
+// -> path string : directories separated by the list separator of the system, empty for none
+// <- int : number of WDL tables found
+// <- error : error
+
+func LoadSyzygy(path string) (int, error) {
+	tables := map[int]*[2]map[uint64]*syzygyTable{}
+	maxPieces := map[int]int{}
+	num := 0
+	if path != "" {
+		for _, dir := range filepath.SplitList(path) {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				return 0, fmt.Errorf("syzygy directory %s not found", dir)
+			}
+			for variant, format := range SYZYGY_FORMATS {
+				if tables[variant] == nil {
+					tables[variant] = &[2]map[uint64]*syzygyTable{{}, {}}
+				}
+				for kind, suffix := range format.Suffix {
+					paths, err := filepath.Glob(filepath.Join(dir, "*"+suffix))
+					if err != nil {
+						return 0, err
+					}
+					for _, file := range paths {
+						t, err := newSyzygyTable(variant, strings.TrimSuffix(filepath.Base(file), suffix), file, kind == 1)
+						if err != nil {
+							// not named after a material configuration
+							continue
+						}
+						if _, found := tables[variant][kind][t.key]; found {
+							// the first directory wins
+							continue
+						}
+						tables[variant][kind][t.key] = t
+						tables[variant][kind][t.key2] = t
+						if kind == 0 {
+							num++
+							if t.pieceCount > maxPieces[variant] {
+								maxPieces[variant] = t.pieceCount
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	syzygyTables = tables
+	syzygyMaxPieces = maxPieces
+	SyzygyPath = path
+	return num, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// newSyzygyTable : creates a table of a file
+// -> variant int : variant
+// -> code string : material configuration, the stronger side first, e.g. KRvK
+// -> path string : file
+// -> dtz bool : true for a DTZ table
+// <- *syzygyTable : table
+// <- error : error
+
+func newSyzygyTable(variant int, code, path string, dtz bool) (*syzygyTable, error) {
+	parts := strings.Split(code, "v")
+	if ( len(parts) != 2 ) || ( len(parts[0])+len(parts[1]) > SYZYGY_MAX_PIECES ) {
+		return nil, fmt.Errorf("invalid syzygy table %s", code)
+	}
+	t := &syzygyTable{variant: variant, dtz: dtz, code: code, path: path}
+	var err error
+	if t.key, err = MaterialSignatureFromCode(parts[0]+parts[1], White); err != nil {
+		return nil, err
+	}
+	if t.key2, err = MaterialSignatureFromCode(parts[0]+parts[1], Black); err != nil {
+		return nil, err
+	}
+	t.pieceCount = len(parts[0]) + len(parts[1])
+	pawns := [2]int{strings.Count(parts[0], "P"), strings.Count(parts[1], "P")}
+	t.hasPawns = pawns[0]+pawns[1] > 0
+	for _, part := range parts {
+		for _, symbol := range "QRBNP" {
+			if strings.Count(part, string(symbol)) == 1 {
+				t.hasUniquePieces = true
+			}
+		}
+	}
+	// the leading color has the fewer pawns, the other color if it has none
+	if ( pawns[1] == 0 ) || ( ( pawns[0] > 0 ) && ( pawns[1] >= pawns[0] ) ) {
+		t.pawnCount = pawns
+	} else {
+		t.pawnCount = [2]int{pawns[1], pawns[0]}
+	}
+	return t, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// open : reads the file of a table the first time it is used
+// -> t *syzygyTable : table
+// <- error : error
+
+func (t *syzygyTable) open() error {
+	t.once.Do(func() {
+		data, err := os.ReadFile(t.path)
+		if err != nil {
+			t.err = err
+			return
+		}
+		kind := 0
+		if t.dtz {
+			kind = 1
+		}
+		if ( len(data) < 5 ) || ( [4]byte(data[:4]) != SYZYGY_FORMATS[t.variant].Magic[kind] ) {
+			t.err = fmt.Errorf("%s: not a syzygy table", t.path)
+			return
+		}
+		t.data = data
+		if err := t.setup(); err != nil {
+			t.data = nil
+			t.err = err
+		}
+	})
+	return t.err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// setup : parses the headers of the parts of a table
+// -> t *syzygyTable : table
+// <- error : error
+
+func (t *syzygyTable) setup() error {
+	data := t.data
+	corrupt := fmt.Errorf("%s: corrupt syzygy table", t.path)
+
+	if ( data[4]&2 != 0 ) != t.hasPawns {
+		return corrupt
+	}
+	at := 5
+
+	sides := 1
+	if !t.dtz && ( t.key != t.key2 ) {
+		sides = 2
+	}
+	files := 1
+	if t.hasPawns {
+		files = 4
+	}
+	pp := t.hasPawns && ( t.pawnCount[1] > 0 )
+
+	for f := 0; f < files; f++ {
+		if at+2+t.pieceCount > len(data) {
+			return corrupt
+		}
+		order := [2][2]int{{int(data[at] & 0xf), 0xf}, {int(data[at] >> 4), 0xf}}
+		if pp {
+			order[0][1], order[1][1] = int(data[at+1]&0xf), int(data[at+1]>>4)
+			at++
+		}
+		at++
+		for k := 0; k < t.pieceCount; k, at = k+1, at+1 {
+			t.pairs[0][f].pieces[k] = data[at] & 0xf
+			t.pairs[1][f].pieces[k] = data[at] >> 4
+		}
+		for i := 0; i < sides; i++ {
+			t.setGroups(&t.pairs[i][f], order[i], f)
+		}
+	}
+
+	at += at & 1
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			var err error
+			if at, err = t.setSizes(&t.pairs[i][f], at); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t.dtz {
+		t.mapAt = at
+		for f := 0; f < files; f++ {
+			d := &t.pairs[0][f]
+			if d.flags&syzygyFlagMapped == 0 {
+				continue
+			}
+			for i := 0; i < 4; i++ {
+				if at+2 > len(data) {
+					return corrupt
+				}
+				if d.flags&syzygyFlagWide != 0 {
+					if i == 0 {
+						at += at & 1
+					}
+					d.mapIdx[i] = ( at-t.mapAt )/2 + 1
+					at += 2*int(binary.LittleEndian.Uint16(data[at:])) + 2
+				} else {
+					d.mapIdx[i] = at - t.mapAt + 1
+					at += int(data[at]) + 1
+				}
+			}
+		}
+		at += at & 1
+	}
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			t.pairs[i][f].sparseIndex = at
+			at += int(t.pairs[i][f].sparseIndexSize) * 6
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			t.pairs[i][f].blockLength = at
+			at += int(t.pairs[i][f].blockLengthSize) * 2
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			// blocks are aligned on 64 bytes
+			at = ( at + 0x3f ) &^ 0x3f
+			t.pairs[i][f].data = at
+			at += int(t.pairs[i][f].blocksNum * t.pairs[i][f].sizeofBlock)
+		}
+	}
+	if at > len(data) {
+		return corrupt
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// setGroups : computes the groups of pieces of a part and their index factors
+// -> t *syzygyTable : table
+// -> d *syzygyPairs : part
+// -> order [2]int : encoding order of the leading group and of the remaining pawns
+// -> f int : file of the leading pawn
+
+func (t *syzygyTable) setGroups(d *syzygyPairs, order [2]int, f int) {
+	n := 0
+	firstLen := 2
+	if t.hasPawns {
+		firstLen = 0
+	} else if t.hasUniquePieces {
+		firstLen = 3
+	}
+	d.groupLen[0] = 1
+	for i := 1; i < t.pieceCount; i++ {
+		firstLen--
+		if ( firstLen > 0 ) || ( d.pieces[i] == d.pieces[i-1] ) {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+
+	pp := t.hasPawns && ( t.pawnCount[1] > 0 )
+	next := 1
+	freeSquares := 64 - d.groupLen[0]
+	if pp {
+		next = 2
+		freeSquares -= d.groupLen[1]
+	}
+	idx := uint64(1)
+	for k := 0; ( next < n ) || ( k == order[0] ) || ( k == order[1] ); k++ {
+		if k == order[0] {
+			// leading pawns or pieces
+			d.groupIdx[0] = idx
+			switch {
+				case t.hasPawns: idx *= syzygyLeadPawnsSize[d.groupLen[0]][f]
+				case t.hasUniquePieces: idx *= 31332
+				default: idx *= syzygyKKSize[t.connectedKings()]
+			}
+		} else if k == order[1] {
+			// remaining pawns
+			d.groupIdx[1] = idx
+			idx *= syzygyBinomial[d.groupLen[1]][48-d.groupLen[0]]
+		} else {
+			// remaining pieces
+			d.groupIdx[next] = idx
+			idx *= syzygyBinomial[d.groupLen[next]][freeSquares]
+			freeSquares -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// connectedKings : returns 1 if the kings of the variant may stand next to each other
+// -> t *syzygyTable : table
+// <- int : 1 for connected kings, otherwise 0
+
+func (t *syzygyTable) connectedKings() int {
+	if SYZYGY_FORMATS[t.variant].ConnectedKings {
+		return 1
+	}
+	return 0
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// setSizes : parses the compression header of a part
+// -> t *syzygyTable : table
+// -> d *syzygyPairs : part
+// -> at int : offset of the header
+// <- int : offset after the header
+// <- error : error
+
+func (t *syzygyTable) setSizes(d *syzygyPairs, at int) (int, error) {
+	data := t.data
+	corrupt := fmt.Errorf("%s: corrupt syzygy table", t.path)
+	if at+2 > len(data) {
+		return 0, corrupt
+	}
+
+	d.flags = data[at]
+	at++
+	if d.flags&syzygyFlagSingleValue != 0 {
+		// all positions have the same value
+		d.minSymLen = int(data[at])
+		return at + 1, nil
+	}
+
+	if at+10 > len(data) {
+		return 0, corrupt
+	}
+	n := 0
+	for d.groupLen[n] != 0 {
+		n++
+	}
+	tbSize := d.groupIdx[n]
+	d.sizeofBlock = 1 << data[at]
+	d.span = 1 << data[at+1]
+	d.sparseIndexSize = ( tbSize + d.span - 1 ) / d.span
+	padding := uint64(data[at+2])
+	d.blocksNum = uint64(binary.LittleEndian.Uint32(data[at+3:]))
+	d.blockLengthSize = d.blocksNum + padding
+	maxSymLen := int(data[at+7])
+	d.minSymLen = int(data[at+8])
+	at += 9
+	if ( maxSymLen < d.minSymLen ) || ( at+2*( maxSymLen-d.minSymLen+1 )+2 > len(data) ) {
+		return 0, corrupt
+	}
+
+	// canonical Huffman codes, longer codes have lower values
+	d.lowestSym = at
+	d.base64 = make([]uint64, maxSymLen-d.minSymLen+1)
+	for i := len(d.base64) - 2; i >= 0; i-- {
+		d.base64[i] = ( d.base64[i+1] + uint64(t.lowestSym(d, i)) - uint64(t.lowestSym(d, i+1)) ) / 2
+	}
+	for i := range d.base64 {
+		d.base64[i] <<= uint(64 - i - d.minSymLen)
+	}
+	at += 2 * len(d.base64)
+
+	// symbols expand recursively into pairs of symbols
+	d.symlen = make([]byte, binary.LittleEndian.Uint16(data[at:]))
+	at += 2
+	d.btree = at
+	if at+3*len(d.symlen) > len(data) {
+		return 0, corrupt
+	}
+	visited := make([]bool, len(d.symlen))
+	for sym := range d.symlen {
+		if !visited[sym] {
+			d.symlen[sym] = t.setSymlen(d, sym, visited)
+		}
+	}
+	return at + 3*len(d.symlen) + len(d.symlen)&1, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// setSymlen : computes the number of values minus one a symbol expands to
+// -> t *syzygyTable : table
+// -> d *syzygyPairs : part
+// -> sym int : symbol
+// -> visited []bool : symbols already computed
+// <- byte : number of values minus one
+
+func (t *syzygyTable) setSymlen(d *syzygyPairs, sym int, visited []bool) byte {
+	visited[sym] = true
+	left, right := t.pair(d, sym)
+	if right == 0xfff {
+		return 0
+	}
+	for _, s := range []int{left, right} {
+		if ( s < len(visited) ) && !visited[s] {
+			d.symlen[s] = t.setSymlen(d, s, visited)
+		}
+	}
+	if ( left >= len(d.symlen) ) || ( right >= len(d.symlen) ) {
+		return 0
+	}
+	return d.symlen[left] + d.symlen[right] + 1
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// pair : returns the symbols a symbol expands to, 12 bits each
+// a leaf has the value as left symbol and 0xfff as right symbol
+// -> t *syzygyTable : table
+// -> d *syzygyPairs : part
+// -> sym int : symbol
+// <- int : left symbol
+// <- int : right symbol
+
+func (t *syzygyTable) pair(d *syzygyPairs, sym int) (int, int) {
+	lr := t.data[d.btree+3*sym : d.btree+3*sym+3]
+	return int(lr[1]&0xf)<<8 | int(lr[0]), int(lr[2])<<4 | int(lr[1]>>4)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// lowestSym : returns the lowest symbol of a code length
+// -> t *syzygyTable : table
+// -> d *syzygyPairs : part
+// -> i int : code length minus the minimum code length
+// <- uint16 : symbol
+
+func (t *syzygyTable) lowestSym(d *syzygyPairs, i int) uint16 {
+	return binary.LittleEndian.Uint16(t.data[d.lowestSym+2*i:])
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// be32 : reads a big endian word of a block, zero past the end of the file
+// -> t *syzygyTable : table
+// -> at int : offset
+// <- uint64 : word
+
+func (t *syzygyTable) be32(at int) uint64 {
+	if at+4 > len(t.data) {
+		return 0
+	}
+	return uint64(binary.BigEndian.Uint32(t.data[at:]))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// decompress : returns the value stored at an index of a part
+// -> t *syzygyTable : table
+// -> d *syzygyPairs : part
+// -> idx uint64 : index
+// <- int : value
+
+func (t *syzygyTable) decompress(d *syzygyPairs, idx uint64) int {
+	if d.flags&syzygyFlagSingleValue != 0 {
+		return d.minSymLen
+	}
+
+	// the sparse index gives the block of a position close to idx
+	k := idx / d.span
+	entry := d.sparseIndex + 6*int(k)
+	block := int(binary.LittleEndian.Uint32(t.data[entry:]))
+	offset := int(binary.LittleEndian.Uint16(t.data[entry+4:]))
+	offset += int(idx%d.span) - int(d.span/2)
+
+	// each block stores its length plus one values
+	blockLength := func(b int) int {
+		return int(binary.LittleEndian.Uint16(t.data[d.blockLength+2*b:]))
+	}
+	for offset < 0 {
+		block--
+		offset += blockLength(block) + 1
+	}
+	for offset > blockLength(block) {
+		offset -= blockLength(block) + 1
+		block++
+	}
+
+	// walk the Huffman codes of the block up to the symbol containing the value
+	at := d.data + block*int(d.sizeofBlock)
+	buf := t.be32(at)<<32 | t.be32(at+4)
+	at += 8
+	bufSize := 64
+	sym := 0
+	for {
+		l := 0
+		for buf < d.base64[l] {
+			l++
+		}
+		sym = int(( buf-d.base64[l] ) >> uint(64-l-d.minSymLen))
+		sym += int(t.lowestSym(d, l))
+		if offset < int(d.symlen[sym])+1 {
+			break
+		}
+		offset -= int(d.symlen[sym]) + 1
+		l += d.minSymLen
+		buf <<= uint(l)
+		bufSize -= l
+		if bufSize <= 32 {
+			bufSize += 32
+			buf |= t.be32(at) << uint(64-bufSize)
+			at += 4
+		}
+	}
+
+	// expand the symbol down to the value
+	for d.symlen[sym] != 0 {
+		left, right := t.pair(d, sym)
+		if offset < int(d.symlen[left])+1 {
+			sym = left
+		} else {
+			offset -= int(d.symlen[left]) + 1
+			sym = right
+		}
+	}
+	left, _ := t.pair(d, sym)
+	return left
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// probeTable : returns the value a table stores for a position
+// for a WDL table the value is the result, for a DTZ table the distance to zeroing in plies
+// -> t *syzygyTable : table
+// -> pos *Position : position with the material of the table
+// -> wdl int : result of the position, only for DTZ tables
+// <- int : value
+// <- int : state, syzygyOk or syzygyChangeSTM
+
+func (t *syzygyTable) probeTable(pos *Position, wdl int) (int, int) {
+	d, idx, tbFile, ok := t.encode(pos)
+	if !ok {
+		return 0, syzygyChangeSTM
+	}
+	value := t.decompress(d, idx)
+	if !t.dtz {
+		return value - 2, syzygyOk
+	}
+	return t.mapScore(tbFile, value, wdl), syzygyOk
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// encode : returns the part and the index of a position
+// -> t *syzygyTable : table
+// -> pos *Position : position with the material of the table
+// <- *syzygyPairs : part
+// <- uint64 : index
+// <- int : file of the leading pawn
+// <- bool : false if a DTZ table stores the other side to move
+
+func (t *syzygyTable) encode(pos *Position) (*syzygyPairs, uint64, int, bool) {
+	var squares [SYZYGY_MAX_PIECES]Square
+	var pieces [SYZYGY_MAX_PIECES]byte
+	size, leadPawnsCnt := 0, 0
+	leadPawns := Bitboard(0)
+	tbFile := 0
+
+	// tables store the stronger side as White, and only White to move if both sides have the same pieces
+	sig := pos.MaterialSignature()
+	stm := 0
+	if pos.SideToMove == Black {
+		stm = 1
+	}
+	flip := ( t.key == t.key2 && stm == 1 ) || ( sig != t.key )
+	flipColor, flipSquares := byte(0), Square(0)
+	if flip {
+		flipColor, flipSquares = 8, 56
+		stm ^= 1
+	}
+
+	// the parts of tables with pawns are by file of the leading pawn
+	if t.hasPawns {
+		pc := t.pairs[0][0].pieces[0] ^ flipColor
+		col := White
+		if pc&8 != 0 {
+			col = Black
+		}
+		leadPawns = pos.ByPiece(col, Pawn)
+		for bb := leadPawns; bb != 0; {
+			squares[size] = bb.Pop() ^ flipSquares
+			size++
+		}
+		leadPawnsCnt = size
+		lead := 0
+		for i := 1; i < leadPawnsCnt; i++ {
+			if syzygyMapPawns[squares[i]] > syzygyMapPawns[squares[lead]] {
+				lead = i
+			}
+		}
+		squares[0], squares[lead] = squares[lead], squares[0]
+		if tbFile = squares[0].File(); tbFile > 3 {
+			tbFile = 7 - tbFile
+		}
+	}
+
+	// DTZ tables only store one side to move
+	if t.dtz {
+		flags := t.pairs[0][tbFile].flags
+		if ( int(flags&syzygyFlagSTM) != stm ) && !( ( t.key == t.key2 ) && !t.hasPawns ) {
+			return nil, 0, tbFile, false
+		}
+	}
+
+	for bb := ( pos.ByColor[White] | pos.ByColor[Black] ) &^ leadPawns; bb != 0; {
+		sq := bb.Pop()
+		pi := pos.Get(sq)
+		squares[size] = sq ^ flipSquares
+		pieces[size] = byte(pi.Figure()) ^ flipColor
+		if pi.Color() == Black {
+			pieces[size] ^= 8
+		}
+		size++
+	}
+
+	side := stm
+	if t.dtz {
+		side = 0
+	}
+	d := &t.pairs[side][tbFile]
+
+	// order the pieces as the table does
+	for i := leadPawnsCnt; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// the leading piece goes to the a1-d1-d4 triangle
+	if squares[0].File() > 3 {
+		for i := 0; i < size; i++ {
+			squares[i] ^= 7
+		}
+	}
+
+	idx := uint64(0)
+	if t.hasPawns {
+		idx = syzygyLeadPawnIdx[leadPawnsCnt][squares[0]]
+		syzygySortSquares(squares[1:leadPawnsCnt], func(a, b Square) bool {
+			return syzygyMapPawns[a] < syzygyMapPawns[b]
+		})
+		for i := 1; i < leadPawnsCnt; i++ {
+			idx += syzygyBinomial[i][syzygyMapPawns[squares[i]]]
+		}
+	} else {
+		if squares[0].Rank() > 3 {
+			for i := 0; i < size; i++ {
+				squares[i] ^= 56
+			}
+		}
+		// the first piece of the leading group off the diagonal goes below it
+		for i := 0; i < d.groupLen[0]; i++ {
+			if syzygyOffDiagonal(squares[i]) == 0 {
+				continue
+			}
+			if syzygyOffDiagonal(squares[i]) > 0 {
+				for j := i; j < size; j++ {
+					squares[j] = ( ( squares[j] >> 3 ) | ( squares[j] << 3 ) ) & 63
+				}
+			}
+			break
+		}
+		if t.hasUniquePieces {
+			adjust1 := uint64(0)
+			if squares[1] > squares[0] {
+				adjust1 = 1
+			}
+			adjust2 := uint64(0)
+			if squares[2] > squares[0] {
+				adjust2++
+			}
+			if squares[2] > squares[1] {
+				adjust2++
+			}
+			s0, s1, s2 := uint64(squares[0]), uint64(squares[1]), uint64(squares[2])
+			r0, r1, r2 := uint64(squares[0].Rank()), uint64(squares[1].Rank()), uint64(squares[2].Rank())
+			switch {
+				case syzygyOffDiagonal(squares[0]) != 0:
+					idx = ( uint64(syzygyMapA1D1D4[s0])*63 + s1 - adjust1 ) * 62 + s2 - adjust2
+				case syzygyOffDiagonal(squares[1]) != 0:
+					idx = ( 6*63 + r0*28 + uint64(syzygyMapB1H1H7[s1]) ) * 62 + s2 - adjust2
+				case syzygyOffDiagonal(squares[2]) != 0:
+					idx = 6*63*62 + 4*28*62 + r0*7*28 + ( r1-adjust1 )*28 + uint64(syzygyMapB1H1H7[s2])
+				default:
+					idx = 6*63*62 + 4*28*62 + 4*7*28 + r0*7*6 + ( r1-adjust1 )*6 + ( r2 - adjust2 )
+			}
+		} else {
+			idx = uint64(syzygyMapKK[t.connectedKings()][syzygyMapA1D1D4[squares[0]]][squares[1]])
+		}
+	}
+
+	// the remaining groups by ascending squares, skipping the squares of the previous groups
+	idx *= d.groupIdx[0]
+	start := d.groupLen[0]
+	remainingPawns := t.hasPawns && ( t.pawnCount[1] > 0 )
+	for next := 1; d.groupLen[next] != 0; next++ {
+		group := squares[start : start+d.groupLen[next]]
+		syzygySortSquares(group, func(a, b Square) bool { return a < b })
+		n := uint64(0)
+		for i, sq := range group {
+			adjust := 0
+			for _, prev := range squares[:start] {
+				if sq > prev {
+					adjust++
+				}
+			}
+			if remainingPawns {
+				adjust += 8
+			}
+			n += syzygyBinomial[i+1][int(sq)-adjust]
+		}
+		remainingPawns = false
+		idx += n * d.groupIdx[next]
+		start += d.groupLen[next]
+	}
+
+	return d, idx, tbFile, true
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// mapScore : converts a stored DTZ value to plies
+// -> t *syzygyTable : DTZ table
+// -> f int : file of the leading pawn
+// -> value int : stored value
+// -> wdl int : result of the position
+// <- int : distance to zeroing in plies
+
+func (t *syzygyTable) mapScore(f, value, wdl int) int {
+	d := &t.pairs[0][f]
+	if d.flags&syzygyFlagMapped != 0 {
+		m := d.mapIdx[[5]int{1, 3, 0, 2, 0}[wdl+2]] + value
+		if d.flags&syzygyFlagWide != 0 {
+			value = int(binary.LittleEndian.Uint16(t.data[t.mapAt+2*m:]))
+		} else {
+			value = int(t.data[t.mapAt+m])
+		}
+	}
+	// values are in moves unless the table tells otherwise
+	if ( ( wdl == SyzygyWin ) && ( d.flags&syzygyFlagWinPlies == 0 ) ) ||
+		( ( wdl == SyzygyLoss ) && ( d.flags&syzygyFlagLossPlies == 0 ) ) ||
+		( wdl == SyzygyCursedWin ) || ( wdl == SyzygyBlessedLoss ) {
+		value *= 2
+	}
+	return value + 1
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygySortSquares : sorts a few squares keeping the order of equal ones
+// -> squares []Square : squares
+// -> less func(a, b Square) bool : order
+
+func syzygySortSquares(squares []Square, less func(a, b Square) bool) {
+	for i := 1; i < len(squares); i++ {
+		for j := i; ( j > 0 ) && less(squares[j], squares[j-1]); j-- {
+			squares[j], squares[j-1] = squares[j-1], squares[j]
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyProbeTable : probes the table of the material of a position
+// -> pos *Position : position
+// -> dtz bool : true for the DTZ table
+// -> wdl int : result of the position, only for DTZ tables
+// <- int : value
+// <- int : state
+
+func syzygyProbeTable(pos *Position, dtz bool, wdl int) (int, int) {
+	if ( pos.ByColor[White] | pos.ByColor[Black] ).Count() == 2 {
+		// bare kings
+		return SyzygyDraw, syzygyOk
+	}
+	tables := syzygyTables[Variant]
+	if tables == nil {
+		return 0, syzygyFail
+	}
+	kind := 0
+	if dtz {
+		kind = 1
+	}
+	t, found := tables[kind][pos.MaterialSignature()]
+	if !found || ( t.open() != nil ) {
+		return 0, syzygyFail
+	}
+	return t.probeTable(pos, wdl)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyTerminal : returns the result of a position decided by the material on the board
+// -> pos *Position : position
+// <- int : result for the side to move
+// <- bool : true if the game ended
+
+func syzygyTerminal(pos *Position) (int, bool) {
+	winner, over := pos.Terminal()
+	switch {
+		case !over: return 0, false
+		case winner == NoColor: return SyzygyDraw, true
+		case winner == pos.SideToMove: return SyzygyWin, true
+		default: return SyzygyLoss, true
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygySearch : probes a position and its captures
+// tables store "don't care" values where a capture is the best move, so the captures
+// and, for DTZ, the pawn moves are searched as well
+// -> pos *Position : position
+// -> zeroing bool : search pawn moves as well
+// <- int : result for the side to move
+// <- int : state
+
+func syzygySearch(pos *Position, zeroing bool) (int, int) {
+	if result, over := syzygyTerminal(pos); over {
+		return result, syzygyZeroingBestMove
+	}
+
+	moves := pos.GetLegalMoves(GET_ALL)
+	best, searched := SyzygyLoss, 0
+	for _, move := range moves {
+		if ( move.Capture() == NoPiece ) && ( !zeroing || ( move.Piece().Figure() != Pawn ) ) {
+			continue
+		}
+		searched++
+		pos.DoMove(move)
+		value, state := syzygySearch(pos, false)
+		pos.UndoMove()
+		if state == syzygyFail {
+			return SyzygyDraw, syzygyFail
+		}
+		if -value > best {
+			best = -value
+			if best >= SyzygyWin {
+				return best, syzygyZeroingBestMove
+			}
+		}
+	}
+
+	// the stored value of a position having only captures may be wrong
+	noMoreMoves := ( searched > 0 ) && ( searched == len(moves) )
+	value := best
+	if !noMoreMoves {
+		var state int
+		if value, state = syzygyProbeTable(pos, false, 0); state == syzygyFail {
+			return SyzygyDraw, syzygyFail
+		}
+	}
+	if best >= value {
+		if ( best > SyzygyDraw ) || noMoreMoves {
+			return best, syzygyZeroingBestMove
+		}
+		return best, syzygyOk
+	}
+	return value, syzygyOk
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyDTZBeforeZeroing : returns the distance to zeroing of a result reached by a zeroing move
+// -> wdl int : result
+// <- int : distance to zeroing
+
+func syzygyDTZBeforeZeroing(wdl int) int {
+	return [5]int{-1, -101, 0, 101, 1}[wdl+2]
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygySign : returns the sign of a value
+// -> v int : value
+// <- int : -1, 0 or 1
+
+func syzygySign(v int) int {
+	switch {
+		case v > 0: return 1
+		case v < 0: return -1
+	}
+	return 0
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyMated : tells whether the side to move lost on the board
+// -> pos *Position : position
+// <- bool : true if mated
+
+func syzygyMated(pos *Position) bool {
+	if result, over := syzygyTerminal(pos); over {
+		return result == SyzygyLoss
+	}
+	return pos.IsChecked(pos.SideToMove) && !pos.HasLegalMoves()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyCovers : tells whether a position may be in the tables of the current variant
+// -> pos *Position : position
+// <- bool : true if covered
+
+func syzygyCovers(pos *Position) bool {
+	limit := syzygyMaxPieces[Variant]
+	return ( limit > 0 ) && ( pos.CastlingAbility() == NoCastle ) &&
+		( int(( pos.ByColor[White] | pos.ByColor[Black] ).Count()) <= limit )
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ProbeSyzygyWDL : returns the result of a position from the WDL tables
+// -> pos *Position : position
+// <- int : result for the side to move, SyzygyLoss to SyzygyWin
+// <- bool : true if the tables cover the position
+
+func ProbeSyzygyWDL(pos *Position) (int, bool) {
+	if !syzygyCovers(pos) {
+		return SyzygyDraw, false
+	}
+	wdl, state := syzygySearch(pos, false)
+	return wdl, state != syzygyFail
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ProbeSyzygyDTZ : returns the distance to zeroing of a position from the DTZ tables
+// the distance is in plies, positive for a win, negative for a loss, 0 for a draw
+// results decided by the fifty move rule are off by 100
+// -> pos *Position : position
+// <- int : distance to zeroing
+// <- bool : true if the tables cover the position
+
+func ProbeSyzygyDTZ(pos *Position) (int, bool) {
+	if !syzygyCovers(pos) {
+		return 0, false
+	}
+	dtz, state := syzygyProbeDTZ(pos)
+	return dtz, state != syzygyFail
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyProbeDTZ : returns the distance to zeroing of a position
+// -> pos *Position : position
+// <- int : distance to zeroing
+// <- int : state
+
+func syzygyProbeDTZ(pos *Position) (int, int) {
+	if result, over := syzygyTerminal(pos); over {
+		return syzygySign(result), syzygyOk
+	}
+	wdl, state := syzygySearch(pos, true)
+	if ( state == syzygyFail ) || ( wdl == SyzygyDraw ) {
+		return 0, state
+	}
+	if state == syzygyZeroingBestMove {
+		return syzygyDTZBeforeZeroing(wdl), syzygyOk
+	}
+
+	dtz, state := syzygyProbeTable(pos, true, wdl)
+	if state == syzygyFail {
+		return 0, state
+	}
+	if state != syzygyChangeSTM {
+		if ( wdl == SyzygyBlessedLoss ) || ( wdl == SyzygyCursedWin ) {
+			dtz += 100
+		}
+		return dtz * syzygySign(wdl), syzygyOk
+	}
+
+	// the table stores the other side to move, find the best move one ply deeper
+	minDTZ := 0xffff
+	for _, move := range pos.GetLegalMoves(GET_ALL) {
+		zeroing := ( move.Capture() != NoPiece ) || ( move.Piece().Figure() == Pawn )
+		pos.DoMove(move)
+		if zeroing {
+			var v int
+			v, state = syzygySearch(pos, false)
+			dtz = -syzygyDTZBeforeZeroing(v)
+		} else {
+			dtz, state = syzygyProbeDTZ(pos)
+			dtz = -dtz
+		}
+		if ( dtz == 1 ) && syzygyMated(pos) {
+			minDTZ = 1
+		}
+		if !zeroing {
+			dtz += syzygySign(dtz)
+		}
+		if ( dtz < minDTZ ) && ( syzygySign(dtz) == syzygySign(wdl) ) {
+			minDTZ = dtz
+		}
+		pos.UndoMove()
+		if state == syzygyFail {
+			return 0, state
+		}
+	}
+	if minDTZ == 0xffff {
+		// no legal moves, mated
+		return -1, syzygyOk
+	}
+	return minDTZ, syzygyOk
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ProbeSyzygyRoot : returns the legal moves that do not keep the best result of a position
+// with the DTZ tables a winning side keeps the moves that win within the fifty move rule,
+// or the fastest ones if the position repeated, and a losing side resists as long as possible
+// without them the moves keeping the best WDL result are kept
+// -> pos *Position : position
+// <- []Move : moves to exclude
+// <- bool : true if the tables cover all moves
+
+func ProbeSyzygyRoot(pos *Position) ([]Move, bool) {
+	if !syzygyCovers(pos) {
+		return nil, false
+	}
+	if _, over := pos.Terminal(); over {
+		return nil, false
+	}
+	moves := pos.GetLegalMoves(GET_ALL)
+	if len(moves) == 0 {
+		return nil, false
+	}
+
+	// distance to zeroing counted from the root
+	values := make([]int, len(moves))
+	for i, move := range moves {
+		pos.DoMove(move)
+		v, state := 0, syzygyOk
+		if syzygyMated(pos) {
+			v = 1
+		} else if pos.HalfmoveClock() == 0 {
+			var wdl int
+			wdl, state = syzygySearch(pos, false)
+			v = syzygyDTZBeforeZeroing(-wdl)
+		} else {
+			v, state = syzygyProbeDTZ(pos)
+			v = -v
+			v += syzygySign(v)
+		}
+		pos.UndoMove()
+		if state == syzygyFail {
+			// DTZ tables missing
+			return ProbeSyzygyRootWDL(pos)
+		}
+		values[i] = v
+	}
+
+	// the fastest win, else a draw, else the longest loss
+	best, win, draw := 0, false, false
+	for _, v := range values {
+		switch {
+			case v > 0:
+				if !win || ( v < best ) {
+					best = v
+				}
+				win = true
+			case v == 0: draw = true
+			case !win && !draw && ( v < best ): best = v
+		}
+	}
+	keep := func(v int) bool { return v == 0 }
+	cnt50 := pos.HalfmoveClock()
+	if win {
+		limit := best
+		if ( pos.ThreeFoldRepetition() < 2 ) && ( best+cnt50 <= 99 ) {
+			limit = 99 - cnt50
+		}
+		keep = func(v int) bool { return ( v > 0 ) && ( v <= limit ) }
+	} else if !draw {
+		if -best*2+cnt50 < 100 {
+			// all moves lose before the fifty move rule
+			return nil, true
+		}
+		keep = func(v int) bool { return v == best }
+	}
+
+	excluded := []Move{}
+	for i, move := range moves {
+		if !keep(values[i]) {
+			excluded = append(excluded, move)
+		}
+	}
+	return excluded, true
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ProbeSyzygyRootWDL : returns the legal moves that do not keep the best WDL result of a position
+// -> pos *Position : position
+// <- []Move : moves to exclude
+// <- bool : true if the tables cover all moves
+
+func ProbeSyzygyRootWDL(pos *Position) ([]Move, bool) {
+	if !syzygyCovers(pos) {
+		return nil, false
+	}
+	if _, over := pos.Terminal(); over {
+		return nil, false
+	}
+	moves := pos.GetLegalMoves(GET_ALL)
+	values := make([]int, len(moves))
+	best := SyzygyLoss
+	for i, move := range moves {
+		pos.DoMove(move)
+		wdl, ok := ProbeSyzygyWDL(pos)
+		pos.UndoMove()
+		if !ok {
+			return nil, false
+		}
+		values[i] = -wdl
+		if values[i] > best {
+			best = values[i]
+		}
+	}
+	excluded := []Move{}
+	for i, move := range moves {
+		if values[i] < best {
+			excluded = append(excluded, move)
+		}
+	}
+	return excluded, true
+}
+
+///////////////////////////////////////////////
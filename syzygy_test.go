@@ -0,0 +1,635 @@
+//////////////////////////////////////////////////////
+// syzygy_test.go
+// tests probing of Syzygy WDL and DTZ tablebases on positions of known result
+// the tables of testdata/syzygy are written from the retrograde solver of tablebase.go,
+// SYZYGY_GENERATE=1 rewrites them and SYZYGY_PATH probes other tables instead
+// the probe tests are skipped without tables, except in CI where missing tables fail
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// syzygyTestCase : a position of known result
+type syzygyTestCase struct {
+	variant int    // variant
+	code    string // table needed
+	fen     string // position
+	wdl     int    // result for the side to move
+	dtz     int    // distance to zeroing, 0 to check the sign only
+}
+
+var syzygyTestCases = []syzygyTestCase{
+	// Qg8 mates
+	{VARIANT_Standard, "KQvK", "k7/8/1K6/8/8/8/8/6Q1 w - - 0 1", SyzygyWin, 1},
+	// Rh8 mates
+	{VARIANT_Standard, "KRvK", "k7/8/1K6/8/8/8/8/7R w - - 0 1", SyzygyWin, 1},
+	// the same position with Black to move is lost
+	{VARIANT_Standard, "KRvK", "k7/8/1K6/8/8/8/8/7R b - - 0 1", SyzygyLoss, 0},
+	// the pawn runs, e4 zeroes
+	{VARIANT_Standard, "KPvK", "8/8/8/8/8/8/4P3/4K2k w - - 0 1", SyzygyWin, 1},
+	// the king in front of a rook pawn holds
+	{VARIANT_Standard, "KPvK", "k7/8/8/8/8/8/P7/K7 b - - 0 1", SyzygyDraw, 0},
+	// Qb7 mates in atomic, the queen is safe next to the king
+	{VARIANT_Atomic, "KQvK", "k7/8/8/8/8/8/8/1Q2K3 w - - 0 1", SyzygyWin, 1},
+	// the king escapes next to the other king where checks are impossible, Qg8 is no mate
+	{VARIANT_Atomic, "KQvK", "k7/8/1K6/8/8/8/8/6Q1 w - - 0 1", SyzygyDraw, 0},
+	// a lone knight cannot explode the king
+	{VARIANT_Atomic, "KNvK", "k7/8/1K6/8/8/8/8/7N w - - 0 1", SyzygyDraw, 0},
+}
+
+// syzygyTestTable : a table of testdata/syzygy
+type syzygyTestTable struct {
+	variant int    // variant
+	code    string // material configuration
+}
+
+// tables of testdata/syzygy, the promotions of KPvK lead to KQvK, KRvK or insufficient material
+var syzygyTestTables = []syzygyTestTable{
+	{VARIANT_Standard, "KQvK"},
+	{VARIANT_Standard, "KRvK"},
+	{VARIANT_Standard, "KPvK"},
+	{VARIANT_Atomic, "KQvK"},
+	{VARIANT_Atomic, "KNvK"},
+}
+
+// directory of the test tables
+const SYZYGY_TEST_DIR = "testdata/syzygy"
+
+// block and span sizes of the written tables, as powers of two
+const(
+	syzygyTestBlockBits = 6
+	syzygyTestSpanBits  = 10
+)
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyMissing : skips a test needing tables that are not installed
+// the CI environment variable turns the skip into a failure, so that CI cannot pass without probing
+// -> t *testing.T : test
+// -> format string : reason
+// -> args ...interface{} : arguments of the reason
+
+func syzygyMissing(t *testing.T, format string, args ...interface{}) {
+	t.Helper()
+	if os.Getenv("CI") != "" {
+		t.Fatalf(format, args...)
+	}
+	t.Skipf(format, args...)
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyTestPath : returns the directories of the test tables
+// -> t *testing.T : test
+// <- string : directories
+
+func syzygyTestPath(t *testing.T) string {
+	path := os.Getenv("SYZYGY_PATH")
+	if path == "" {
+		path = SYZYGY_TEST_DIR
+	}
+	if _, err := os.Stat(path); ( path == SYZYGY_TEST_DIR ) && ( err != nil ) {
+		syzygyMissing(t, "no Syzygy tables, set SYZYGY_PATH")
+	}
+	return path
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestSyzygyProbe : probes positions of known result
+
+func TestSyzygyProbe(t *testing.T) {
+	path := syzygyTestPath(t)
+	uci = NewUCI()
+	defer LoadSyzygy("")
+	for _, tc := range syzygyTestCases {
+		t.Run(VARIANT_TO_NAME[tc.variant]+" "+tc.fen, func(t *testing.T) {
+			uci.SetVariant(tc.variant)
+			if _, err := LoadSyzygy(path); err != nil {
+				t.Fatal(err)
+			}
+			pos, err := PositionFromFEN(tc.fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wdl, ok := ProbeSyzygyWDL(pos)
+			if !ok {
+				syzygyMissing(t, "no %s table in %s", tc.code, path)
+			}
+			if wdl != tc.wdl {
+				t.Errorf("wdl %d, expected %d", wdl, tc.wdl)
+			}
+			dtz, ok := ProbeSyzygyDTZ(pos)
+			if !ok {
+				syzygyMissing(t, "no %s DTZ table in %s", tc.code, path)
+			}
+			switch {
+				case ( tc.dtz != 0 ) && ( dtz != tc.dtz ):
+					t.Errorf("dtz %d, expected %d", dtz, tc.dtz)
+				case ( tc.wdl > 0 ) && ( dtz <= 0 ), ( tc.wdl < 0 ) && ( dtz >= 0 ), ( tc.wdl == 0 ) && ( dtz != 0 ):
+					t.Errorf("dtz %d does not match wdl %d", dtz, tc.wdl)
+			}
+			if fen := pos.String(); fen != tc.fen {
+				t.Errorf("probing changed the position to %s", fen)
+			}
+		})
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestSyzygyLoad : registers table files by name and checks that files which are not
+// tables are not probed
+
+func TestSyzygyLoad(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"KQvK.rtbw", "KQvK.rtbz", "KRvK.rtbw", "KNvK.atbw", "notes.rtbw"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("not a table"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	defer LoadSyzygy("")
+	num, err := LoadSyzygy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 3 {
+		t.Errorf("%d WDL tables registered, expected 3", num)
+	}
+	if syzygyMaxPieces[VARIANT_Standard] != 3 {
+		t.Errorf("largest standard table has %d pieces, expected 3", syzygyMaxPieces[VARIANT_Standard])
+	}
+	pos, err := PositionFromFEN("k7/8/1K6/8/8/8/8/6Q1 w - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ProbeSyzygyWDL(pos); ok {
+		t.Errorf("probed a file that is not a table")
+	}
+	if _, err := LoadSyzygy(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("missing directory loaded without error")
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyTestSolve : generates a table with the retrograde solver and computes the distance
+// to zeroing of its positions
+// a win in n plies zeroes now or moves to a loss in n-1 plies, a loss in n plies
+// only has moves to wins in at most n-1 plies, the layers are resolved by increasing n
+// -> t *testing.T : test
+// -> variant int : variant
+// -> code string : material configuration
+// <- *Tablebase : table
+// <- []int : distance to zeroing in plies by index, 0 if mated, -1 for draws and illegal positions
+
+func syzygyTestSolve(t *testing.T, variant int, code string) (*Tablebase, []int) {
+	t.Helper()
+	uci = NewUCI()
+	uci.SetVariant(variant)
+	tables, err := GenerateTablebase(code, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tb *Tablebase
+	bySig := map[uint64]*Tablebase{}
+	for _, table := range tables {
+		bySig[table.sig] = table
+		if table.Code == code {
+			tb = table
+		}
+	}
+	if tb == nil {
+		t.Fatalf("%s %s: table not generated", VARIANT_TO_NAME[variant], code)
+	}
+
+	// lost tells whether the side to move lost, after a zeroing move
+	lost := func(pos *Position) bool {
+		if winner, over := pos.Terminal(); over {
+			return winner == pos.SideToMove.Opposite()
+		}
+		table, found := bySig[pos.MaterialSignature()]
+		if !found {
+			t.Fatalf("%s: no table for %s", code, pos.String())
+		}
+		result, ok := table.Probe(pos)
+		return ok && ( result.Winner == pos.SideToMove.Opposite() )
+	}
+	win := func(i int) bool {
+		return ( tb.data[i] != tbDraw ) && ( tb.data[i] != tbInvalid ) && ( ( tb.data[i] - 1 )%2 == 1 )
+	}
+	loss := func(i int) bool {
+		return ( tb.data[i] != tbDraw ) && ( tb.data[i] != tbInvalid ) && ( ( tb.data[i] - 1 )%2 == 0 )
+	}
+
+	// for a win, whether a zeroing move wins, for a loss whether it has a zeroing move
+	// the other moves by index of the position they lead to
+	size := tb.size()
+	dtz := make([]int, size)
+	zeroing := make([]bool, size)
+	children := make([][]int32, size)
+	unresolved := 0
+	for i := range dtz {
+		dtz[i] = -1
+		if !win(i) && !loss(i) {
+			continue
+		}
+		if tb.data[i] == 1 {
+			dtz[i] = 0
+			continue
+		}
+		unresolved++
+		pos := tb.position(i)
+		for _, m := range pos.GetLegalMoves(GET_ALL) {
+			pos.DoMove(m)
+			if ( m.Capture() != NoPiece ) || ( m.Piece().Figure() == Pawn ) {
+				zeroing[i] = zeroing[i] || loss(i) || lost(pos)
+			} else if j := tb.index(pos); loss(i) || loss(j) {
+				children[i] = append(children[i], int32(j))
+			}
+			pos.UndoMove()
+		}
+	}
+
+	for n, changed := 1, true; changed; n++ {
+		changed = false
+		for i := range dtz {
+			if dtz[i] >= 0 {
+				continue
+			}
+			if win(i) {
+				found := zeroing[i] && ( n == 1 )
+				for _, j := range children[i] {
+					found = found || ( dtz[j] == n-1 )
+				}
+				if found {
+					dtz[i] = n
+					changed = true
+				}
+			} else if loss(i) {
+				longest := 0
+				if zeroing[i] {
+					longest = 1
+				}
+				for _, j := range children[i] {
+					if ( dtz[j] < 0 ) || ( dtz[j] >= n ) {
+						longest = -1
+						break
+					}
+					if dtz[j]+1 > longest {
+						longest = dtz[j] + 1
+					}
+				}
+				if longest == n {
+					dtz[i] = n
+					changed = true
+				}
+			}
+			if changed && ( dtz[i] == n ) {
+				unresolved--
+			}
+		}
+	}
+	if unresolved != 0 {
+		t.Fatalf("%s %s: %d positions without a distance to zeroing", VARIANT_TO_NAME[variant], code, unresolved)
+	}
+	return tb, dtz
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyTestLayout : creates a table of the current variant with its piece order and groups
+// the leading pawns come first, the other pieces in the order of the configuration
+// -> t *testing.T : test
+// -> code string : material configuration, White's pieces first
+// -> dtz bool : true for a DTZ table
+// <- *syzygyTable : table without data
+
+func syzygyTestLayout(t *testing.T, code string, dtz bool) *syzygyTable {
+	t.Helper()
+	table, err := newSyzygyTable(Variant, code, "", dtz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(code, "v")
+	lead := byte(Pawn)
+	if strings.Count(parts[0], "P") != table.pawnCount[0] {
+		lead |= 8
+	}
+	pieces := []byte{}
+	for i, part := range parts {
+		for _, symbol := range part {
+			pc := byte(symbolToFigure[symbol])
+			if i == 1 {
+				pc |= 8
+			}
+			if pc == lead {
+				pieces = append([]byte{pc}, pieces...)
+			} else {
+				pieces = append(pieces, pc)
+			}
+		}
+	}
+	order := [2]int{0, 0xf}
+	if table.hasPawns && ( table.pawnCount[1] > 0 ) {
+		order[1] = 1
+	}
+	for i := range table.pairs {
+		for f := range table.pairs[i] {
+			copy(table.pairs[i][f].pieces[:], pieces)
+			table.setGroups(&table.pairs[i][f], order, f)
+		}
+	}
+	return table
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyTestCompress : compresses the values of a part with codes of a single length
+// each value is a leaf symbol, values that are never probed are stored as the first symbol
+// -> values []int : values by index, -1 if never probed
+// -> flags byte : flags of the part
+// <- []byte : compression header
+// <- []byte : sparse index
+// <- []byte : block lengths
+// <- []byte : blocks
+
+func syzygyTestCompress(values []int, flags byte) ([]byte, []byte, []byte, []byte) {
+	symbols, syms := []int{}, map[int]int{}
+	for _, v := range values {
+		if _, found := syms[v]; !found && ( v >= 0 ) {
+			syms[v] = len(symbols)
+			symbols = append(symbols, v)
+		}
+	}
+	if len(symbols) <= 1 {
+		value := 0
+		if len(symbols) == 1 {
+			value = symbols[0]
+		}
+		return []byte{flags | syzygyFlagSingleValue, byte(value)}, nil, nil, nil
+	}
+
+	bits := 1
+	for 1<<bits < len(symbols) {
+		bits++
+	}
+	perBlock := ( 8 << syzygyTestBlockBits ) / bits
+	blocksNum := ( len(values) + perBlock - 1 ) / perBlock
+
+	// the lowest symbol of the only code length is 0
+	header := []byte{flags, syzygyTestBlockBits, syzygyTestSpanBits, 0, 0, 0, 0, 0, byte(bits), byte(bits), 0, 0}
+	binary.LittleEndian.PutUint32(header[4:], uint32(blocksNum))
+	header = binary.LittleEndian.AppendUint16(header, uint16(len(symbols)))
+	for _, v := range symbols {
+		header = append(header, byte(v), byte(v>>8)&0xf|0xf0, 0xff)
+	}
+	if len(symbols)&1 != 0 {
+		header = append(header, 0)
+	}
+
+	// the middle of each span, past the end the offset runs over the last block
+	sparse := []byte{}
+	span := 1 << syzygyTestSpanBits
+	for k := 0; k*span < len(values); k++ {
+		at := k*span + span/2
+		last := at
+		if last >= len(values) {
+			last = len(values) - 1
+		}
+		sparse = binary.LittleEndian.AppendUint32(sparse, uint32(last/perBlock))
+		sparse = binary.LittleEndian.AppendUint16(sparse, uint16(last%perBlock + at - last))
+	}
+
+	lengths := []byte{}
+	for b := 0; b < blocksNum; b++ {
+		n := len(values) - b*perBlock
+		if n > perBlock {
+			n = perBlock
+		}
+		lengths = binary.LittleEndian.AppendUint16(lengths, uint16(n-1))
+	}
+
+	// codes are read from the most significant bit
+	blocks := make([]byte, blocksNum<<syzygyTestBlockBits)
+	for i, v := range values {
+		sym := 0
+		if v >= 0 {
+			sym = syms[v]
+		}
+		at := ( i/perBlock )<<syzygyTestBlockBits*8 + ( i%perBlock )*bits
+		for k := 0; k < bits; k++ {
+			if sym>>( bits-1-k )&1 != 0 {
+				blocks[( at+k )/8] |= 0x80 >> ( ( at+k )%8 )
+			}
+		}
+	}
+	return header, sparse, lengths, blocks
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// syzygyTestFile : encodes the WDL or DTZ file of a solved table
+// the DTZ file stores White to move, wins in moves and losses in plies
+// -> t *testing.T : test
+// -> tb *Tablebase : solved table
+// -> dtz []int : distance to zeroing by index of the table
+// -> isDTZ bool : true for the DTZ file
+// <- []byte : file
+
+func syzygyTestFile(t *testing.T, tb *Tablebase, dtz []int, isDTZ bool) []byte {
+	t.Helper()
+	table := syzygyTestLayout(t, tb.Code, isDTZ)
+	sides, files := 1, 1
+	if !isDTZ && ( table.key != table.key2 ) {
+		sides = 2
+	}
+	if table.hasPawns {
+		files = 4
+	}
+
+	// values of the parts by index, -1 if never probed
+	var values [2][4][]int
+	var flags [2][4]byte
+	for i := 0; i < sides; i++ {
+		for f := 0; f < files; f++ {
+			d := &table.pairs[i][f]
+			n := 0
+			for d.groupLen[n] != 0 {
+				n++
+			}
+			values[i][f] = make([]int, d.groupIdx[n])
+			for k := range values[i][f] {
+				values[i][f][k] = -1
+			}
+		}
+	}
+	for i := 0; i < tb.size(); i++ {
+		v := tb.data[i]
+		if v == tbInvalid {
+			continue
+		}
+		pos := tb.position(i)
+		d, idx, f, ok := table.encode(pos)
+		if !ok {
+			continue
+		}
+		side := 0
+		if d == &table.pairs[1][f] {
+			side = 1
+		}
+		value := -1
+		switch {
+			case !isDTZ && ( v == tbDraw ): value = SyzygyDraw + 2
+			case !isDTZ && ( ( v - 1 )%2 == 1 ): value = SyzygyWin + 2
+			case !isDTZ: value = SyzygyLoss + 2
+			case dtz[i] > 100:
+				t.Fatalf("%s: %s is decided by the fifty move rule", tb.Code, pos.String())
+			case ( v == tbDraw ) || ( dtz[i] == 0 ):
+			case ( v - 1 )%2 == 1: value = ( dtz[i] - 1 ) / 2
+			default:
+				value = dtz[i] - 1
+				flags[side][f] |= syzygyFlagLossPlies
+		}
+		if old := values[side][f][idx]; ( old >= 0 ) && ( value >= 0 ) && ( old != value ) {
+			t.Fatalf("%s: %s stores %d at index %d, another position %d", tb.Code, pos.String(), value, idx, old)
+		}
+		if value >= 0 {
+			values[side][f][idx] = value
+		}
+	}
+
+	kind := 0
+	if isDTZ {
+		kind = 1
+	}
+	magic := SYZYGY_FORMATS[Variant].Magic[kind]
+	data := append([]byte{}, magic[:]...)
+	data = append(data, byte(sides-1))
+	if table.hasPawns {
+		data[4] |= 2
+	}
+	for f := 0; f < files; f++ {
+		data = append(data, 0)
+		if table.hasPawns && ( table.pawnCount[1] > 0 ) {
+			data = append(data, 0x11)
+		}
+		for k := 0; k < table.pieceCount; k++ {
+			data = append(data, table.pairs[0][f].pieces[k]|table.pairs[1][f].pieces[k]<<4)
+		}
+	}
+	data = append(data, make([]byte, len(data)&1)...)
+
+	sparse, lengths, blocks := [][]byte{}, [][]byte{}, [][]byte{}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			header, s, l, b := syzygyTestCompress(values[i][f], flags[i][f])
+			data = append(data, header...)
+			sparse, lengths, blocks = append(sparse, s), append(lengths, l), append(blocks, b)
+		}
+	}
+	if isDTZ {
+		data = append(data, make([]byte, len(data)&1)...)
+	}
+	for _, s := range sparse {
+		data = append(data, s...)
+	}
+	for _, l := range lengths {
+		data = append(data, l...)
+	}
+	for _, b := range blocks {
+		data = append(data, make([]byte, -len(data)&0x3f)...)
+		data = append(data, b...)
+	}
+	return data
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestSyzygyWriteTables : rewrites the tables of testdata/syzygy when SYZYGY_GENERATE is set
+
+func TestSyzygyWriteTables(t *testing.T) {
+	if os.Getenv("SYZYGY_GENERATE") == "" {
+		t.Skip("set SYZYGY_GENERATE to rewrite the test tables")
+	}
+	if err := os.MkdirAll(SYZYGY_TEST_DIR, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range syzygyTestTables {
+		tb, dtz := syzygyTestSolve(t, tc.variant, tc.code)
+		for kind, suffix := range SYZYGY_FORMATS[tc.variant].Suffix {
+			data := syzygyTestFile(t, tb, dtz, kind == 1)
+			if err := os.WriteFile(filepath.Join(SYZYGY_TEST_DIR, tc.code+suffix), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestSyzygyTables : the tables of testdata/syzygy give the results of the retrograde solver
+// the index encoding is shared with the prober, tables of other generators need SYZYGY_PATH
+
+func TestSyzygyTables(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates tables")
+	}
+	defer LoadSyzygy("")
+	for _, tc := range syzygyTestTables {
+		tb, dtz := syzygyTestSolve(t, tc.variant, tc.code)
+		if _, err := LoadSyzygy(SYZYGY_TEST_DIR); err != nil {
+			t.Fatal(err)
+		}
+		errors := 0
+		for i := 0; ( i < tb.size() ) && ( errors < 10 ); i++ {
+			v := tb.data[i]
+			if v == tbInvalid {
+				continue
+			}
+			wdl, plies := SyzygyDraw, 0
+			switch {
+				case v == tbDraw:
+				case ( v - 1 )%2 == 1: wdl, plies = SyzygyWin, dtz[i]
+				case dtz[i] == 0: wdl, plies = SyzygyLoss, -1
+				default: wdl, plies = SyzygyLoss, -dtz[i]
+			}
+			pos := tb.position(i)
+			name := VARIANT_TO_NAME[tc.variant] + " " + pos.String()
+			if got, ok := ProbeSyzygyWDL(pos); !ok || ( got != wdl ) {
+				t.Errorf("%s: wdl %d, expected %d", name, got, wdl)
+				errors++
+			}
+			if got, ok := ProbeSyzygyDTZ(pos); !ok || ( got != plies ) {
+				t.Errorf("%s: dtz %d, expected %d", name, got, plies)
+				errors++
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////
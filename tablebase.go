@@ -0,0 +1,996 @@
+//////////////////////////////////////////////////////
+// tablebase.go
+// implements the generation and probing of endgame tablebases for all variants
+// tables are built by retrograde analysis, one pass per distance, using the move generator
+// so that the rules of each variant are followed without variant specific code
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// TABLEBASE_MAGIC identifies tablebase files
+const TABLEBASE_MAGIC = "VTB2"
+
+// maximum number of pieces, kings included, of a table
+const TABLEBASE_MAX_PIECES = 5
+
+// longest distance to the end of the game a table can store
+const TABLEBASE_MAX_PLIES = 253
+
+// tablebase file names of the variants
+var TABLEBASE_VARIANT_NAMES = [...]string{"standard", "racingkings", "atomic", "horde"}
+
+// stored values, a byte per position
+// 0 is a draw, p+1 is the end of the game in p plies, odd p is a win for the side to move, even p a loss
+const(
+	tbDraw    byte = 0
+	tbInvalid byte = 255
+)
+
+// values during the generation
+const(
+	tbUnknown uint16 = 0xffff
+	tbDrawn   uint16 = 0xfffe
+	tbIllegal uint16 = 0xfffd
+)
+
+// symmetries of the board a table is reduced by
+// the kings are moved to a canonical region, the rest follows
+const(
+	tbSymmetryNone   = iota // no king
+	tbSymmetryMirror        // the a-h mirror, for pawns and the Racing Kings goal, first king on files a-d
+	tbSymmetryFull          // mirrors and the diagonal, first king in the a1-d1-d4 triangle
+)
+
+// number of board symmetries by table symmetry
+var tbSymmetries = [...]int{1, 2, 8}
+
+// binomial coefficients, identical pieces are indexed as a combination of squares
+var tbBinomial [65][TABLEBASE_MAX_PIECES+1]int
+
+// Tablebase : distances to the end of the game of all positions of a material configuration
+// positions with castling rights or en passant captures are not covered
+// the fifty move rule is ignored
+type Tablebase struct {
+	Variant  int        // variant
+	Code     string     // material configuration, White's pieces first, e.g. KQvK
+	pieces   []Piece    // pieces in index order
+	sig      uint64     // material signature
+	data     []byte     // values by index
+	symmetry int        // board symmetry, tbSymmetryNone, tbSymmetryMirror or tbSymmetryFull
+	kings    []int      // positions in pieces of the kings, up to two
+	kingSqs  [][]Square // canonical placements of the kings by king index
+	kingIdx  []int      // king index by placement, first king square * 64 + second king square, -1 if not canonical
+	groups   [][2]int   // runs of identical pieces other than kings, first position in pieces and length
+}
+
+// TablebaseResult : result of a probe
+type TablebaseResult struct {
+	Winner Color // side winning, NoColor for a draw
+	Plies  int32 // plies to the end of the game with best play
+}
+
+// directory of the tablebase files, empty if none
+var TablebasePath = ""
+
+// loaded tables by variant and material signature
+var tablebases = map[int]map[uint64]*Tablebase{}
+
+// tbGenerator : generates tables and the tables they depend on
+type tbGenerator struct {
+	threads int
+	tables  map[uint64]*tbGenerated
+	lock    sync.Mutex
+	report  func(code string)
+}
+
+// tbGenerated : a table generated once
+type tbGenerated struct {
+	once  sync.Once
+	table *Tablebase
+	err   error
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// NewTablebase : creates an empty table for a material configuration of the current variant
+// -> code string : material configuration, White's pieces, a v and Black's pieces, e.g. KQvK
+// <- *Tablebase : table
+// <- error : error
+
+func NewTablebase(code string) (*Tablebase, error) {
+	parts := strings.Split(code, "v")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid material configuration %s", code)
+	}
+	tb := &Tablebase{Variant: Variant}
+	pos := NewPosition()
+	sq := SquareA1
+	for i, part := range parts {
+		col := White
+		if i == 1 {
+			col = Black
+		}
+		for _, symbol := range part {
+			fig := symbolToFigure[symbol]
+			if fig == NoFigure {
+				return nil, fmt.Errorf("invalid material configuration %s", code)
+			}
+			tb.pieces = append(tb.pieces, ColorFigure(col, fig))
+			pos.Put(sq, ColorFigure(col, fig))
+			sq++
+		}
+	}
+	if ( len(tb.pieces) < 1 ) || ( len(tb.pieces) > TABLEBASE_MAX_PIECES ) {
+		return nil, fmt.Errorf("tables have 1 to %d pieces", TABLEBASE_MAX_PIECES)
+	}
+	tb.sig = pos.MaterialSignature()
+	tb.Code = tablebaseCode(tb.sig)
+	tb.pieces = tablebasePieces(tb.sig)
+	tb.layout()
+	return tb, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// init : computes the binomial coefficients
+
+func init() {
+	for n := range tbBinomial {
+		tbBinomial[n][0] = 1
+		for k := 1; ( k <= n ) && ( k <= TABLEBASE_MAX_PIECES ); k++ {
+			tbBinomial[n][k] = tbBinomial[n-1][k-1]
+			if k < n {
+				tbBinomial[n][k] += tbBinomial[n-1][k]
+			}
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// layout : sets up the index of the table
+// the kings are indexed together in their canonical placements, the other pieces by runs of identical pieces
+// the symmetry does not depend on the current variant since the tables of all variants can be loaded
+// -> tb *Tablebase : table
+
+func (tb *Tablebase) layout() {
+	pawns := false
+	tb.kings, tb.groups = nil, nil
+	for i := 0; i < len(tb.pieces); {
+		pi := tb.pieces[i]
+		pawns = pawns || ( pi.Figure() == Pawn )
+		if pi.Figure() == King {
+			tb.kings = append(tb.kings, i)
+			i++
+			continue
+		}
+		n := 1
+		for ( i+n < len(tb.pieces) ) && ( tb.pieces[i+n] == pi ) {
+			n++
+		}
+		tb.groups = append(tb.groups, [2]int{i, n})
+		i += n
+	}
+	switch {
+		case len(tb.kings) == 0: tb.symmetry = tbSymmetryNone
+		case pawns || ( tb.Variant == VARIANT_Racing_Kings ): tb.symmetry = tbSymmetryMirror
+		default: tb.symmetry = tbSymmetryFull
+	}
+
+	tb.kingSqs = [][]Square{}
+	tb.kingIdx = make([]int, 64*64)
+	for i := range tb.kingIdx {
+		tb.kingIdx[i] = -1
+	}
+	if len(tb.kings) == 0 {
+		tb.kingSqs = append(tb.kingSqs, []Square{})
+		return
+	}
+	for first := SquareA1; first <= SquareH8; first++ {
+		seconds := []Square{first}
+		if len(tb.kings) == 2 {
+			seconds = []Square{}
+			for second := SquareA1; second <= SquareH8; second++ {
+				if second != first {
+					seconds = append(seconds, second)
+				}
+			}
+		}
+		for _, second := range seconds {
+			if !tb.canonical(first, second) {
+				continue
+			}
+			tb.kingIdx[int(first)*64+int(second)] = len(tb.kingSqs)
+			sqs := []Square{first}
+			if len(tb.kings) == 2 {
+				sqs = append(sqs, second)
+			}
+			tb.kingSqs = append(tb.kingSqs, sqs)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// canonical : tells whether a placement of the kings is in the canonical region of the symmetry
+// with the first king on the diagonal the second king has to be on or below it
+// -> tb *Tablebase : table
+// -> first Square : square of the first king
+// -> second Square : square of the second king, first if there is one king only
+// <- bool : true if canonical
+
+func (tb *Tablebase) canonical(first, second Square) bool {
+	switch tb.symmetry {
+		case tbSymmetryMirror:
+			return first.File() <= 3
+		case tbSymmetryFull:
+			if ( first.File() > 3 ) || ( first.Rank() > first.File() ) {
+				return false
+			}
+			return ( first.Rank() != first.File() ) || ( second.Rank() <= second.File() )
+	}
+	return true
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// tbTransform : applies a symmetry of the board to a square
+// -> sq Square : square
+// -> t int : symmetry, bit 0 mirrors the files, bit 1 the ranks, bit 2 flips the a1-h8 diagonal
+// <- Square : square
+
+func tbTransform(sq Square, t int) Square {
+	if t&1 != 0 {
+		sq ^= 7
+	}
+	if t&2 != 0 {
+		sq ^= 56
+	}
+	if t&4 != 0 {
+		sq = sq>>3 | ( sq&7 )<<3
+	}
+	return sq
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// tablebaseCode : returns the canonical material configuration of a signature
+// -> sig uint64 : material signature
+// <- string : configuration, e.g. KQvK
+
+func tablebaseCode(sig uint64) string {
+	code := ""
+	for _, pi := range tablebasePieces(sig) {
+		if ( pi.Color() == Black ) && !strings.Contains(code, "v") {
+			code += "v"
+		}
+		code += figureToSymbol[pi.Figure()]
+	}
+	if !strings.Contains(code, "v") {
+		code += "v"
+	}
+	return code
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// tablebasePieces : returns the pieces of a signature in index order
+// White's pieces then Black's, from the king down to the pawns
+// -> sig uint64 : material signature
+// <- []Piece : pieces
+
+func tablebasePieces(sig uint64) []Piece {
+	pieces := []Piece{}
+	for _, col := range []Color{White, Black} {
+		for fig := FigureMaxValue; fig >= FigureMinValue; fig-- {
+			for n := MaterialCount(sig, col, fig); n > 0; n-- {
+				pieces = append(pieces, ColorFigure(col, fig))
+			}
+		}
+	}
+	return pieces
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// size : returns the number of positions of the table
+// -> tb *Tablebase : table
+// <- int : size
+
+func (tb *Tablebase) size() int {
+	size := 2 * len(tb.kingSqs)
+	for _, group := range tb.groups {
+		size *= tbBinomial[64][group[1]]
+	}
+	return size
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// index : returns the index of a position of the table
+// the board is turned so that the kings are in their canonical region
+// identical pieces are indexed as the combination of their squares
+// -> tb *Tablebase : table
+// -> pos *Position : position with the material of the table
+// <- int : index
+
+func (tb *Tablebase) index(pos *Position) int {
+	kings := []Square{}
+	for _, i := range tb.kings {
+		pi := tb.pieces[i]
+		kings = append(kings, pos.ByPiece(pi.Color(), King).AsSquare())
+	}
+	kingIndex := 0
+	t := 0
+	for ; t < tbSymmetries[tb.symmetry]; t++ {
+		if len(kings) == 0 {
+			break
+		}
+		first, second := tbTransform(kings[0], t), tbTransform(kings[len(kings)-1], t)
+		if kingIndex = tb.kingIdx[int(first)*64+int(second)]; kingIndex >= 0 {
+			break
+		}
+	}
+
+	index, radix := kingIndex, len(tb.kingSqs)
+	for _, group := range tb.groups {
+		pi := tb.pieces[group[0]]
+		sqs := []int{}
+		for bb := pos.ByPiece(pi.Color(), pi.Figure()); bb != 0; {
+			sqs = append(sqs, int(tbTransform(bb.Pop(), t)))
+		}
+		sort.Ints(sqs)
+		combination := 0
+		for k, sq := range sqs {
+			combination += tbBinomial[sq][k+1]
+		}
+		index += radix * combination
+		radix *= tbBinomial[64][group[1]]
+	}
+	index *= 2
+	if pos.SideToMove == Black {
+		index |= 1
+	}
+	return index
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// position : returns the position of an index
+// -> tb *Tablebase : table
+// -> index int : index
+// <- *Position : position, nil if the placement is illegal
+
+func (tb *Tablebase) position(index int) *Position {
+	us := White
+	if index&1 != 0 {
+		us = Black
+	}
+	index >>= 1
+	sqs := make([]Square, len(tb.pieces))
+	for k, i := range tb.kings {
+		sqs[i] = tb.kingSqs[index%len(tb.kingSqs)][k]
+	}
+	index /= len(tb.kingSqs)
+	for _, group := range tb.groups {
+		combinations := tbBinomial[64][group[1]]
+		combination := index % combinations
+		index /= combinations
+		// the largest square first
+		sq := 63
+		for k := group[1]; k > 0; k-- {
+			for tbBinomial[sq][k] > combination {
+				sq--
+			}
+			combination -= tbBinomial[sq][k]
+			sqs[group[0]+k-1] = Square(sq)
+			sq--
+		}
+	}
+
+	pos := NewPosition()
+	for i, pi := range tb.pieces {
+		sq := sqs[i]
+		if pos.Get(sq) != NoPiece {
+			return nil
+		}
+		if pi.Figure() == Pawn {
+			// pawns never stand on their promotion rank, nor on their first rank outside horde
+			rank := sq.POV(pi.Color()).Rank()
+			if ( rank == 7 ) || ( ( rank == 0 ) && !( IS_Horde && ( pi.Color() == HORDE_Pawns_Side ) ) ) {
+				return nil
+			}
+		}
+		pos.Put(sq, pi)
+	}
+	pos.SetSideToMove(us)
+	if _, over := pos.Terminal(); !over {
+		if pos.IsChecked(us.Opposite()) {
+			// the side which moved last cannot be in check
+			return nil
+		}
+		if IS_Racing_Kings && pos.IsCheckedLocal(us) {
+			// giving check is illegal in racing kings
+			return nil
+		}
+	}
+	return pos
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// value : returns the value of a position of the table in generation form
+// -> tb *Tablebase : table
+// -> pos *Position : position
+// <- uint16 : plies to the end of the game, tbDrawn or tbIllegal
+
+func (tb *Tablebase) value(pos *Position) uint16 {
+	switch v := tb.data[tb.index(pos)]; v {
+		case tbDraw: return tbDrawn
+		case tbInvalid: return tbIllegal
+		default: return uint16(v - 1)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// GenerateTablebase : generates the table of a material configuration of the current variant
+// and the tables of all configurations it converts to
+// -> code string : material configuration, e.g. KQvK
+// -> threads int : number of parallel workers, 0 for all cpus
+// -> report func(code string) : called when a table is complete, can be nil
+// <- []*Tablebase : the table and all tables it depends on
+// <- error : error
+
+func GenerateTablebase(code string, threads int, report func(code string)) ([]*Tablebase, error) {
+	tb, err := NewTablebase(code)
+	if err != nil {
+		return nil, err
+	}
+	if threads < 1 {
+		threads = runtime.NumCPU()
+	}
+	g := &tbGenerator{threads: threads, tables: map[uint64]*tbGenerated{}, report: report}
+	if _, err := g.table(tb.sig); err != nil {
+		return nil, err
+	}
+	tables := []*Tablebase{}
+	for _, generated := range g.tables {
+		if generated.table != nil {
+			tables = append(tables, generated.table)
+		}
+	}
+	return tables, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// table : returns the table of a signature, generating it on first use
+// -> g *tbGenerator : generator
+// -> sig uint64 : material signature
+// <- *Tablebase : table
+// <- error : error
+
+func (g *tbGenerator) table(sig uint64) (*Tablebase, error) {
+	g.lock.Lock()
+	generated, found := g.tables[sig]
+	if !found {
+		generated = &tbGenerated{}
+		g.tables[sig] = generated
+	}
+	g.lock.Unlock()
+
+	generated.once.Do(func() {
+		generated.table, generated.err = g.generate(sig)
+		if ( generated.err == nil ) && ( g.report != nil ) {
+			g.report(generated.table.Code)
+		}
+	})
+	return generated.table, generated.err
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// generate : generates the table of a signature
+// positions without moves are resolved first, then pass d resolves the positions ending in d plies
+// a position is a win in d plies if a move leads to a loss in d-1 plies
+// and a loss in d plies if all moves lead to wins in at most d-1 plies
+// the positions left are draws
+// -> g *tbGenerator : generator
+// -> sig uint64 : material signature
+// <- *Tablebase : table
+// <- error : error
+
+func (g *tbGenerator) generate(sig uint64) (*Tablebase, error) {
+	tb, err := NewTablebase(tablebaseCode(sig))
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint16, tb.size())
+	for i := range values {
+		values[i] = tbUnknown
+	}
+
+	// pass 0 finds the illegal and ended positions and generates the tables captures lead to
+	var gerr error
+	var glock sync.Mutex
+	g.parallel(len(values), func(from, to int) {
+		for index := from; index < to; index++ {
+			pos := tb.position(index)
+			if pos == nil {
+				values[index] = tbIllegal
+				continue
+			}
+			v, err := g.resolve(tb, pos, values, 0)
+			if err != nil {
+				glock.Lock()
+				gerr = err
+				glock.Unlock()
+				return
+			}
+			values[index] = v
+		}
+	})
+	if gerr != nil {
+		return nil, gerr
+	}
+
+	subtablePlies := g.maxSubtablePlies(tb)
+	for d := uint16(1); ; d++ {
+		if d > TABLEBASE_MAX_PLIES {
+			return nil, fmt.Errorf("%s: distance exceeds %d plies", tb.Code, TABLEBASE_MAX_PLIES)
+		}
+		// updates are applied after the pass so that workers read stable values
+		updates := make([][]int, g.threads)
+		updated := make([][]uint16, g.threads)
+		pending := make([]bool, g.threads)
+		var worker int
+		var wlock sync.Mutex
+		g.parallel(len(values), func(from, to int) {
+			wlock.Lock()
+			w := worker
+			worker++
+			wlock.Unlock()
+			for index := from; index < to; index++ {
+				if values[index] != tbUnknown {
+					continue
+				}
+				v, _ := g.resolve(tb, tb.position(index), values, d)
+				if v == tbUnknown {
+					pending[w] = true
+					continue
+				}
+				updates[w] = append(updates[w], index)
+				updated[w] = append(updated[w], v)
+			}
+		})
+		changed, unknown := false, false
+		for w := range updates {
+			for i, index := range updates[w] {
+				values[index] = updated[w][i]
+				changed = true
+			}
+			unknown = unknown || pending[w]
+		}
+		if !unknown {
+			break
+		}
+		if !changed && ( d > tbMaxPlies(values) + 1 ) && ( d > subtablePlies + 1 ) {
+			// nothing can be resolved anymore, the rest are draws
+			break
+		}
+	}
+
+	tb.data = make([]byte, len(values))
+	for i, v := range values {
+		switch v {
+			case tbUnknown, tbDrawn: tb.data[i] = tbDraw
+			case tbIllegal: tb.data[i] = tbInvalid
+			default: tb.data[i] = byte(v + 1)
+		}
+	}
+	return tb, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// tbMaxPlies : returns the longest resolved distance
+// -> values []uint16 : values in generation form
+// <- uint16 : plies
+
+func tbMaxPlies(values []uint16) uint16 {
+	longest := uint16(0)
+	for _, v := range values {
+		if ( v < tbIllegal ) && ( v > longest ) {
+			longest = v
+		}
+	}
+	return longest
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// maxSubtablePlies : returns the longest distance of the tables generated so far other than tb
+// -> g *tbGenerator : generator
+// -> tb *Tablebase : table
+// <- uint16 : plies
+
+func (g *tbGenerator) maxSubtablePlies(tb *Tablebase) uint16 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	longest := uint16(0)
+	for sig, generated := range g.tables {
+		if ( sig == tb.sig ) || ( generated.table == nil ) {
+			continue
+		}
+		for _, v := range generated.table.data {
+			if ( v != tbDraw ) && ( v != tbInvalid ) && ( uint16(v - 1) > longest ) {
+				longest = uint16(v - 1)
+			}
+		}
+	}
+	return longest
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// parallel : runs work on the range [0, n) split between the workers
+// -> g *tbGenerator : generator
+// -> n int : size of the range
+// -> work func(from, to int) : work on a chunk
+
+func (g *tbGenerator) parallel(n int, work func(from, to int)) {
+	chunk := ( n + g.threads - 1 ) / g.threads
+	var wg sync.WaitGroup
+	for from := 0; from < n; from += chunk {
+		to := from + chunk
+		if to > n {
+			to = n
+		}
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			work(from, to)
+		}(from, to)
+	}
+	wg.Wait()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// resolve : computes the value of a position from the values of its successors
+// values of at most d-1 plies are final when pass d runs, so a win is only accepted up to d plies
+// unless all successors are known
+// -> g *tbGenerator : generator
+// -> tb *Tablebase : table being generated
+// -> pos *Position : position
+// -> values []uint16 : values of the table being generated
+// -> d uint16 : pass
+// <- uint16 : value, tbUnknown if not resolved yet
+// <- error : error of a table captures lead to
+
+func (g *tbGenerator) resolve(tb *Tablebase, pos *Position, values []uint16, d uint16) (uint16, error) {
+	us := pos.SideToMove
+	// the terminal rules have to be checked before generating moves
+	if winner, over := pos.Terminal(); over {
+		switch winner {
+			case NoColor: return tbDrawn, nil
+			case us: return tbIllegal, nil
+			default: return 0, nil
+		}
+	}
+	moves := pos.GetLegalMoves(GET_ALL)
+	if len(moves) == 0 {
+		if pos.IsChecked(us) {
+			return 0, nil
+		}
+		return tbDrawn, nil
+	}
+
+	bestWin, worstLoss := uint16(tbUnknown), uint16(0)
+	unknown, drawn := false, false
+	for _, move := range moves {
+		pos.DoMove(move)
+		v, err := g.successor(tb, pos, values, d)
+		pos.UndoMove()
+		if err != nil {
+			return tbUnknown, err
+		}
+		switch {
+			case v == tbUnknown: unknown = true
+			case v == tbDrawn: drawn = true
+			case v == tbIllegal:
+			case v%2 == 0:
+				// the opponent loses
+				if v+1 < bestWin {
+					bestWin = v + 1
+				}
+			default:
+				if v+1 > worstLoss {
+					worstLoss = v + 1
+				}
+		}
+	}
+	if bestWin != tbUnknown {
+		if !unknown || ( bestWin <= d ) {
+			return bestWin, nil
+		}
+		return tbUnknown, nil
+	}
+	if unknown {
+		return tbUnknown, nil
+	}
+	if drawn {
+		return tbDrawn, nil
+	}
+	return worstLoss, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// successor : returns the value of a position reached by a move
+// -> g *tbGenerator : generator
+// -> tb *Tablebase : table being generated
+// -> pos *Position : position
+// -> values []uint16 : values of the table being generated
+// -> d uint16 : pass
+// <- uint16 : value
+// <- error : error
+
+func (g *tbGenerator) successor(tb *Tablebase, pos *Position, values []uint16, d uint16) (uint16, error) {
+	if winner, over := pos.Terminal(); over {
+		if winner == NoColor {
+			return tbDrawn, nil
+		}
+		return 0, nil
+	}
+	if pos.curr.EnpassantSquare[0] != SquareA1 {
+		// the tables do not cover en passant, look one ply further
+		return g.resolve(tb, pos, values, d)
+	}
+	sig := pos.MaterialSignature()
+	if sig == tb.sig {
+		if d == 0 {
+			return tbUnknown, nil
+		}
+		return values[tb.index(pos)], nil
+	}
+	sub, err := g.table(sig)
+	if err != nil {
+		return tbUnknown, err
+	}
+	return sub.value(pos), nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Probe : returns the result of a position of the table
+// -> tb *Tablebase : table
+// -> pos *Position : position with the material of the table
+// <- TablebaseResult : result
+// <- bool : false if the position is not covered
+
+func (tb *Tablebase) Probe(pos *Position) (TablebaseResult, bool) {
+	if ( pos.CastlingAbility() != NoCastle ) || ( pos.curr.EnpassantSquare[0] != SquareA1 ) {
+		return TablebaseResult{}, false
+	}
+	v := tb.data[tb.index(pos)]
+	switch {
+		case v == tbInvalid: return TablebaseResult{}, false
+		case v == tbDraw: return TablebaseResult{Winner: NoColor}, true
+		case ( v - 1 ) % 2 == 1: return TablebaseResult{Winner: pos.SideToMove, Plies: int32(v - 1)}, true
+		default: return TablebaseResult{Winner: pos.SideToMove.Opposite(), Plies: int32(v - 1)}, true
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// FileName : returns the file name of the table
+// -> tb *Tablebase : table
+// <- string : file name
+
+func (tb *Tablebase) FileName() string {
+	return TABLEBASE_VARIANT_NAMES[tb.Variant] + "-" + tb.Code + ".vtb"
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Write : writes the table, a header followed by the zlib compressed values
+// -> tb *Tablebase : table
+// -> w io.Writer : writer
+// <- error : error
+
+func (tb *Tablebase) Write(w io.Writer) error {
+	header := []byte(TABLEBASE_MAGIC)
+	header = append(header, byte(tb.Variant), byte(len(tb.Code)))
+	header = append(header, tb.Code...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(tb.data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	zw := zlib.NewWriter(w)
+	if _, err := zw.Write(tb.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ReadTablebase : reads a table written by Write
+// -> r io.Reader : reader
+// <- *Tablebase : table
+// <- error : error
+
+func ReadTablebase(r io.Reader) (*Tablebase, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(TABLEBASE_MAGIC)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(TABLEBASE_MAGIC)]) != TABLEBASE_MAGIC {
+		return nil, fmt.Errorf("not a tablebase file")
+	}
+	variant := int(header[len(TABLEBASE_MAGIC)])
+	if variant >= len(TABLEBASE_VARIANT_NAMES) {
+		return nil, fmt.Errorf("unknown variant %d", variant)
+	}
+	code := make([]byte, header[len(TABLEBASE_MAGIC)+1])
+	var size uint32
+	if _, err := io.ReadFull(br, code); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+
+	// the index depends on the code and the variant of the table, not on the current variant
+	tb, err := NewTablebase(string(code))
+	if err != nil {
+		return nil, err
+	}
+	tb.Variant = variant
+	tb.layout()
+	if int(size) != tb.size() {
+		return nil, fmt.Errorf("%s: size %d, expected %d", tb.Code, size, tb.size())
+	}
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	tb.data = make([]byte, size)
+	if _, err := io.ReadFull(zr, tb.data); err != nil {
+		return nil, fmt.Errorf("%s: %v", tb.Code, err)
+	}
+	return tb, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// SaveTablebases : writes tables to a directory
+// -> dir string : directory
+// -> tables []*Tablebase : tables
+// <- error : error
+
+func SaveTablebases(dir string, tables []*Tablebase) error {
+	for _, tb := range tables {
+		f, err := os.Create(filepath.Join(dir, tb.FileName()))
+		if err != nil {
+			return err
+		}
+		err = tb.Write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadTablebases : loads all tables of a directory, replacing the loaded ones
+// -> dir string : directory, empty to unload the tables
+// <- int : number of tables loaded
+// <- error : error
+
+func LoadTablebases(dir string) (int, error) {
+	loaded := map[int]map[uint64]*Tablebase{}
+	num := 0
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.vtb"))
+		if err != nil {
+			return 0, err
+		}
+		for _, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				return 0, err
+			}
+			tb, err := ReadTablebase(f)
+			f.Close()
+			if err != nil {
+				return 0, fmt.Errorf("%s: %v", path, err)
+			}
+			if loaded[tb.Variant] == nil {
+				loaded[tb.Variant] = map[uint64]*Tablebase{}
+			}
+			loaded[tb.Variant][tb.sig] = tb
+			num++
+		}
+	}
+	tablebases = loaded
+	TablebasePath = dir
+	return num, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// ProbeTablebase : returns the result of a position if a table of the current variant covers it
+// -> pos *Position : position
+// <- TablebaseResult : result
+// <- bool : true if the position was found
+
+func ProbeTablebase(pos *Position) (TablebaseResult, bool) {
+	tables := tablebases[Variant]
+	if len(tables) == 0 {
+		return TablebaseResult{}, false
+	}
+	if ( pos.ByColor[White] | pos.ByColor[Black] ).Count() > TABLEBASE_MAX_PIECES {
+		return TablebaseResult{}, false
+	}
+	tb, found := tables[pos.MaterialSignature()]
+	if !found {
+		return TablebaseResult{}, false
+	}
+	return tb.Probe(pos)
+}
+
+///////////////////////////////////////////////
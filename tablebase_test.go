@@ -0,0 +1,171 @@
+//////////////////////////////////////////////////////
+// tablebase_test.go
+// tests the index of the tables against their positions and generated tables against
+// results known for each variant
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// tablebaseIndexTestCase : a table whose index is checked against its positions
+type tablebaseIndexTestCase struct {
+	variant int    // variant
+	code    string // material configuration
+}
+
+var tablebaseIndexTestCases = []tablebaseIndexTestCase{
+	// two kings, full symmetry
+	{VARIANT_Standard, "KQvK"},
+	// pawns, mirror symmetry
+	{VARIANT_Standard, "KPvK"},
+	// no white king
+	{VARIANT_Horde, "PvK"},
+	// identical pieces as combinations
+	{VARIANT_Horde, "PPvK"},
+	// adjacent kings
+	{VARIANT_Atomic, "KNvK"},
+	// the goal breaks the symmetry of the ranks
+	{VARIANT_Racing_Kings, "KvK"},
+}
+
+// tablebaseResultTestCase : a position of known result
+type tablebaseResultTestCase struct {
+	variant int    // variant
+	code    string // table to generate
+	fen     string // position
+	winner  Color  // side winning, NoColor for a draw
+	plies   int32  // plies to the end of the game, 0 to check the winner only
+}
+
+var tablebaseResultTestCases = []tablebaseResultTestCase{
+	// Qb7 mates, the queen is safe from the king in atomic
+	{VARIANT_Atomic, "KQvK", "k7/8/8/8/8/8/8/1Q2K3 w - - 0 1", White, 1},
+	// the same position is no mate in 1 in standard chess, Kxb7 follows
+	{VARIANT_Standard, "KQvK", "k7/8/8/8/8/8/8/1Q2K3 w - - 0 1", White, 0},
+	// Kh8 reaches the goal and Black cannot follow
+	{VARIANT_Racing_Kings, "KvK", "8/7K/8/8/8/8/8/k7 w - - 0 1", White, 1},
+	// Black follows White to the goal
+	{VARIANT_Racing_Kings, "KvK", "8/K6k/8/8/8/8/8/8 w - - 0 1", NoColor, 0},
+	// the king takes the last pawn of the horde
+	{VARIANT_Horde, "PvK", "8/8/8/8/8/8/6kP/8 b - - 0 1", Black, 1},
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestTablebaseIndex : checks that the index of every legal position of a table is its own
+
+func TestTablebaseIndex(t *testing.T) {
+	for _, tc := range tablebaseIndexTestCases {
+		uci = NewUCI()
+		uci.SetVariant(tc.variant)
+		tb, err := NewTablebase(tc.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		legal := 0
+		for i := 0; i < tb.size(); i++ {
+			pos := tb.position(i)
+			if pos == nil {
+				continue
+			}
+			legal++
+			if index := tb.index(pos); index != i {
+				t.Fatalf("%s %s: %s has index %d, expected %d", VARIANT_TO_NAME[tc.variant], tc.code, pos.String(), index, i)
+			}
+		}
+		if legal == 0 {
+			t.Errorf("%s %s: no legal position", VARIANT_TO_NAME[tc.variant], tc.code)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// tablebaseTestTable : generates a table of a variant
+// -> t *testing.T : test
+// -> variant int : variant
+// -> code string : material configuration
+// <- *Tablebase : table
+
+func tablebaseTestTable(t *testing.T, variant int, code string) *Tablebase {
+	t.Helper()
+	uci = NewUCI()
+	uci.SetVariant(variant)
+	tables, err := GenerateTablebase(code, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tb := range tables {
+		if tb.Code == code {
+			return tb
+		}
+	}
+	t.Fatalf("%s %s: table not generated", VARIANT_TO_NAME[variant], code)
+	return nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestTablebaseLongestMate : checks the longest standard mates, 10 moves for KQvK and 16 for KRvK
+
+func TestTablebaseLongestMate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates tables")
+	}
+	for code, expected := range map[string]int{"KQvK": 19, "KRvK": 31} {
+		tb := tablebaseTestTable(t, VARIANT_Standard, code)
+		longest := 0
+		for _, v := range tb.data {
+			// odd distances are wins for the side to move
+			if plies := int(v) - 1; ( v != tbDraw ) && ( v != tbInvalid ) && ( plies%2 == 1 ) && ( plies > longest ) {
+				longest = plies
+			}
+		}
+		if longest != expected {
+			t.Errorf("%s: longest win %d plies, expected %d", code, longest, expected)
+		}
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestTablebaseResults : probes generated tables on positions of known result
+
+func TestTablebaseResults(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates tables")
+	}
+	for _, tc := range tablebaseResultTestCases {
+		tb := tablebaseTestTable(t, tc.variant, tc.code)
+		pos, err := PositionFromFEN(tc.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, ok := tb.Probe(pos)
+		name := VARIANT_TO_NAME[tc.variant] + " " + tc.fen
+		switch {
+			case !ok:
+				t.Errorf("%s: not covered by %s", name, tc.code)
+			case result.Winner != tc.winner:
+				t.Errorf("%s: winner %v, expected %v", name, result.Winner, tc.winner)
+			case ( tc.plies != 0 ) && ( result.Plies != tc.plies ):
+				t.Errorf("%s: %d plies, expected %d", name, result.Plies, tc.plies)
+			case ( tc.plies == 0 ) && ( tc.winner != NoColor ) && ( result.Plies <= 1 ):
+				t.Errorf("%s: %d plies, expected more than 1", name, result.Plies)
+		}
+	}
+}
+
+///////////////////////////////////////////////
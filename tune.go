@@ -0,0 +1,390 @@
+//////////////////////////////////////////////////////
+// tune.go
+// implements Texel's tuning method for the evaluation parameters
+// https://chessprogramming.wikispaces.com/Texel%27s+Tuning+Method
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// number of opening plies skipped when collecting positions from PGN games
+const TUNE_SKIP_PLIES = 8
+
+// TuneEntry : a labelled position
+type TuneEntry struct {
+	Position *Position // quiet position
+	Result   float64   // game result from White's POV, 1 win, 0.5 draw, 0 loss
+}
+
+// Tuner : tunes the parameters on labelled positions
+type Tuner struct {
+	Entries []TuneEntry // labelled positions
+	Params  []EvalParam // parameters being tuned
+	K       float64     // scaling constant of the sigmoid
+	Threads int         // number of parallel workers
+}
+
+// result in brackets at the end of an EPD line, e.g. [0.5]
+var reEPDResult = regexp.MustCompile(`\[\s*([01](\.\d*)?)\s*\]`)
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadTuneEPD : reads labelled positions from an EPD stream
+// each line starts with the piece placement, side, castling and en passant fields
+// the result is either a PGN result ( c9 "1-0"; ) or White's score in brackets ( [1.0] )
+// -> r io.Reader : reader
+// <- []TuneEntry : labelled positions
+// <- error : error
+
+func LoadTuneEPD(r io.Reader) ([]TuneEntry, error) {
+	entries := []TuneEntry{}
+	scanner := bufio.NewScanner(r)
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+
+		result, found := 0.0, false
+		for _, pgnresult := range []string{"1/2-1/2", "1-0", "0-1"} {
+			if strings.Contains(line, pgnresult) {
+				result, found = PGNResultScore(pgnresult)
+				break
+			}
+		}
+		if !found {
+			match := reEPDResult.FindStringSubmatch(line)
+			if match == nil {
+				return nil, fmt.Errorf("line %d: missing result", num)
+			}
+			result, _ = strconv.ParseFloat(match[1], 64)
+		}
+
+		pos, err := PositionFromFEN(strings.Join(fields[:4], " ") + " 0 1")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", num, err)
+		}
+		entries = append(entries, TuneEntry{Position: pos, Result: result})
+	}
+	return entries, scanner.Err()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// LoadTunePGN : collects labelled positions from the games of the current variant
+// opening plies and positions with the side to move in check are skipped
+// -> r io.Reader : reader
+// -> skipPlies int : number of opening plies to skip
+// <- []TuneEntry : labelled positions
+// <- error : error
+
+func LoadTunePGN(r io.Reader, skipPlies int) ([]TuneEntry, error) {
+	games, err := ReadPGN(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := []TuneEntry{}
+	for i, game := range games {
+		variant, ok := game.Variant()
+		if !ok || ( variant != Variant ) {
+			continue
+		}
+		result, ok := PGNResultScore(game.Result)
+		if !ok {
+			continue
+		}
+		ply := 0
+		err := game.Replay(func(pos *Position, move Move) bool {
+			ply++
+			if ( ply > skipPlies ) && !pos.IsChecked(pos.SideToMove) {
+				if copied, err := PositionFromFEN(pos.String()); err == nil {
+					entries = append(entries, TuneEntry{Position: copied, Result: result})
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("game %d: %v", i+1, err)
+		}
+	}
+	return entries, nil
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// NewTuner : creates a tuner for the current variant
+// -> entries []TuneEntry : labelled positions
+// <- *Tuner : tuner
+
+func NewTuner(entries []TuneEntry) *Tuner {
+	return &Tuner{
+		Entries: entries,
+		Params:  EvalParams(),
+		K:       1,
+		Threads: runtime.NumCPU(),
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// parallel : runs work on all entries split between the workers
+// Evaluate shares the evaluation caches of the package, which are not safe for parallel use,
+// so they are disabled meanwhile and restored afterwards
+// -> t *Tuner : tuner
+// -> work func(worker int, from int, entries []TuneEntry) : work on a chunk starting at index from
+
+func (t *Tuner) parallel(work func(worker int, from int, entries []TuneEntry)) {
+	cache := disableCache
+	disableCache = true
+	defer func() {
+		disableCache = cache
+		// the cached evaluations are stale if the parameters changed
+		clearEvalCaches()
+	}()
+
+	chunk := ( len(t.Entries) + t.threads() - 1 ) / t.threads()
+	var wg sync.WaitGroup
+	for from := 0; from < len(t.Entries); from += chunk {
+		to := from + chunk
+		if to > len(t.Entries) {
+			to = len(t.Entries)
+		}
+		wg.Add(1)
+		go func(worker int, from int, entries []TuneEntry) {
+			defer wg.Done()
+			work(worker, from, entries)
+		}(from/chunk, from, t.Entries[from:to])
+	}
+	wg.Wait()
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// threads : number of workers, at least one
+// -> t *Tuner : tuner
+// <- int : number of workers
+
+func (t *Tuner) threads() int {
+	if t.Threads < 1 {
+		return 1
+	}
+	return t.Threads
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Resolve : replaces each position by the end of its quiescence principal variation
+// so that the static evaluation of the tuned position is quiet
+// positions which end the game are dropped
+// -> t *Tuner : tuner
+
+func (t *Tuner) Resolve() {
+	keep := make([]bool, len(t.Entries))
+	t.parallel(func(worker int, from int, entries []TuneEntry) {
+		eng := NewEngine(nil, nil, Options{Private: true})
+		for i := range entries {
+			pos := entries[i].Position
+			eng.SetPosition(pos)
+			eng.rootPly = pos.Ply
+			eng.stack.Reset(pos)
+			score := eng.searchQuiescence(-InfinityScore, InfinityScore)
+			if ( score <= KnownLossScore ) || ( score >= KnownWinScore ) {
+				continue
+			}
+			for _, move := range eng.pvTable.Get(pos) {
+				pos.DoMove(move)
+			}
+			if IS_Atomic && ( pos.IsExploded(White) || pos.IsExploded(Black) ) {
+				continue
+			}
+			// mated and stalemated positions are not in the quiescence scores
+			if len(pos.GetLegalMoves(GET_ALL)) == 0 {
+				continue
+			}
+			if leaf, err := PositionFromFEN(pos.String()); err == nil {
+				entries[i].Position = leaf
+				keep[from+i] = true
+			}
+		}
+	})
+
+	resolved := t.Entries[:0]
+	for i, entry := range t.Entries {
+		if keep[i] {
+			resolved = append(resolved, entry)
+		}
+	}
+	t.Entries = resolved
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Error : mean squared error between the results and the predicted results
+// the prediction is a sigmoid of the static evaluation
+// -> t *Tuner : tuner
+// <- float64 : error
+
+func (t *Tuner) Error() float64 {
+	if len(t.Entries) == 0 {
+		return 0
+	}
+	sums := make([]float64, t.threads())
+	t.parallel(func(worker int, from int, entries []TuneEntry) {
+		sum := 0.0
+		for _, entry := range entries {
+			score := float64(ScaleToCentiPawn(Evaluate(entry.Position)))
+			predicted := 1 / ( 1 + math.Pow(10, -t.K*score/400) )
+			sum += ( entry.Result - predicted ) * ( entry.Result - predicted )
+		}
+		sums[worker] = sum
+	})
+	total := 0.0
+	for _, sum := range sums {
+		total += sum
+	}
+	return total / float64(len(t.Entries))
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// FitK : finds the sigmoid scaling constant which minimizes the error
+// for the current parameters
+// -> t *Tuner : tuner
+// <- float64 : error
+
+func (t *Tuner) FitK() float64 {
+	best := t.Error()
+	for step := 0.5; step > 0.001; step /= 2 {
+		for _, k := range []float64{t.K - step, t.K + step} {
+			if k <= 0 {
+				continue
+			}
+			old := t.K
+			t.K = k
+			if err := t.Error(); err < best {
+				best = err
+			} else {
+				t.K = old
+			}
+		}
+	}
+	return best
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// Tune : minimizes the error by local search
+// each parameter is moved by its step in both directions and kept where the error decreases
+// stops after iterations passes or when a pass brings no improvement
+// -> t *Tuner : tuner
+// -> iterations int : maximum number of passes
+// -> progress func(iteration int, err float64) : called after each pass, can be nil
+// <- float64 : error
+
+func (t *Tuner) Tune(iterations int, progress func(iteration int, err float64)) float64 {
+	best := t.Error()
+	for iteration := 1; iteration <= iterations; iteration++ {
+		improved := false
+		for _, param := range t.Params {
+			old := *param.Value
+			for _, dir := range []int32{1, -1} {
+				*param.Value = old + dir*param.Step
+				initWeights()
+				if err := t.Error(); err < best {
+					best = err
+					improved = true
+					break
+				}
+				*param.Value = old
+				initWeights()
+			}
+		}
+		if progress != nil {
+			progress(iteration, best)
+		}
+		if !improved {
+			break
+		}
+	}
+	return best
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// RunTuner : tunes the current variant on a data file and writes the parameter file
+// files ending with .pgn are read as PGN, .bin as binary records of the data generator, anything else as EPD
+// -> datapath string : labelled positions
+// -> outpath string : parameter file to write
+// -> iterations int : maximum number of local search passes
+// <- error : error
+
+func RunTuner(datapath string, outpath string, iterations int) error {
+	data, err := os.Open(datapath)
+	if err != nil {
+		return err
+	}
+	var entries []TuneEntry
+	if strings.HasSuffix(strings.ToLower(datapath), ".pgn") {
+		entries, err = LoadTunePGN(data, TUNE_SKIP_PLIES)
+	} else if strings.HasSuffix(strings.ToLower(datapath), ".bin") {
+		entries, err = LoadTuneBinary(data)
+	} else {
+		entries, err = LoadTuneEPD(data)
+	}
+	data.Close()
+	if err != nil {
+		return err
+	}
+
+	tuner := NewTuner(entries)
+	fmt.Printf("tuning %s on %d positions with %d threads\n", VARIANT_TO_NAME[Variant], len(entries), tuner.Threads)
+	tuner.Resolve()
+	fmt.Printf("%d quiet positions\n", len(tuner.Entries))
+	err0 := tuner.FitK()
+	fmt.Printf("K %.3f error %.6f\n", tuner.K, err0)
+	tuner.Tune(iterations, func(iteration int, err float64) {
+		fmt.Printf("iteration %d error %.6f\n", iteration, err)
+		// save after each pass so that an interrupted run is not lost
+		if out, cerr := os.Create(outpath); cerr == nil {
+			WriteParams(out)
+			out.Close()
+		}
+	})
+
+	out, err := os.Create(outpath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return WriteParams(out)
+}
+
+///////////////////////////////////////////////
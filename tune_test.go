@@ -0,0 +1,176 @@
+//////////////////////////////////////////////////////
+// tune_test.go
+// tests reading labelled positions, resolving them to quiet positions,
+// fitting the sigmoid scaling and writing the tuned parameter file
+//////////////////////////////////////////////////////
+
+package lib
+
+// imports
+
+import(
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+///////////////////////////////////////////////
+// definitions
+
+// labelled positions in both result notations
+const tuneTestEPD = `rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - c9 "1/2-1/2";
+4k3/8/8/3q4/8/8/8/3RK3 w - - c9 "1-0";
+rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - [0.0]
+
+4k3/8/8/8/8/8/4P3/4K3 w - - [1]
+`
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// tuneTestSetup : sets up Standard and restores the evaluation parameters when the test finishes
+// -> t *testing.T : test
+
+func tuneTestSetup(t *testing.T) {
+	uci = NewUCI()
+	uci.SetVariant(VARIANT_Standard)
+	saved := map[string]int32{}
+	for _, param := range EvalParams() {
+		saved[param.Name] = *param.Value
+	}
+	t.Cleanup(func() {
+		SetParams(saved)
+	})
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestLoadTuneEPD : results are read in both notations, short lines are skipped
+
+func TestLoadTuneEPD(t *testing.T) {
+	tuneTestSetup(t)
+	entries, err := LoadTuneEPD(strings.NewReader(tuneTestEPD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0.5, 1, 0, 1}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Result != want[i] {
+			t.Errorf("entry %d: got result %v, want %v", i, entry.Result, want[i])
+		}
+	}
+
+	if _, err := LoadTuneEPD(strings.NewReader("4k3/8/8/8/8/8/4P3/4K3 w - -\n")); err == nil {
+		t.Errorf("line without a result accepted")
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestTunerResolve : captures are resolved and positions which end the game are dropped
+
+func TestTunerResolve(t *testing.T) {
+	tuneTestSetup(t)
+	entries, err := LoadTuneEPD(strings.NewReader(tuneTestEPD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuner := NewTuner(entries)
+	tuner.Threads = 2
+	tuner.Resolve()
+
+	// the mated position is dropped, the others are kept in order
+	if len(tuner.Entries) != 3 {
+		t.Fatalf("got %d resolved entries, want 3", len(tuner.Entries))
+	}
+	if placement := strings.Fields(tuner.Entries[0].Position.String())[0]; placement != "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR" {
+		t.Errorf("quiet position changed to %s", placement)
+	}
+	if placement := strings.Fields(tuner.Entries[1].Position.String())[0]; strings.Contains(placement, "q") {
+		t.Errorf("hanging queen not captured in %s", placement)
+	}
+	if tuner.Entries[1].Result != 1 {
+		t.Errorf("resolved position lost its result: %v", tuner.Entries[1].Result)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestTunerFitK : fitting the scaling constant does not increase the error
+
+func TestTunerFitK(t *testing.T) {
+	tuneTestSetup(t)
+	entries, err := LoadTuneEPD(strings.NewReader(tuneTestEPD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuner := NewTuner(entries)
+	tuner.Resolve()
+	before := tuner.Error()
+	after := tuner.FitK()
+	if after > before {
+		t.Errorf("FitK raised the error from %f to %f", before, after)
+	}
+	if tuner.K <= 0 {
+		t.Errorf("got K %f, want a positive constant", tuner.K)
+	}
+	if err := tuner.Error(); err != after {
+		t.Errorf("FitK returned error %f, the fitted K gives %f", after, err)
+	}
+}
+
+///////////////////////////////////////////////
+
+///////////////////////////////////////////////
+// TestRunTuner : the tuner writes a parameter file which reads back complete
+// and leaves a caller's disabled caches disabled
+
+func TestRunTuner(t *testing.T) {
+	tuneTestSetup(t)
+	dir := t.TempDir()
+	datapath := filepath.Join(dir, "data.epd")
+	outpath := filepath.Join(dir, "params.json")
+	if err := os.WriteFile(datapath, []byte(tuneTestEPD), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := disableCache
+	disableCache = true
+	defer func() {
+		disableCache = cache
+	}()
+	if err := RunTuner(datapath, outpath, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !disableCache {
+		t.Errorf("the tuner enabled the caches disabled by its caller")
+	}
+
+	out, err := os.Open(outpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	values, err := ReadParams(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, param := range EvalParams() {
+		if value, ok := values[param.Name]; !ok || ( value != *param.Value ) {
+			t.Errorf("%s: written %d, tuned %d", param.Name, value, *param.Value)
+		}
+	}
+
+	if err := RunTuner(filepath.Join(dir, "missing.epd"), outpath, 1); err == nil {
+		t.Errorf("missing data file accepted")
+	}
+}
+
+///////////////////////////////////////////////